@@ -0,0 +1,151 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/spf13/cobra"
+)
+
+// NewAliasCommand returns the cobra command managing the commands exported
+// to the managed bin directory
+func NewAliasCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alias",
+		Short: "Manage the exported commands and their aliases",
+		Long: `Manage the commands exported to the managed bin directory, see cpak env.
+
+Each binary of the installed applications is exported as a command named after
+it. When several applications export the same name, the first one installed
+provides the command, an alias chooses another one or pins its version.`,
+	}
+
+	cmd.AddCommand(NewAliasSetCommand())
+	cmd.AddCommand(NewAliasListCommand())
+	cmd.AddCommand(NewAliasRemoveCommand())
+	return cmd
+}
+
+func NewAliasSetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set NAME REMOTE[@VERSION] [-- ARGS...]",
+		Short: "Set a command to run a binary of an application",
+		Long: `Set a command to run a binary of an application, with the given default
+arguments. VERSION is the version, branch, release or commit to pin, the most
+recently installed version is run otherwise.
+
+Examples:
+  cpak alias set python github.com/example/python@3.12
+  cpak alias set py github.com/example/python --binary python3 -- -X utf8`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: SetAlias,
+	}
+	cmd.Flags().String("binary", "", "The binary to run, by name or path, defaults to the one named after the alias")
+	return cmd
+}
+
+func NewAliasListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the exported commands and their alternatives",
+		Args:    cobra.NoArgs,
+		RunE:    ListAliases,
+	}
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+	return cmd
+}
+
+func NewAliasRemoveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "rm NAME",
+		Aliases: []string{"remove"},
+		Short:   "Remove an alias, restoring the exported command",
+		Args:    cobra.ExactArgs(1),
+		RunE:    RemoveAlias,
+	}
+	return cmd
+}
+
+func aliasError(iErr error) (err error) {
+	err = fmt.Errorf("an error occurred while managing the aliases: %s", iErr)
+	return
+}
+
+func SetAlias(cmd *cobra.Command, args []string) error {
+	binary, _ := cmd.Flags().GetString("binary")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return aliasError(err)
+	}
+
+	err = c.SetAlias(args[0], args[1], binary, args[2:])
+	if err != nil {
+		return aliasError(err)
+	}
+	return nil
+}
+
+func ListAliases(cmd *cobra.Command, args []string) error {
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return aliasError(err)
+	}
+
+	commands, err := c.GetCommands()
+	if err != nil {
+		return aliasError(err)
+	}
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(commands, "", "  ")
+		if err != nil {
+			return aliasError(err)
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	header := []string{"Name", "Remote", "Binary", "Args", "Alias", "Alternatives"}
+	data := [][]string{}
+	for _, command := range commands {
+		alias := ""
+		if command.Alias {
+			alias = "yes"
+		}
+		data = append(data, []string{
+			command.Name,
+			command.Origin,
+			command.Binary,
+			strings.Join(command.Args, " "),
+			alias,
+			strings.Join(command.Alternatives, ", "),
+		})
+	}
+	tools.ShowTable(header, data)
+	return nil
+}
+
+func RemoveAlias(cmd *cobra.Command, args []string) error {
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return aliasError(err)
+	}
+
+	err = c.RemoveAlias(args[0])
+	if err != nil {
+		return aliasError(err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/spf13/cobra"
+)
+
+// NewAppServiceCommand returns the cobra command managing the services of
+// the installed applications
+func NewAppServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service",
+		Short: "Manage the services of the applications",
+		Long: `Manage the services of the applications, long-running binaries exported as
+systemd user units. Enabled services are started along with the user session,
+services activated through D-Bus are started on demand anyway.`,
+	}
+
+	cmd.AddCommand(newAppServiceActionCommand("enable", "Enable and start the services of an application", EnableAppServices))
+	cmd.AddCommand(newAppServiceActionCommand("disable", "Stop and disable the services of an application", DisableAppServices))
+	cmd.AddCommand(NewAppServiceStatusCommand())
+	return cmd
+}
+
+func newAppServiceActionCommand(action string, short string, runE func(*cobra.Command, []string) error) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   action + " <remote> [service...]",
+		Short: short,
+		Long:  short + ", all of them if none is given.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runE,
+	}
+	addAppServiceFlags(cmd)
+	return cmd
+}
+
+func NewAppServiceStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status <remote>",
+		Short: "Show the state of the services of an application",
+		Args:  cobra.ExactArgs(1),
+		RunE:  ShowAppServicesStatus,
+	}
+	addAppServiceFlags(cmd)
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+	return cmd
+}
+
+func addAppServiceFlags(cmd *cobra.Command) {
+	cmd.Flags().String("version", "", "Specify a version")
+	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
+	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
+	cmd.Flags().StringP("release", "r", "", "Specify a release")
+}
+
+func appServiceError(iErr error) (err error) {
+	err = fmt.Errorf("an error occurred while managing the services: %s", iErr)
+	return
+}
+
+func EnableAppServices(cmd *cobra.Command, args []string) error {
+	return manageAppServices(cmd, args, (*cpak.Cpak).EnableServices)
+}
+
+func DisableAppServices(cmd *cobra.Command, args []string) error {
+	return manageAppServices(cmd, args, (*cpak.Cpak).DisableServices)
+}
+
+func manageAppServices(cmd *cobra.Command, args []string, action func(*cpak.Cpak, string, string, string, string, string, []string) error) error {
+	version, _ := cmd.Flags().GetString("version")
+	branch, _ := cmd.Flags().GetString("branch")
+	commit, _ := cmd.Flags().GetString("commit")
+	release, _ := cmd.Flags().GetString("release")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return appServiceError(err)
+	}
+
+	err = action(&c, strings.ToLower(args[0]), version, branch, commit, release, args[1:])
+	if err != nil {
+		return appServiceError(err)
+	}
+	return nil
+}
+
+func ShowAppServicesStatus(cmd *cobra.Command, args []string) error {
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+	version, _ := cmd.Flags().GetString("version")
+	branch, _ := cmd.Flags().GetString("branch")
+	commit, _ := cmd.Flags().GetString("commit")
+	release, _ := cmd.Flags().GetString("release")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return appServiceError(err)
+	}
+
+	statuses, err := c.GetServicesStatus(strings.ToLower(args[0]), version, branch, commit, release)
+	if err != nil {
+		return appServiceError(err)
+	}
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return appServiceError(err)
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	header := []string{"Service", "Unit", "Enabled", "Active", "D-Bus names"}
+	data := [][]string{}
+	for _, status := range statuses {
+		data = append(data, []string{status.Name, status.Unit, status.Enabled, status.Active, strings.Join(status.BusNames, ", ")})
+	}
+	tools.ShowTable(header, data)
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/spf13/cobra"
+)
+
+func NewAuditLogCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit-log",
+		Short: "Show the sandbox-crossing events of the cpak applications",
+		Long: `Show the events which crossed the sandbox of the cpak applications: host
+commands, nested runs, override changes and the mounts granted to the
+containers, along with the decision taken for each of them.
+
+Times can be given in RFC3339 format, as a date (2006-01-02) or relative to
+now (30m, 24h, 7d).`,
+		Args: cobra.NoArgs,
+		RunE: ShowAuditLog,
+	}
+
+	cmd.Flags().String("app", "", "Only show the events of the given application (origin or id)")
+	cmd.Flags().String("type", "", "Only show the events of the given type (hostexec, nested-run, override, mount)")
+	cmd.Flags().String("since", "", "Only show the events newer than the given time")
+	cmd.Flags().String("until", "", "Only show the events older than the given time")
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+
+	return cmd
+}
+
+func ShowAuditLog(cmd *cobra.Command, args []string) (err error) {
+	appFlag, _ := cmd.Flags().GetString("app")
+	typeFlag, _ := cmd.Flags().GetString("type")
+	sinceFlag, _ := cmd.Flags().GetString("since")
+	untilFlag, _ := cmd.Flags().GetString("until")
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	filter := cpak.AuditLogFilter{Type: typeFlag}
+	filter.Since, err = parseAuditTime(sinceFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --since value: %w", err)
+	}
+	filter.Until, err = parseAuditTime(untilFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --until value: %w", err)
+	}
+
+	cpk, err := cpak.NewCpak()
+	if err != nil {
+		return err
+	}
+
+	// the events are keyed by application id, so origins are resolved to
+	// the ids of their installed versions, anything else is taken as an id
+	// since removed applications can still be in the log
+	appNames := map[string]string{}
+	store, err := cpak.NewStore(cpk.Options.StorePath)
+	if err != nil {
+		return fmt.Errorf("failed to open store: %w", err)
+	}
+	apps, err := store.GetApplications()
+	store.Close()
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		appNames[app.CpakId] = app.Origin
+		if appFlag != "" && app.Origin == appFlag {
+			filter.AppIds = append(filter.AppIds, app.CpakId)
+		}
+	}
+	if appFlag != "" && len(filter.AppIds) == 0 {
+		filter.AppIds = []string{appFlag}
+	}
+
+	events, err := cpak.ReadAuditLog(filter)
+	if err != nil {
+		return err
+	}
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	header := []string{"Time", "App", "Container", "Type", "Decision", "Detail"}
+	data := [][]string{}
+	for _, event := range events {
+		app := event.AppId
+		if origin, ok := appNames[app]; ok {
+			app = origin
+		} else if app == "" {
+			app = "host"
+		}
+		data = append(data, []string{
+			event.Time.Format(time.RFC3339),
+			app,
+			event.ContainerId,
+			event.Type,
+			event.Decision,
+			event.Detail,
+		})
+	}
+	tools.ShowTable(header, data)
+	return nil
+}
+
+// parseAuditTime parses an absolute time or one relative to now, an empty
+// value returns the zero time.
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+
+	// time.ParseDuration has no days unit, so it is handled here
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+		}
+		return time.Now().AddDate(0, 0, -n), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+	}
+	return time.Now().Add(-duration), nil
+}
@@ -0,0 +1,60 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+func NewCommitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "commit <container|remote>",
+		Short: "Commit the persistent changes of a cpak application",
+		Long: `Commit the changes made by a persistent container into a new layer,
+installed as a derived local application on top of the original one. When a
+remote is given, the most recent persistent container of that application is
+used. The running containers of the application are stopped.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: CommitPackage,
+	}
+	cmd.Flags().String("version", "", "Specify a version")
+	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
+	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
+	cmd.Flags().StringP("release", "r", "", "Specify a release")
+
+	return cmd
+}
+
+func CommitPackage(cmd *cobra.Command, args []string) (err error) {
+	ref := args[0]
+
+	version, _ := cmd.Flags().GetString("version")
+	branch, _ := cmd.Flags().GetString("branch")
+	commit, _ := cmd.Flags().GetString("commit")
+	release, _ := cmd.Flags().GetString("release")
+
+	cpk, err := cpak.NewCpak()
+	if err != nil {
+		return err
+	}
+
+	app, container, err := cpk.GetPersistentContainer(ref, version, branch, commit, release)
+	if err != nil {
+		return err
+	}
+
+	derived, err := cpk.Commit(app, container)
+	if err != nil {
+		return fmt.Errorf("an error occurred while committing the cpak container: %s", err)
+	}
+
+	logger.Printf("Changes committed, run them with: cpak run %s", derived.Origin)
+	return nil
+}
@@ -0,0 +1,196 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+func NewDeviceAttachCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "device-attach NODE...",
+		Short:  "Binds host device nodes in the current container (internal)",
+		Args:   cobra.MinimumNArgs(1),
+		RunE:   runDeviceAttach,
+		Hidden: true,
+	}
+
+	cmd.Flags().Int("init-pid", 0, "Pid of the container main process, whose mount namespace gets the device nodes")
+	cmd.Flags().Bool("detach", false, "Unbind the device nodes instead")
+	cmd.MarkFlagRequired("init-pid")
+
+	return cmd
+}
+
+// runDeviceAttach is run by the cpak service in the user namespace of a
+// container, while still in the host mount namespace. Each host device node
+// is cloned as a detached mount, then moved in the container mount
+// namespace, so that the container never sees anything else of /dev.
+func runDeviceAttach(cmd *cobra.Command, args []string) error {
+	initPid, _ := cmd.Flags().GetInt("init-pid")
+	detach, _ := cmd.Flags().GetBool("detach")
+
+	nodes := []string{}
+	errs := []error{}
+	for _, node := range args {
+		if filepath.Clean(node) != node || !strings.HasPrefix(node, "/dev/") {
+			errs = append(errs, fmt.Errorf("invalid device node %s", node))
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+
+	clones := map[string]int{}
+	err := enterContainerMountNs(initPid, func() {
+		if detach {
+			return
+		}
+		for _, node := range nodes {
+			fd, cloneErr := cloneDevice(node)
+			if cloneErr != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", node, cloneErr))
+				continue
+			}
+			clones[node] = fd
+		}
+	})
+	defer func() {
+		for _, fd := range clones {
+			unix.Close(fd)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if detach {
+			err = detachDevice(node)
+		} else if fd, ok := clones[node]; ok {
+			err = attachDevice(fd, node)
+		} else {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", node, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// enterContainerMountNs moves the calling thread in the mount namespace of
+// the container with the given main process. The given function runs
+// before, in a private copy of the host mount namespace: it is owned by the
+// container user namespace, which allows cloning its mounts. The copy also
+// gives the thread its own filesystem context, needed to join another
+// namespace. The mount namespaces are per thread, the thread is thrown away
+// when the command exits.
+func enterContainerMountNs(initPid int, before func()) error {
+	runtime.LockOSThread()
+
+	containerNs, err := os.Open(filepath.Join("/proc", strconv.Itoa(initPid), "ns", "mnt"))
+	if err != nil {
+		return fmt.Errorf("container mount namespace not found: %w", err)
+	}
+	defer containerNs.Close()
+
+	err = unix.Unshare(unix.CLONE_NEWNS)
+	if err != nil {
+		return fmt.Errorf("failed to create a mount namespace: %w", err)
+	}
+
+	before()
+
+	err = unix.Setns(int(containerNs.Fd()), unix.CLONE_NEWNS)
+	if err != nil {
+		return fmt.Errorf("failed to enter the container mount namespace: %w", err)
+	}
+	return nil
+}
+
+// cloneDevice returns a detached mount of the given host device node. The
+// node is checked through the returned descriptor, so that it cannot be
+// swapped in between.
+func cloneDevice(node string) (int, error) {
+	fd, err := unix.OpenTree(unix.AT_FDCWD, node, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return -1, fmt.Errorf("device not found on the host: %w", err)
+	}
+
+	var stat unix.Stat_t
+	err = unix.Fstat(fd, &stat)
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	nodeType := stat.Mode & unix.S_IFMT
+	if nodeType != unix.S_IFCHR && nodeType != unix.S_IFBLK {
+		unix.Close(fd)
+		return -1, fmt.Errorf("not a device")
+	}
+	return fd, nil
+}
+
+func attachDevice(fd int, node string) error {
+	var sourceStat unix.Stat_t
+	err := unix.Fstat(fd, &sourceStat)
+	if err != nil {
+		return err
+	}
+	sourceType := sourceStat.Mode & unix.S_IFMT
+
+	var nodeStat unix.Stat_t
+	err = unix.Stat(node, &nodeStat)
+	if err == nil {
+		// the device is already reachable, e.g. through a bound directory
+		// like /dev/bus/usb/
+		if nodeStat.Mode&unix.S_IFMT == sourceType && nodeStat.Rdev == sourceStat.Rdev {
+			return nil
+		}
+		if nodeStat.Mode&unix.S_IFMT == unix.S_IFDIR {
+			return fmt.Errorf("a directory exists in the container")
+		}
+	} else {
+		err = os.MkdirAll(filepath.Dir(node), 0755)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(node, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+
+	return unix.MoveMount(fd, "", unix.AT_FDCWD, node, unix.MOVE_MOUNT_F_EMPTY_PATH)
+}
+
+func detachDevice(node string) error {
+	err := unix.Unmount(node, unix.MNT_DETACH)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOENT) {
+		// not bound by device-attach, e.g. part of a bound directory
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// only the placeholder created by device-attach is removed
+	info, err := os.Lstat(node)
+	if err == nil && info.Mode().IsRegular() && info.Size() == 0 {
+		return os.Remove(node)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/spf13/cobra"
+)
+
+func NewDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <container|remote>",
+		Short: "Show the persistent changes of a cpak application",
+		Long: `Show the files added (A), changed (C) and deleted (D) by a persistent
+container, compared to the layers of its application. When a remote is given,
+the most recent persistent container of that application is used.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: DiffPackage,
+	}
+	cmd.Flags().String("version", "", "Specify a version")
+	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
+	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
+	cmd.Flags().StringP("release", "r", "", "Specify a release")
+	cmd.Flags().Bool("json", false, "Output the changes as JSON")
+
+	return cmd
+}
+
+func DiffPackage(cmd *cobra.Command, args []string) (err error) {
+	ref := args[0]
+
+	version, _ := cmd.Flags().GetString("version")
+	branch, _ := cmd.Flags().GetString("branch")
+	commit, _ := cmd.Flags().GetString("commit")
+	release, _ := cmd.Flags().GetString("release")
+	asJson, _ := cmd.Flags().GetBool("json")
+
+	cpk, err := cpak.NewCpak()
+	if err != nil {
+		return err
+	}
+
+	app, container, err := cpk.GetPersistentContainer(ref, version, branch, commit, release)
+	if err != nil {
+		return err
+	}
+
+	changes, err := cpk.Diff(app, container)
+	if err != nil {
+		return err
+	}
+
+	if asJson {
+		out, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Kind, change.Path)
+	}
+	return nil
+}
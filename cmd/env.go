@@ -0,0 +1,55 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/spf13/cobra"
+)
+
+func NewEnvCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env [bash|zsh|fish]",
+		Short: "Print the shell setup to use the exported commands",
+		Long: `Print the commands adding the exported commands to PATH, along with their
+completions and man pages, for the given shell or the one in $SHELL.
+
+Add the following to the shell startup file:
+
+  bash, zsh: eval "$(cpak env)"
+  fish:      cpak env fish | source
+
+zsh users must run compinit after it, for the completions to be loaded.`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: cpak.Shells,
+		RunE:      PrintShellEnv,
+	}
+
+	return cmd
+}
+
+func PrintShellEnv(cmd *cobra.Command, args []string) error {
+	shell := filepath.Base(os.Getenv("SHELL"))
+	if len(args) > 0 {
+		shell = args[0]
+	}
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return err
+	}
+
+	env, err := c.GetShellEnv(shell)
+	if err != nil {
+		return fmt.Errorf("an error occurred while generating the shell setup: %w", err)
+	}
+	fmt.Print(env)
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+// NewGpuCommand returns the cobra command inspecting the host GPU drivers
+func NewGpuCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gpu",
+		Short: "Inspect the host GPU drivers injected in the containers",
+	}
+
+	cmd.AddCommand(NewGpuInfoCommand())
+	return cmd
+}
+
+func NewGpuInfoCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show the host GPU drivers and CDI devices available to the containers",
+		Args:  cobra.NoArgs,
+		RunE:  GpuInfo,
+	}
+	cmd.Flags().Bool("refresh", false, "Run the discovery again, ignoring the cache")
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+	return cmd
+}
+
+func GpuInfo(cmd *cobra.Command, args []string) error {
+	refresh, _ := cmd.Flags().GetBool("refresh")
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	cpk, err := cpak.NewCpak()
+	if err != nil {
+		return err
+	}
+	drivers, err := cpk.GetHostGpuDrivers(refresh)
+	if err != nil {
+		return err
+	}
+	cdiDevices := cpak.GetCdiDevices()
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(map[string]any{
+			"drivers":    drivers,
+			"cdiDevices": cdiDevices,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	if len(drivers) == 0 {
+		fmt.Println("No GPU driver found on the host, only the image drivers are used")
+	}
+	for _, driver := range drivers {
+		source := "discovered now"
+		if driver.Cached {
+			source = "cached"
+		}
+		mode := "injected only with gpuDrivers=host"
+		if driver.HostByDefault {
+			mode = "injected unless gpuDrivers=image"
+		}
+		fmt.Printf("Driver: %s %s (%s, %s)\n", driver.Driver.Vendor, driver.Driver.Version, source, mode)
+
+		header := []string{"Path", "Target", "Kind", "Soname"}
+		data := [][]string{}
+		for _, file := range driver.Driver.ParsedFiles {
+			data = append(data, []string{file.Path, file.Target, file.Kind, file.Soname})
+		}
+		for _, device := range driver.Devices {
+			data = append(data, []string{device, "", types.GpuFileDevice, ""})
+		}
+		tools.ShowTable(header, data)
+		fmt.Println()
+	}
+
+	if len(cdiDevices) > 0 {
+		fmt.Println("CDI devices, selected with the cdiDevices override key:")
+		for _, name := range cdiDevices {
+			fmt.Println("  " + name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+func NewHostPathAttachCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:    "host-path-attach PATH...",
+		Short:  "Binds host files in the current container under the host root directory (internal)",
+		Args:   cobra.MinimumNArgs(1),
+		RunE:   runHostPathAttach,
+		Hidden: true,
+	}
+
+	cmd.Flags().Int("init-pid", 0, "Pid of the container main process, whose mount namespace gets the files")
+	cmd.MarkFlagRequired("init-pid")
+
+	return cmd
+}
+
+// runHostPathAttach is run by cpak run in the user namespace of a container,
+// while still in the host mount namespace. Each host file or directory is
+// cloned as a detached mount, then moved in the container mount namespace
+// at the same path under the host root directory, so that the container
+// only sees the files it has been given.
+func runHostPathAttach(cmd *cobra.Command, args []string) error {
+	initPid, _ := cmd.Flags().GetInt("init-pid")
+
+	paths := []string{}
+	errs := []error{}
+	for _, path := range args {
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+			errs = append(errs, fmt.Errorf("invalid host path %s", path))
+			continue
+		}
+		paths = append(paths, path)
+	}
+
+	clones := map[string]int{}
+	err := enterContainerMountNs(initPid, func() {
+		for _, path := range paths {
+			// the directories are cloned with their submounts, which
+			// cannot be left out of a copied mount namespace
+			fd, cloneErr := unix.OpenTree(unix.AT_FDCWD, path, unix.OPEN_TREE_CLONE|unix.OPEN_TREE_CLOEXEC|unix.AT_RECURSIVE)
+			if cloneErr != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, cloneErr))
+				continue
+			}
+			clones[path] = fd
+		}
+	})
+	defer func() {
+		for _, fd := range clones {
+			unix.Close(fd)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		fd, ok := clones[path]
+		if !ok {
+			continue
+		}
+		err = attachHostPath(fd, filepath.Join(cpak.HostRootInContainer, path))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func attachHostPath(fd int, target string) error {
+	var sourceStat unix.Stat_t
+	err := unix.Fstat(fd, &sourceStat)
+	if err != nil {
+		return err
+	}
+	isDir := sourceStat.Mode&unix.S_IFMT == unix.S_IFDIR
+
+	var targetStat unix.Stat_t
+	err = unix.Stat(target, &targetStat)
+	if err == nil {
+		// already bound by a previous run
+		if targetStat.Dev == sourceStat.Dev && targetStat.Ino == sourceStat.Ino {
+			return nil
+		}
+		if (targetStat.Mode&unix.S_IFMT == unix.S_IFDIR) != isDir {
+			return fmt.Errorf("a different file type exists in the container")
+		}
+	} else if isDir {
+		err = os.MkdirAll(target, 0755)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+
+	return unix.MoveMount(fd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH)
+}
@@ -9,7 +9,7 @@ import (
 	"fmt"
 	"os"
 
-	hrun_client "github.com/containerpak/hrun/pkg/client"
+	"github.com/mirkobrombin/cpak/pkg/cpak"
 	"github.com/mirkobrombin/cpak/pkg/logger"
 	"github.com/spf13/cobra"
 )
@@ -40,13 +40,13 @@ func runHostExecClient(cmd *cobra.Command, args []string) error {
 
 	commandAndArgs := args
 
-	logger.Printf("Starting hrun client for command %v on socket %s", commandAndArgs, socketPath)
-	err := hrun_client.StartClient(commandAndArgs, socketPath)
+	logger.Printf("Starting hostexec client for command %v on socket %s", commandAndArgs, socketPath)
+	err := cpak.HostExec(commandAndArgs, socketPath)
 
 	if err != nil {
-		return fmt.Errorf("hrun client execution failed: %w", err)
+		return fmt.Errorf("hostexec client execution failed: %w", err)
 	}
 
-	logger.Println("hrun client finished successfully.")
+	logger.Println("hostexec client finished successfully.")
 	return nil
 }
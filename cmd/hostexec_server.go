@@ -6,12 +6,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 
-	hrun_server "github.com/containerpak/hrun/pkg/server"
+	"github.com/mirkobrombin/cpak/pkg/cpak"
 	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -25,6 +27,9 @@ func NewHostExecServerCommand() *cobra.Command {
 
 	cmd.Flags().String("socket-path", "", "Path for the Unix domain socket")
 	cmd.Flags().StringArray("allowed-cmd", []string{}, "Allowed command to execute (can be specified multiple times)")
+	cmd.Flags().String("policies", "{}", "JSON encoded argument policies for the allowed commands")
+	cmd.Flags().String("app-id", "", "Application of the container, for the audit log")
+	cmd.Flags().String("container-id", "", "Container served, for the audit log")
 	cmd.MarkFlagRequired("socket-path")
 
 	return cmd
@@ -33,6 +38,14 @@ func NewHostExecServerCommand() *cobra.Command {
 func runHostExecServer(cmd *cobra.Command, args []string) error {
 	socketPath, _ := cmd.Flags().GetString("socket-path")
 	allowedCmds, _ := cmd.Flags().GetStringArray("allowed-cmd")
+	policiesJson, _ := cmd.Flags().GetString("policies")
+	appId, _ := cmd.Flags().GetString("app-id")
+	containerId, _ := cmd.Flags().GetString("container-id")
+
+	policies := map[string]types.HostCommandPolicy{}
+	if err := json.Unmarshal([]byte(policiesJson), &policies); err != nil {
+		return fmt.Errorf("invalid hostexec policies: %w", err)
+	}
 
 	socketDir := filepath.Dir(socketPath)
 	if err := os.MkdirAll(socketDir, 0700); err != nil {
@@ -41,14 +54,23 @@ func runHostExecServer(cmd *cobra.Command, args []string) error {
 
 	_ = os.Remove(socketPath)
 
-	logger.Printf("Starting hrun server on socket: %s with allowed commands: %v", socketPath, allowedCmds)
-	err := hrun_server.StartServer(allowedCmds, socketPath)
+	logger.Printf("Starting hostexec server on socket: %s with allowed commands: %v", socketPath, allowedCmds)
+	server := &cpak.HostExecServer{
+		SocketPath: socketPath,
+		Allowed:    allowedCmds,
+		Policies:   policies,
+		// the socket lives in the container state directory
+		ContainerPidFile: filepath.Join(socketDir, cpak.ContainerPidFileName),
+		AppId:            appId,
+		ContainerId:      containerId,
+	}
+	err := server.Serve()
 
 	if err != nil {
 		logger.Printf("hrun server exited with error: %v", err)
 		return fmt.Errorf("hostexec server failed: %w", err)
 	}
 
-	logger.Println("hrun server finished successfully.")
+	logger.Println("hostexec server finished successfully.")
 	return nil
 }
@@ -0,0 +1,128 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+func NewInfoCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "info <origin>",
+		Short: "Show the details of an installed application",
+		Long: `Show the details of an installed application, from its manifest and from
+the AppStream metainfo shipped in its image. The most recently installed
+version is shown if none is given.`,
+		Args: cobra.ExactArgs(1),
+		RunE: ShowInfo,
+	}
+	cmd.Flags().StringP("version", "v", "", "Specify a version")
+	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
+	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
+	cmd.Flags().StringP("release", "r", "", "Specify a release")
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+
+	return cmd
+}
+
+func infoError(iErr error) (err error) {
+	err = fmt.Errorf("an error occurred while getting the application info: %s", iErr)
+	return
+}
+
+// appInfoOutput is the JSON output of cpak info.
+type appInfoOutput struct {
+	types.AppInfo
+	Name           string    `json:"name"`
+	Version        string    `json:"version"`
+	Origin         string    `json:"origin"`
+	Source         string    `json:"source"`
+	Installed      time.Time `json:"installed"`
+	Binaries       []string  `json:"binaries"`
+	DesktopEntries []string  `json:"desktop_entries"`
+}
+
+func ShowInfo(cmd *cobra.Command, args []string) error {
+	version, _ := cmd.Flags().GetString("version")
+	branch, _ := cmd.Flags().GetString("branch")
+	commit, _ := cmd.Flags().GetString("commit")
+	release, _ := cmd.Flags().GetString("release")
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return infoError(err)
+	}
+
+	app, err := c.GetAppInfo(strings.ToLower(args[0]), version, branch, commit, release)
+	if err != nil {
+		return infoError(err)
+	}
+	info := app.ParsedInfo
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(appInfoOutput{
+			AppInfo:        info,
+			Name:           app.Name,
+			Version:        app.Version,
+			Origin:         app.Origin,
+			Source:         app.SourceType(),
+			Installed:      app.InstallTimestamp,
+			Binaries:       app.ParsedBinaries,
+			DesktopEntries: app.ParsedDesktopEntries,
+		}, "", "  ")
+		if err != nil {
+			return infoError(err)
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	field := func(name string, value string) {
+		if value != "" {
+			fmt.Printf("%-16s %s\n", name+":", value)
+		}
+	}
+	binaries := []string{}
+	for _, binary := range app.ParsedBinaries {
+		binaries = append(binaries, filepath.Base(binary))
+	}
+	rating := []string{}
+	for attribute, value := range info.ContentRating {
+		rating = append(rating, attribute+"="+value)
+	}
+	sort.Strings(rating)
+
+	field("Name", app.Name)
+	field("Id", info.Id)
+	field("Summary", info.Summary)
+	field("Version", app.Version)
+	field("Origin", app.Origin)
+	field("Source", app.SourceType())
+	field("Installed", app.InstallTimestamp.Format(time.RFC3339))
+	field("License", info.License)
+	field("Homepage", info.Homepage)
+	field("Categories", strings.Join(info.Categories, ", "))
+	field("Keywords", strings.Join(info.Keywords, ", "))
+	field("Binaries", strings.Join(binaries, ", "))
+	field("Content rating", strings.Join(rating, ", "))
+	for _, screenshot := range info.Screenshots {
+		field("Screenshot", strings.TrimSpace(screenshot.Url+" "+screenshot.Caption))
+	}
+	if info.Description != "" {
+		fmt.Printf("\n%s\n", info.Description)
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/mirkobrombin/cpak/pkg/cpak"
 	"github.com/mirkobrombin/cpak/pkg/logger"
 	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -19,13 +20,17 @@ func NewInstallCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "install <remote>",
 		Short: "Install a package from a remote Git repository",
-		Args:  cobra.ExactArgs(1),
-		RunE:  InstallPackage,
+		Long: `Install a package from a remote Git repository, e.g. github.com/example/app,
+or by its short name in the catalogs, see cpak remote.`,
+		Args: cobra.ExactArgs(1),
+		RunE: InstallPackage,
 	}
 
 	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
 	cmd.Flags().StringP("release", "r", "", "Install a specific release")
 	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
+	cmd.Flags().BoolP("yes", "y", false, "Grant the requested permissions without asking")
+	cmd.Flags().Bool("deny-dangerous", false, "Deny the dangerous permissions instead of granting them")
 
 	return cmd
 }
@@ -41,8 +46,15 @@ func InstallPackage(cmd *cobra.Command, args []string) (err error) {
 	branch, _ := cmd.Flags().GetString("branch")
 	release, _ := cmd.Flags().GetString("release")
 	commit, _ := cmd.Flags().GetString("commit")
+	assumeYes, _ := cmd.Flags().GetBool("yes")
+	denyDangerous, _ := cmd.Flags().GetBool("deny-dangerous")
 
-	cpak, err := cpak.NewCpak()
+	cpk, err := cpak.NewCpak()
+	if err != nil {
+		return installError(err)
+	}
+
+	remote, branch, release, commit, err = cpk.ResolveRemote(remote, branch, release, commit)
 	if err != nil {
 		return installError(err)
 	}
@@ -65,7 +77,7 @@ func InstallPackage(cmd *cobra.Command, args []string) (err error) {
 		branch = "main"
 	}
 
-	manifest, err := cpak.FetchManifest(remote, branch, release, commit)
+	manifest, err := cpk.FetchManifest(remote, branch, release, commit)
 	if err != nil {
 		return err
 	}
@@ -89,14 +101,102 @@ func InstallPackage(cmd *cobra.Command, args []string) (err error) {
 	}
 	logger.Println()
 
-	logger.Println("The following permissions will be granted:")
-	tools.PrintStructKeyVal(manifest.Override)
-	logger.Println()
+	err = cpak.ExpandManifestOverride(manifest)
+	if err != nil {
+		return installError(err)
+	}
+
+	granted, installed, err := cpk.GetGrantedPermissions(remote)
+	if err != nil {
+		return installError(err)
+	}
+	grantedServices, grantedDBusServices, err := cpk.GetGrantedServices(remote)
+	if err != nil {
+		return installError(err)
+	}
+	manifest.Override = reviewPermissions(granted, grantedServices, grantedDBusServices, manifest, installed, denyDangerous)
+
+	if !assumeYes {
+		confirm := tools.ConfirmOperation("Do you want to continue?")
+		if !confirm {
+			return
+		}
+	}
+
+	// the application permissions have just been approved, dependencies
+	// are asked for separately, only if they request any permission
+	cpk.ConfirmPermissions = func(origin string, depManifest *types.CpakManifest, granted types.Override, installed bool) (types.Override, bool) {
+		if depManifest == manifest {
+			return manifest.Override, true
+		}
+		grantedServices, grantedDBusServices, err := cpk.GetGrantedServices(origin)
+		if err != nil {
+			logger.Printf("Failed to get the services of %s: %v", origin, err)
+			return depManifest.Override, false
+		}
+		if len(cpak.DiffPermissions(granted, depManifest.Override))+len(cpak.DiffServicePermissions(grantedServices, grantedDBusServices, depManifest)) == 0 {
+			return depManifest.Override, true
+		}
 
-	confirm := tools.ConfirmOperation("Do you want to continue?")
-	if !confirm {
-		return
+		logger.Printf("\nThe dependency %s requests additional permissions.", origin)
+		override := reviewPermissions(granted, grantedServices, grantedDBusServices, depManifest, installed, denyDangerous)
+		if assumeYes {
+			return override, true
+		}
+		return override, tools.ConfirmOperation("Do you want to grant them?")
 	}
 
-	return cpak.InstallCpak(remote, manifest, branch, commit, release)
+	return cpk.InstallCpak(remote, manifest, branch, commit, release)
+}
+
+// reviewPermissions shows the permissions of the manifest override and
+// services which are not in the granted ones, grouped by risk, and returns
+// the override to install, without the dangerous permissions if they are
+// denied. Denied services are removed from the manifest.
+func reviewPermissions(granted types.Override, grantedServices []types.Service, grantedDBusServices []types.DBusService, manifest *types.CpakManifest, update bool, denyDangerous bool) types.Override {
+	requested := manifest.Override
+	changes := cpak.DiffPermissions(granted, requested)
+	changes = append(changes, cpak.DiffServicePermissions(grantedServices, grantedDBusServices, manifest)...)
+	if len(changes) == 0 {
+		logger.Println("No additional permissions will be granted.")
+		logger.Println()
+		return requested
+	}
+
+	if update {
+		logger.Println("The following permissions are new compared to the installed version:")
+	} else {
+		logger.Println("The following permissions will be granted:")
+	}
+
+	denied := []types.PermissionChange{}
+	for _, risk := range []types.PermissionRisk{types.PermissionRiskHigh, types.PermissionRiskMedium, types.PermissionRiskLow} {
+		header := false
+		for _, change := range changes {
+			if change.Risk != risk {
+				continue
+			}
+			if !header {
+				logger.Printf("  %s:", strings.ToUpper(risk.String()[:1])+risk.String()[1:])
+				header = true
+			}
+
+			line := fmt.Sprintf("    - %s (%s)", change.Description, change.Key)
+			if change.Value != "" {
+				line = fmt.Sprintf("    - %s: %s (%s)", change.Description, change.Value, change.Key)
+			}
+			if denyDangerous && risk == types.PermissionRiskHigh {
+				line += " [denied]"
+				denied = append(denied, change)
+			}
+			logger.Println(line)
+		}
+	}
+	logger.Println()
+
+	if len(denied) > 0 {
+		cpak.RestrictServices(manifest, denied)
+		return cpak.RestrictPermissions(granted, requested, denied)
+	}
+	return requested
 }
@@ -6,108 +6,337 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"reflect"
 	"strings"
 
 	"github.com/mirkobrombin/cpak/pkg/cpak"
 	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
 	"github.com/mirkobrombin/cpak/pkg/types"
-	"github.com/mirkobrombin/go-struct-flags/v1/binder"
 	"github.com/spf13/cobra"
 )
 
-// NewOverrideCommand returns the cobra command for setting a single override key/value
+// NewOverrideCommand returns the cobra command managing the override of a
+// cpak application
 func NewOverrideCommand() *cobra.Command {
-	var key, value string
 	cmd := &cobra.Command{
-		Use:   "override APP_ORIGIN -k key -v value",
-		Short: "Set override key/value for a cpak application",
-		Long: `Set a single override key to a given value for an installed cpak application.
+		Use:   "override APP_ORIGIN show|set|unset|reset|edit|export|import [ARGS]",
+		Short: "Manage the override of a cpak application",
+		Long: `Manage the user override of an installed cpak application, which only
+holds the keys set by the user, on top of the manifest and the user defaults.
+
+  show [--effective]     show the override, or the merged one with --effective
+  set KEY VALUE          set a key
+  unset KEY...           remove keys, going back to the lower layers
+  reset                  remove the whole override
+  edit                   edit the override in $EDITOR
+  export [FILE]          write the override to FILE or to the standard output
+  import FILE|-          replace the override with FILE or the standard input
+
 Use JSON field names for KEY (e.g. socketX11, fsExtra, env, etc.).
-For list fields (fsExtra, env, allowedHostCommands), separate items with ':'`,
-		Args: cobra.ExactArgs(1),
+For list fields (fsExtra, env, deviceRules, cdiDevices, allowedHostCommands,
+nestedRun), separate items with ':' or give a JSON list, map fields
+(hostCommandPolicies) are given as JSON.`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: RunOverride,
 	}
 
-	cmd.Flags().StringVarP(&key, "key", "k", "", "Override key (required)")
-	cmd.Flags().StringVarP(&value, "value", "v", "", "Override value (required)")
-	_ = cmd.MarkFlagRequired("key")
-	_ = cmd.MarkFlagRequired("value")
+	cmd.Flags().String("branch", "", "Select the application branch")
+	cmd.Flags().String("commit", "", "Select the application commit")
+	cmd.Flags().String("release", "", "Select the application release")
+	cmd.Flags().Bool("effective", false, "Show or export the merged override, with the source of every key")
+	cmd.Flags().StringArrayP("override", "o", []string{}, "Include a per-run override in the merged override, as key=value")
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+
+	// kept for compatibility with the former "override APP -k KEY -v VALUE"
+	cmd.Flags().StringP("key", "k", "", "Override key to set")
+	cmd.Flags().StringP("value", "v", "", "Override value to set")
+	cmd.Flags().MarkHidden("key")
+	cmd.Flags().MarkHidden("value")
 	return cmd
 }
 
-// RunOverride sets the override key/value for a cpak application
+// RunOverride dispatches the override actions
 func RunOverride(cmd *cobra.Command, args []string) error {
 	appOrigin := strings.ToLower(args[0])
 
-	key, err := cmd.Flags().GetString("key")
+	action := "show"
+	actionArgs := []string{}
+	if key, _ := cmd.Flags().GetString("key"); key != "" {
+		value, _ := cmd.Flags().GetString("value")
+		action = "set"
+		actionArgs = []string{key, value}
+	} else if len(args) > 1 {
+		action = args[1]
+		actionArgs = args[2:]
+	}
+
+	cpk, err := cpak.NewCpak()
 	if err != nil {
-		return fmt.Errorf("a key is required")
+		return err
 	}
-	value, err := cmd.Flags().GetString("value")
+	app, err := getOverrideApplication(cmd, cpk, appOrigin)
 	if err != nil {
-		return fmt.Errorf("a value is required")
+		return err
 	}
 
-	// Initialize cpak and store
-	cpk, err := cpak.NewCpak()
+	switch action {
+	case "show":
+		return showOverride(cmd, app)
+	case "set":
+		if len(actionArgs) != 2 {
+			return fmt.Errorf("usage: cpak override %s set KEY VALUE", appOrigin)
+		}
+		return setOverride(app, actionArgs[0], actionArgs[1])
+	case "unset":
+		if len(actionArgs) == 0 {
+			return fmt.Errorf("usage: cpak override %s unset KEY...", appOrigin)
+		}
+		for _, key := range actionArgs {
+			err = cpak.UnsetOverrideKey(app, key)
+			if err != nil {
+				return err
+			}
+			recordOverrideChange(app, "unset "+key)
+			logger.Printf("Override %s unset for %s", key, appOrigin)
+		}
+		return nil
+	case "reset":
+		err = cpak.DeleteOverride(app)
+		if err != nil {
+			return err
+		}
+		recordOverrideChange(app, "reset")
+		logger.Printf("Override reset for %s", appOrigin)
+		return nil
+	case "edit":
+		return editOverride(app)
+	case "export":
+		return exportOverride(cmd, app, actionArgs)
+	case "import":
+		if len(actionArgs) != 1 {
+			return fmt.Errorf("usage: cpak override %s import FILE|-", appOrigin)
+		}
+		return importOverride(app, actionArgs[0])
+	default:
+		return fmt.Errorf("unknown override action %q", action)
+	}
+}
+
+// getOverrideApplication returns the installed application matching the
+// given origin and the version flags
+func getOverrideApplication(cmd *cobra.Command, cpk cpak.Cpak, appOrigin string) (app types.Application, err error) {
+	branch, _ := cmd.Flags().GetString("branch")
+	commit, _ := cmd.Flags().GetString("commit")
+	release, _ := cmd.Flags().GetString("release")
+
+	store, err := cpak.NewStore(cpk.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	app, err = store.GetApplicationByOrigin(appOrigin, "", branch, commit, release)
+	if err != nil || app.CpakId == "" {
+		return app, fmt.Errorf("application %q not found", appOrigin)
+	}
+	return app, nil
+}
+
+func setOverride(app types.Application, key string, value string) error {
+	layerValue, err := cpak.ParseOverrideValue(key, value)
 	if err != nil {
 		return err
 	}
-	store, err := cpak.NewStore(cpk.Options.StorePath)
+
+	// only the given key is stored in the application override, the other
+	// keys keep coming from the manifest and the user defaults
+	err = cpak.SetOverrideKey(app, key, layerValue)
 	if err != nil {
 		return err
 	}
-	defer store.Close()
 
-	apps, err := store.GetApplications()
+	recordOverrideChange(app, fmt.Sprintf("set %s=%s", key, value))
+	logger.Printf("Override %s=%s saved for %s", key, value, app.Origin)
+	return nil
+}
+
+func showOverride(cmd *cobra.Command, app types.Application) error {
+	effectiveFlag, _ := cmd.Flags().GetBool("effective")
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+	overrides, _ := cmd.Flags().GetStringArray("override")
+
+	runOverride, err := cpak.ParseOverrideAssignments(overrides)
 	if err != nil {
 		return err
 	}
-	if len(apps) == 0 {
-		return fmt.Errorf("no cpak applications installed")
+	effective, err := cpak.ResolveOverride(app, runOverride)
+	if err != nil {
+		return err
 	}
 
-	// Find the application by origin
-	var sel types.Application
-	for _, a := range apps {
-		if a.Origin == appOrigin {
-			sel = a
-			break
+	if jsonFlag {
+		var out []byte
+		if effectiveFlag {
+			out, err = json.MarshalIndent(effective, "", "  ")
+		} else {
+			var layer cpak.OverrideLayer
+			layer, err = cpak.LoadOverride(app)
+			if err == nil {
+				out, err = json.MarshalIndent(layer, "", "  ")
+			}
 		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
 	}
-	if sel.Origin == "" {
-		return fmt.Errorf("application %q not found", appOrigin)
+
+	header := []string{"Key", "Value", "Source"}
+	data := [][]string{}
+	val := reflect.ValueOf(effective.Override)
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		key, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		source := effective.Sources[key]
+		if !effectiveFlag && source != cpak.OverrideSourceApp {
+			continue
+		}
+
+		value, _ := json.Marshal(val.Field(i).Interface())
+		data = append(data, []string{key, string(value), source})
 	}
+	tools.ShowTable(header, data)
+	return nil
+}
 
-	// Load existing override or fallback to manifest
-	over := sel.ParsedOverride
-	if userO, err := cpak.LoadOverride(appOrigin, sel.Version); err == nil {
-		over = userO
+func editOverride(app types.Application) error {
+	layer, err := cpak.LoadOverride(app)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return err
 	}
 
-	// Initialize the flag binder
-	binder, err := binder.NewBinder(&over, os.TempDir(), true)
+	tmpFile, err := os.CreateTemp("", "cpak-override-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(append(content, '\n'))
+	tmpFile.Close()
 	if err != nil {
 		return err
 	}
 
-	argsList := []string{value}
-	if key == "fsExtra" || key == "env" || key == "allowedHostCommands" {
-		argsList = strings.Split(value, ":")
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
 	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	for {
+		editorCmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmpFile.Name())
+		editorCmd.Stdin = os.Stdin
+		editorCmd.Stdout = os.Stdout
+		editorCmd.Stderr = os.Stderr
+		err = editorCmd.Run()
+		if err != nil {
+			return fmt.Errorf("editor failed: %w", err)
+		}
+
+		edited, err := cpak.LoadOverrideLayer(tmpFile.Name())
+		if err == nil {
+			err = cpak.SaveOverride(app, edited)
+		}
+		if err == nil {
+			recordOverrideChange(app, "edit")
+			logger.Printf("Override saved for %s", app.Origin)
+			return nil
+		}
+
+		logger.Println(err)
+		if !tools.ConfirmOperation("Edit the override again?") {
+			return fmt.Errorf("override not saved")
+		}
+	}
+}
 
-	// Register the key with the binder
-	if err := binder.Run(key, argsList); err != nil {
+func exportOverride(cmd *cobra.Command, app types.Application, args []string) error {
+	effectiveFlag, _ := cmd.Flags().GetBool("effective")
+
+	var out []byte
+	if effectiveFlag {
+		effective, err := cpak.ResolveOverride(app, nil)
+		if err != nil {
+			return err
+		}
+		out, err = json.MarshalIndent(effective.Override, "", "  ")
+		if err != nil {
+			return err
+		}
+	} else {
+		layer, err := cpak.LoadOverride(app)
+		if err != nil {
+			return err
+		}
+		out, err = json.MarshalIndent(layer, "", "  ")
+		if err != nil {
+			return err
+		}
+	}
+	out = append(out, '\n')
+
+	if len(args) == 0 || args[0] == "-" {
+		_, err := os.Stdout.Write(out)
 		return err
 	}
+	return os.WriteFile(args[0], out, 0644)
+}
 
-	// Save the override
-	if err := cpak.SaveOverride(over, appOrigin, sel.Version); err != nil {
+func importOverride(app types.Application, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
 		return err
 	}
 
-	logger.Printf("Override %s=%s saved for %s", key, value, appOrigin)
+	var layer cpak.OverrideLayer
+	err = json.Unmarshal(data, &layer)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	err = cpak.SaveOverride(app, layer)
+	if err != nil {
+		return err
+	}
+
+	recordOverrideChange(app, "import "+path)
+	logger.Printf("Override imported for %s", app.Origin)
 	return nil
 }
+
+func recordOverrideChange(app types.Application, detail string) {
+	cpak.RecordAuditEvent(types.AuditEvent{
+		AppId:    app.CpakId,
+		Type:     types.AuditEventOverride,
+		Decision: types.AuditDecisionAllowed,
+		Detail:   detail,
+	})
+}
@@ -0,0 +1,172 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/spf13/cobra"
+)
+
+// NewProfileCommand returns the cobra command managing the permission
+// profiles
+func NewProfileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage the permission profiles",
+		Long: `Manage the permission profiles, named sets of override keys which
+manifests, overrides and other profiles can reference with "extends".
+
+Profiles are looked up in ~/.config/cpak/profiles, then in /etc/cpak/profiles
+and finally among the builtin ones (cli-minimal, gui-default, gamer, dev).`,
+	}
+
+	cmd.AddCommand(NewProfileListCommand())
+	cmd.AddCommand(NewProfileShowCommand())
+	cmd.AddCommand(NewProfileCreateCommand())
+	return cmd
+}
+
+func NewProfileListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the permission profiles",
+		Args:    cobra.NoArgs,
+		RunE:    ListProfiles,
+	}
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+	return cmd
+}
+
+func NewProfileShowCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show NAME",
+		Short: "Show a permission profile",
+		Args:  cobra.ExactArgs(1),
+		RunE:  ShowProfile,
+	}
+	cmd.Flags().Bool("resolved", false, "Merge the profiles it extends")
+	return cmd
+}
+
+func NewProfileCreateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create or replace a permission profile",
+		Long: `Create or replace a permission profile, from a JSON file or from the
+given key=value assignments. For list fields, separate items with ':'.`,
+		Args: cobra.ExactArgs(1),
+		RunE: CreateProfile,
+	}
+	cmd.Flags().String("extends", "", "Base the profile on another one")
+	cmd.Flags().StringArrayP("set", "s", []string{}, "Set a key, as key=value")
+	cmd.Flags().StringP("from", "f", "", "Read the profile from a JSON file")
+	cmd.Flags().Bool("system", false, "Save the profile in /etc/cpak/profiles for all the users")
+	return cmd
+}
+
+func ListProfiles(cmd *cobra.Command, args []string) error {
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	profiles, err := cpak.GetProfiles()
+	if err != nil {
+		return err
+	}
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(profiles, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	header := []string{"Name", "Source", "Extends", "Keys"}
+	data := [][]string{}
+	for _, profile := range profiles {
+		keys := len(profile.Layer)
+		if profile.Extends() != "" {
+			keys--
+		}
+		data = append(data, []string{profile.Name, profile.Source, profile.Extends(), fmt.Sprintf("%d", keys)})
+	}
+	tools.ShowTable(header, data)
+	return nil
+}
+
+func ShowProfile(cmd *cobra.Command, args []string) error {
+	resolved, _ := cmd.Flags().GetBool("resolved")
+
+	var layer cpak.OverrideLayer
+	if resolved {
+		var err error
+		layer, err = cpak.ResolveProfile(args[0])
+		if err != nil {
+			return err
+		}
+	} else {
+		profile, err := cpak.GetProfile(args[0])
+		if err != nil {
+			return err
+		}
+		layer = profile.Layer
+	}
+
+	jsonBytes, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
+
+func CreateProfile(cmd *cobra.Command, args []string) error {
+	extends, _ := cmd.Flags().GetString("extends")
+	assignments, _ := cmd.Flags().GetStringArray("set")
+	from, _ := cmd.Flags().GetString("from")
+	system, _ := cmd.Flags().GetBool("system")
+
+	profile := cpak.Profile{
+		Name:   args[0],
+		Source: cpak.ProfileSourceUser,
+		Layer:  cpak.OverrideLayer{},
+	}
+	if system {
+		profile.Source = cpak.ProfileSourceSystem
+	}
+
+	if from != "" {
+		layer, err := cpak.LoadOverrideLayer(from)
+		if err != nil {
+			return err
+		}
+		profile.Layer = layer
+	}
+
+	setLayer, err := cpak.ParseOverrideAssignments(assignments)
+	if err != nil {
+		return err
+	}
+	for key, value := range setLayer {
+		profile.Layer[key] = value
+	}
+	if extends != "" {
+		profile.Layer["extends"], _ = json.Marshal(extends)
+	}
+
+	err = cpak.SaveProfile(profile)
+	if err != nil {
+		return err
+	}
+	logger.Printf("Profile %s saved", profile.Name)
+	return nil
+}
@@ -0,0 +1,43 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/spf13/cobra"
+)
+
+func NewRefreshCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Refresh the indexes of the catalogs",
+		Long: `Fetch again the index of every catalog, verifying its signature. The cached
+index of a catalog is kept if its refresh fails.`,
+		Args: cobra.NoArgs,
+		RunE: RefreshCatalogs,
+	}
+	return cmd
+}
+
+func refreshError(iErr error) (err error) {
+	err = fmt.Errorf("an error occurred while refreshing the catalogs: %s", iErr)
+	return
+}
+
+func RefreshCatalogs(cmd *cobra.Command, args []string) error {
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return refreshError(err)
+	}
+
+	err = c.RefreshCatalogs()
+	if err != nil {
+		return refreshError(err)
+	}
+	return nil
+}
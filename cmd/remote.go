@@ -0,0 +1,150 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/spf13/cobra"
+)
+
+// NewRemoteCommand returns the cobra command managing the catalogs the
+// applications are searched in
+func NewRemoteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remote",
+		Short: "Manage the catalogs of applications",
+		Long: `Manage the catalogs of applications, signed JSON indexes served over HTTP
+or read from a local path, listing the origins of the applications along
+with their metadata and versions. The applications of the catalogs are
+searched with cpak search and installed by their short name.`,
+	}
+
+	cmd.AddCommand(NewRemoteAddCommand())
+	cmd.AddCommand(NewRemoteRemoveCommand())
+	cmd.AddCommand(NewRemoteListCommand())
+	return cmd
+}
+
+func NewRemoteAddCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add NAME URL",
+		Short: "Add a catalog",
+		Long: `Add a catalog, URL being the HTTP(S) URL or the local path of its index.
+The index is verified against its detached signature, URL.sig, with the
+given ed25519 public key. Only the local indexes can be added without key,
+with --insecure, their index is then not verified.
+
+Examples:
+  cpak remote add corp https://apps.example.com/index.json --key MCowBQYDK2VwAyEA...
+  cpak remote add local ./index.json --insecure`,
+		Args: cobra.ExactArgs(2),
+		RunE: AddRemote,
+	}
+	cmd.Flags().StringP("key", "k", "", "The base64 ed25519 public key the index is signed with")
+	cmd.Flags().Bool("insecure", false, "Add a local index without key, which is not verified")
+	return cmd
+}
+
+func NewRemoteRemoveCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "remove NAME",
+		Aliases: []string{"rm"},
+		Short:   "Remove a catalog",
+		Args:    cobra.ExactArgs(1),
+		RunE:    RemoveRemote,
+	}
+	return cmd
+}
+
+func NewRemoteListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the catalogs",
+		Args:    cobra.NoArgs,
+		RunE:    ListRemotes,
+	}
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+	return cmd
+}
+
+func remoteError(iErr error) (err error) {
+	err = fmt.Errorf("an error occurred while managing the catalogs: %s", iErr)
+	return
+}
+
+func AddRemote(cmd *cobra.Command, args []string) error {
+	key, _ := cmd.Flags().GetString("key")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return remoteError(err)
+	}
+
+	err = c.AddCatalogSource(args[0], args[1], key, insecure)
+	if err != nil {
+		return remoteError(err)
+	}
+	return nil
+}
+
+func RemoveRemote(cmd *cobra.Command, args []string) error {
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return remoteError(err)
+	}
+
+	err = c.RemoveCatalogSource(args[0])
+	if err != nil {
+		return remoteError(err)
+	}
+	return nil
+}
+
+func ListRemotes(cmd *cobra.Command, args []string) error {
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return remoteError(err)
+	}
+
+	sources, err := c.GetCatalogSources()
+	if err != nil {
+		return remoteError(err)
+	}
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(sources, "", "  ")
+		if err != nil {
+			return remoteError(err)
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	header := []string{"Name", "URL", "Signed", "Configuration"}
+	data := [][]string{}
+	for _, source := range sources {
+		signed, builtin := "no", ""
+		if source.PublicKey != "" {
+			signed = "yes"
+		} else if source.Insecure {
+			signed = "no (insecure)"
+		}
+		if source.Builtin {
+			builtin = "yes"
+		}
+		data = append(data, []string{source.Name, source.Url, signed, builtin})
+	}
+	tools.ShowTable(header, data)
+	return nil
+}
@@ -6,7 +6,9 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/mirkobrombin/cpak/pkg/cpak"
@@ -26,9 +28,12 @@ use the @ prefix to specify a binary that's not exported by the package.`,
 		RunE: RunPackage,
 	}
 	cmd.Flags().BoolP("verbose", "v", false, "Enable verbose output")
+	cmd.Flags().String("version", "", "Specify a version")
 	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
 	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
 	cmd.Flags().StringP("release", "r", "", "Specify a release")
+	cmd.Flags().Int("host-paths-from", -1, "Translate the host paths and file URIs in the arguments, starting from the given one, to the paths seen in the container")
+	cmd.Flags().StringArrayP("override", "o", []string{}, "Override a permission for this run only, as key=value (only applies to new containers)")
 
 	return cmd
 }
@@ -38,6 +43,16 @@ func runError(iErr error) (err error) {
 	return
 }
 
+// exitOnStatus terminates cpak with the exit status of the process run in
+// the container, if err carries one, so that callers get the same status
+// they would get by running the process directly on the host.
+func exitOnStatus(err error) {
+	var statusErr *cpak.ExitStatusError
+	if errors.As(err, &statusErr) {
+		os.Exit(statusErr.Code)
+	}
+}
+
 func RunPackage(cmd *cobra.Command, args []string) (err error) {
 	remote := strings.ToLower(args[0])
 
@@ -45,21 +60,29 @@ func RunPackage(cmd *cobra.Command, args []string) (err error) {
 	branch, _ := cmd.Flags().GetString("branch")
 	commit, _ := cmd.Flags().GetString("commit")
 	release, _ := cmd.Flags().GetString("release")
+	hostPathsFrom, _ := cmd.Flags().GetInt("host-paths-from")
 
 	binary := args[1]
 	extraArgs := args[2:]
 
 	logger.Println("Running cpak from remote:", remote)
 
-	version, _ := cmd.Flags().GetString("branch")
+	version, _ := cmd.Flags().GetString("version")
+
+	overrides, _ := cmd.Flags().GetStringArray("override")
+	runOverride, err := cpak.ParseOverrideAssignments(overrides)
+	if err != nil {
+		return runError(err)
+	}
 
-	cpak, err := cpak.NewCpak()
+	cpk, err := cpak.NewCpak()
 	if err != nil {
 		return runError(err)
 	}
 
-	err = cpak.Run(remote, version, branch, commit, release, binary, verbose, extraArgs...)
+	err = cpk.Run(remote, version, branch, commit, release, runOverride, binary, verbose, hostPathsFrom, extraArgs...)
 	if err != nil {
+		exitOnStatus(err)
 		return runError(err)
 	}
 
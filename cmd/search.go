@@ -0,0 +1,79 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/cpak"
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/spf13/cobra"
+)
+
+func NewSearchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "search <term>",
+		Short: "Search the applications of the catalogs",
+		Long: `Search the applications of the catalogs by name, description and keywords,
+the best matches first. Every word of the term must match.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: SearchCatalogs,
+	}
+	cmd.Flags().BoolP("json", "j", false, "Print output in JSON format")
+	return cmd
+}
+
+func searchError(iErr error) (err error) {
+	err = fmt.Errorf("an error occurred while searching the catalogs: %s", iErr)
+	return
+}
+
+func SearchCatalogs(cmd *cobra.Command, args []string) error {
+	jsonFlag, _ := cmd.Flags().GetBool("json")
+
+	c, err := cpak.NewCpak()
+	if err != nil {
+		return searchError(err)
+	}
+
+	apps, err := c.SearchCatalogs(strings.Join(args, " "))
+	if err != nil {
+		return searchError(err)
+	}
+
+	if jsonFlag {
+		jsonBytes, err := json.MarshalIndent(apps, "", "  ")
+		if err != nil {
+			return searchError(err)
+		}
+		fmt.Println(string(jsonBytes))
+		return nil
+	}
+
+	if len(apps) == 0 {
+		logger.Println("No applications found")
+		return nil
+	}
+
+	header := []string{"Name", "Description", "Origin", "Versions", "Catalog"}
+	data := [][]string{}
+	for _, app := range apps {
+		description := app.Summary
+		if description == "" {
+			description = app.Description
+		}
+		versions := []string{}
+		for _, version := range app.Versions {
+			versions = append(versions, version.String())
+		}
+		data = append(data, []string{app.Name, description, app.Origin, strings.Join(versions, ", "), app.Catalog})
+	}
+	tools.ShowTable(header, data)
+	return nil
+}
@@ -6,6 +6,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/mirkobrombin/cpak/pkg/cpak"
@@ -28,13 +29,17 @@ func runSError(iErr error) (err error) {
 }
 
 func RunService(cmd *cobra.Command, args []string) (err error) {
-	cpak, err := cpak.NewCpak()
+	cpk, err := cpak.NewCpak()
 	if err != nil {
 		logger.Println("cpak service exited with error!", err)
 		return runSError(err)
 	}
 
-	err = cpak.StartSocketListener()
+	err = cpk.StartSocketListener()
+	if errors.Is(err, cpak.ErrServiceRunning) {
+		logger.Println("cpak service is already running, exiting")
+		return nil
+	}
 	if err != nil {
 		logger.Println("cpak service exited with error!", err)
 		return runSError(err)
@@ -22,9 +22,11 @@ func NewShellCommand() *cobra.Command {
 		RunE:  ShellPackage,
 	}
 	cmd.Flags().BoolP("verbose", "v", false, "Enable verbose output")
+	cmd.Flags().String("version", "", "Specify a version")
 	cmd.Flags().StringP("branch", "b", "", "Specify a branch")
 	cmd.Flags().StringP("commit", "c", "", "Specify a commit")
 	cmd.Flags().StringP("release", "r", "", "Specify a release")
+	cmd.Flags().StringArrayP("override", "o", []string{}, "Override a permission for this run only, as key=value (only applies to new containers)")
 
 	return cmd
 }
@@ -46,17 +48,24 @@ func ShellPackage(cmd *cobra.Command, args []string) (err error) {
 
 	logger.Println("Running cpak from remote:", remote)
 
-	version, _ := cmd.Flags().GetString("branch")
+	version, _ := cmd.Flags().GetString("version")
 
-	cpak, err := cpak.NewCpak()
+	overrides, _ := cmd.Flags().GetStringArray("override")
+	runOverride, err := cpak.ParseOverrideAssignments(overrides)
 	if err != nil {
 		return shellError(err)
 	}
 
-	err = cpak.Run(remote, version, branch, commit, release, binary, verbose, "-i")
+	cpk, err := cpak.NewCpak()
 	if err != nil {
 		return shellError(err)
 	}
 
+	err = cpk.Run(remote, version, branch, commit, release, runOverride, binary, verbose, -1, "-i")
+	if err != nil {
+		exitOnStatus(err)
+		return shellError(err)
+	}
+
 	return nil
 }
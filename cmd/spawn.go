@@ -16,6 +16,7 @@ import (
 	"github.com/mirkobrombin/cpak/pkg/cpak"
 	"github.com/mirkobrombin/cpak/pkg/logger"
 	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -42,6 +43,11 @@ func NewSpawnCommand() *cobra.Command {
 	cmd.Flags().String("state-dir", "s", "set the state directory")
 	cmd.Flags().String("image-dir", "i", "set the image directory")
 	cmd.Flags().String("layers-dir", "d", "set the layers directory")
+	cmd.Flags().String("mount-method", types.MountMethodAuto, "set the layers mount method")
+	cmd.Flags().String("persist-dir", "", "set the directory holding the persistent upper layer")
+	cmd.Flags().String("service-socket-dir", "", "set the directory holding the cpak service socket")
+	cmd.Flags().StringArray("gpu-files", []string{}, "set the GPU driver files to bind, as path or path:target")
+	cmd.Flags().StringArray("gpu-lib-dirs", []string{}, "set the directories of the GPU libraries for the dynamic linker")
 	cmd.Flags().StringArrayP("mount-overrides", "m", []string{}, "set the mount overrides")
 	cmd.Flags().StringArrayP("mount-shims", "M", []string{}, "set the mount shims")
 	cmd.Flags().StringArrayP("extra-links", "x", []string{}, "set the extra links")
@@ -114,6 +120,26 @@ func SpawnPackage(cmd *cobra.Command, args []string) (err error) {
 	if err != nil {
 		return spawnError("extra-links flag", err)
 	}
+	mountMethod, err := cmd.Flags().GetString("mount-method")
+	if err != nil {
+		return spawnError("mount-method flag", err)
+	}
+	persistDir, err := cmd.Flags().GetString("persist-dir")
+	if err != nil {
+		return spawnError("persist-dir flag", err)
+	}
+	serviceSocketDir, err := cmd.Flags().GetString("service-socket-dir")
+	if err != nil {
+		return spawnError("service-socket-dir flag", err)
+	}
+	gpuFiles, err := cmd.Flags().GetStringArray("gpu-files")
+	if err != nil {
+		return spawnError("gpu-files flag", err)
+	}
+	gpuLibDirs, err := cmd.Flags().GetStringArray("gpu-lib-dirs")
+	if err != nil {
+		return spawnError("gpu-lib-dirs flag", err)
+	}
 
 	var hostExecSocketPath string
 	var allowedHostCmdsStr string
@@ -140,18 +166,26 @@ func SpawnPackage(cmd *cobra.Command, args []string) (err error) {
 		return spawnError("mount", err)
 	}
 
+	// containers without network get a private network namespace, where
+	// the loopback interface starts down
+	spawnVerbose("Bringing up the loopback interface")
+	err = tools.SetLoopbackUp()
+	if err != nil {
+		return spawnError("loopback", err)
+	}
+
 	layersAsList := parseLayers(layers)
-	err = mountLayers(rootFs, layersDir, stateDir, layersAsList)
+	err = mountLayers(rootFs, layersDir, stateDir, persistDir, layersAsList, mountMethod)
 	if err != nil {
 		return err
 	}
 
-	err = setupMountPoints(userUid, rootFs, overrideMounts)
+	err = setupMountPoints(userUid, rootFs, overrideMounts, serviceSocketDir)
 	if err != nil {
 		return err
 	}
 
-	err = injectConfigurationFiles(rootFs)
+	err = injectConfigurationFiles(rootFs, gpuFiles)
 	if err != nil {
 		return err
 	}
@@ -178,6 +212,14 @@ func SpawnPackage(cmd *cobra.Command, args []string) (err error) {
 		spawnVerbose("Skipping hostexec shim creation (no allowed commands or socket path).")
 	}
 
+	// the pid file is opened before pivoting, since the state directory is
+	// not reachable from the container's root anymore
+	pidFile, err := os.OpenFile(filepath.Join(stateDir, cpak.ContainerPidFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return spawnError("create pid file", err)
+	}
+	defer pidFile.Close()
+
 	err = pivotRoot(rootFs)
 	if err != nil {
 		return err
@@ -197,7 +239,7 @@ func SpawnPackage(cmd *cobra.Command, args []string) (err error) {
 	// }
 
 	_envVars := setEnvironmentVariables(containerId, rootFs, finalEnvVarsForContainer, stateDir, layersDir, layers)
-	err = startSleepProcess(args, _envVars)
+	err = startSleepProcess(args, _envVars, pidFile, gpuLibDirs)
 	if err != nil {
 		return err
 	}
@@ -248,24 +290,110 @@ func parseLayers(layers string) []string {
 	return layersAsList
 }
 
-func mountLayers(rootFs, layersDir string, stateDir string, layersList []string) error {
-	layersDirs := ""
+// mountLayers assembles the container root filesystem from the given layers
+// using the requested mount method. With the automatic method, every method
+// is tried in order until one succeeds, the method in use is always logged
+// since it has a big impact on performance.
+//
+// If persistDir is set, the upper and work directories are taken from it
+// instead of the state directory, so that changes survive the container.
+func mountLayers(rootFs, layersDir string, stateDir string, persistDir string, layersList []string, mountMethod string) error {
+	if len(layersList) == 0 {
+		return spawnError("mount:layers", fmt.Errorf("no layers to mount"))
+	}
+
+	upperBase := stateDir
+	if persistDir != "" {
+		upperBase = persistDir
+	}
 
+	layersDirs := []string{}
 	for _, layer := range layersList {
-		layerDir := filepath.Join(layersDir, layer)
-		layersDirs = layersDirs + ":" + layerDir
+		layersDirs = append(layersDirs, filepath.Join(layersDir, layer))
+	}
+
+	methods := []string{mountMethod}
+	if mountMethod == types.MountMethodAuto {
+		methods = types.MountMethods
+	}
+
+	var err error
+	for _, method := range methods {
+		spawnVerbose("Mounting layers using", method)
+		switch method {
+		case types.MountMethodOverlay:
+			err = tools.MountOverlay(rootFs, strings.Join(layersDirs, ":"), filepath.Join(upperBase, "up"), filepath.Join(upperBase, "work"))
+		case types.MountMethodFuseOverlayfs:
+			err = mountFuseOverlayfs(rootFs, layersDirs, stateDir, upperBase)
+		case types.MountMethodCopy:
+			if persistDir != "" {
+				err = fmt.Errorf("persistent containers require overlay support")
+				break
+			}
+			err = copyLayers(rootFs, layersDirs)
+		default:
+			err = fmt.Errorf("unknown mount method %s", method)
+		}
+
+		if err == nil {
+			logger.Printf("Layers mounted using %s", method)
+			return nil
+		}
+		logger.Printf("Unable to mount layers using %s: %v", method, err)
 	}
 
-	layersDirs = layersDirs[1:]
+	return spawnError("mount:layers "+strings.Join(layersDirs, ":"), err)
+}
 
-	err := tools.MountOverlay(rootFs, layersDirs, filepath.Join(stateDir, "up"), filepath.Join(stateDir, "work"))
+// mountFuseOverlayfs mounts the layers using fuse-overlayfs and records the
+// fuse-overlayfs process in the state directory, so that it can be stopped
+// along with the container. Its exit status is logged, as long as the
+// spawn process is still running.
+func mountFuseOverlayfs(rootFs string, layersDirs []string, stateDir string, upperBase string) error {
+	process, exited, err := tools.MountFuseOverlayfs(rootFs, strings.Join(layersDirs, ":"), filepath.Join(upperBase, "up"), filepath.Join(upperBase, "work"))
 	if err != nil {
-		return spawnError("mount:layers "+layersDirs, err)
+		return err
+	}
+	go func() {
+		if exitErr := <-exited; exitErr != nil {
+			logger.Printf("fuse-overlayfs exited: %v", exitErr)
+		}
+	}()
+
+	pidInfo, err := tools.NewPidFile(process.Pid)
+	if err != nil {
+		return err
+	}
+	pidFile, err := os.OpenFile(filepath.Join(stateDir, cpak.FuseOverlayfsPidFileName), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer pidFile.Close()
+
+	return pidInfo.Write(pidFile)
+}
+
+// copyLayers snapshots the layers into the container root filesystem, it
+// is the last resort for hosts without any overlay support. Layers are
+// applied from the last one, so that the first layer takes precedence as
+// it does when listed first in the overlay lowerdir option.
+func copyLayers(rootFs string, layersDirs []string) error {
+	reflinked := true
+	for i := len(layersDirs) - 1; i >= 0; i-- {
+		layerReflinked, err := tools.CloneTree(layersDirs[i], rootFs)
+		if err != nil {
+			return err
+		}
+		reflinked = reflinked && layerReflinked
+	}
+
+	if !reflinked {
+		logger.Println("Reflinks not supported by the store filesystem, layers have been copied")
 	}
 	return nil
 }
 
-func setupMountPoints(userUid int, rootFs string, overrideMounts []string) error {
+func setupMountPoints(userUid int, rootFs string, overrideMounts []string, serviceSocketDir string) error {
 	// /tmp is mounted as a new one
 	spawnVerbose("Mounting: /tmp")
 	err := tools.MountTmpfs(filepath.Join(rootFs, "/tmp"))
@@ -346,32 +474,25 @@ func setupMountPoints(userUid int, rootFs string, overrideMounts []string) error
 		}
 	}
 
-	// the cpak socket is mounted as last because it is created by another
-	// process and we need to wait for it to be available. However, it should
-	// be available at this point
-	cpakSockPath := "/tmp/cpak.sock"
-	spawnVerbose("Waiting for: ", cpakSockPath, "to be available...")
-	for {
-		_, err := os.Stat(cpakSockPath)
-		if err == nil {
-			spawnVerbose("Mounting: ", cpakSockPath)
-			err = tools.MountBind(cpakSockPath, filepath.Join(rootFs, cpakSockPath))
-			if err != nil {
-				return spawnError("mount:"+cpakSockPath, err)
-			}
-			break
+	// the directory holding only the cpak service socket is bound rather
+	// than the socket, so that the container keeps reaching the service if
+	// it gets restarted, the service lock stays out of the container
+	if serviceSocketDir != "" {
+		spawnVerbose("Mounting: ", serviceSocketDir)
+		err = os.MkdirAll(filepath.Join(rootFs, serviceSocketDir), 0700)
+		if err != nil {
+			return spawnError("mkdir:"+serviceSocketDir, err)
+		}
+		err = tools.MountBind(serviceSocketDir, filepath.Join(rootFs, serviceSocketDir))
+		if err != nil {
+			return spawnError("mount:"+serviceSocketDir, err)
 		}
 	}
 
 	return nil
 }
 
-func injectConfigurationFiles(rootFs string) error {
-	nvidiaLibs, err := cpak.GetNvidiaLibs()
-	if err != nil {
-		return spawnError("", err)
-	}
-
+func injectConfigurationFiles(rootFs string, gpuFiles []string) error {
 	files := []string{
 		"/etc/resolv.conf",
 		"/etc/hosts",
@@ -380,7 +501,7 @@ func injectConfigurationFiles(rootFs string) error {
 
 	for _, conf := range files {
 		parentDir := filepath.Dir(conf)
-		err = os.MkdirAll(filepath.Join(rootFs, parentDir), 0755)
+		err := os.MkdirAll(filepath.Join(rootFs, parentDir), 0755)
 		if err != nil {
 			return spawnError("mkdir:"+parentDir, err)
 		}
@@ -392,17 +513,29 @@ func injectConfigurationFiles(rootFs string) error {
 		}
 	}
 
-	for _, lib := range nvidiaLibs {
-		spawnVerbose("Mounting: ", lib)
-		// TODO: errors are ignored since also temp directories are returned
-		//	   so they could not exist at the time of the mount
-		tools.MountBind(lib, filepath.Join(rootFs, lib))
-	}
+	// the GPU files come from the discovery cache, a file removed since
+	// then, e.g. by a driver update in progress, is skipped
+	for _, file := range gpuFiles {
+		source, target, ok := strings.Cut(file, ":")
+		if !ok {
+			target = source
+		}
+		if _, err := os.Stat(source); os.IsNotExist(err) {
+			spawnVerbose(source, "does not exist anymore, ignoring")
+			continue
+		}
 
-	// host root is mounted in /run/host for debugging purposes
-	err = tools.MountBind("/", filepath.Join(rootFs, "/run/host"))
-	if err != nil {
-		return spawnError("mount:/", err)
+		parentDir := filepath.Dir(target)
+		err := os.MkdirAll(filepath.Join(rootFs, parentDir), 0755)
+		if err != nil {
+			return spawnError("mkdir:"+parentDir, err)
+		}
+
+		spawnVerbose("Mounting: ", source, "to", target)
+		err = tools.MountBind(source, filepath.Join(rootFs, target))
+		if err != nil {
+			return spawnError("mount:"+source, err)
+		}
 	}
 
 	return nil
@@ -453,9 +586,11 @@ func pivotRoot(rootFs string) error {
 // 	return nil
 // }
 
-func startSleepProcess(cmdArgs []string, envVars []string) error {
+func startSleepProcess(cmdArgs []string, envVars []string, pidFile *os.File, gpuLibDirs []string) error {
+	// the GPU libraries are bound under a private prefix, which the image
+	// dynamic linker does not look into
 	spawnVerbose("Reconfiguring dynamic linker run-time bindings")
-	l := exec.Command("ldconfig")
+	l := exec.Command("ldconfig", gpuLibDirs...)
 	err := l.Run()
 	if err != nil {
 		return spawnError("ldconfig", err)
@@ -488,6 +623,21 @@ func startSleepProcess(cmdArgs []string, envVars []string) error {
 		return spawnError("start", err)
 	}
 
+	// the pid file lets cpak find the container process without scanning
+	// the whole system, the namespace identifiers are recorded as well so
+	// that a reused PID is not mistaken for the container
+	spawnVerbose("Writing pid file for process", c.Process.Pid)
+	pidInfo, err := tools.NewPidFile(c.Process.Pid)
+	if err != nil {
+		c.Process.Kill()
+		return spawnError("pid file", err)
+	}
+	err = pidInfo.Write(pidFile)
+	if err != nil {
+		c.Process.Kill()
+		return spawnError("write pid file", err)
+	}
+
 	err = c.Process.Release()
 	if err != nil {
 		return spawnError("release", err)
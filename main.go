@@ -25,14 +25,28 @@ func main() {
 	rootCmd.AddCommand(cmd.NewInstallCommand())
 	rootCmd.AddCommand(cmd.NewRemoveCommand())
 	rootCmd.AddCommand(cmd.NewListCommand())
+	rootCmd.AddCommand(cmd.NewInfoCommand())
+	rootCmd.AddCommand(cmd.NewSearchCommand())
+	rootCmd.AddCommand(cmd.NewRemoteCommand())
+	rootCmd.AddCommand(cmd.NewRefreshCommand())
 	rootCmd.AddCommand(cmd.NewShellCommand())
 	rootCmd.AddCommand(cmd.NewRunCommand())
 	rootCmd.AddCommand(cmd.NewSpawnCommand())
 	rootCmd.AddCommand(cmd.NewServiceCommand())
+	rootCmd.AddCommand(cmd.NewAppServiceCommand())
+	rootCmd.AddCommand(cmd.NewDeviceAttachCommand())
+	rootCmd.AddCommand(cmd.NewHostPathAttachCommand())
 	rootCmd.AddCommand(cmd.NewStopCommand())
+	rootCmd.AddCommand(cmd.NewCommitCommand())
+	rootCmd.AddCommand(cmd.NewDiffCommand())
 	rootCmd.AddCommand(cmd.NewDedupCommand())
 	rootCmd.AddCommand(cmd.NewAuditCommand())
+	rootCmd.AddCommand(cmd.NewAuditLogCommand())
 	rootCmd.AddCommand(cmd.NewOverrideCommand())
+	rootCmd.AddCommand(cmd.NewProfileCommand())
+	rootCmd.AddCommand(cmd.NewGpuCommand())
+	rootCmd.AddCommand(cmd.NewEnvCommand())
+	rootCmd.AddCommand(cmd.NewAliasCommand())
 	rootCmd.AddCommand(cmd.NewExtractCommand())
 	rootCmd.AddCommand(cmd.NewInitCommand())
 	rootCmd.AddCommand(cmd.NewGenSchemaCommand())
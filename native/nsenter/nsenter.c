@@ -183,8 +183,15 @@ int main(int argc, char **argv)
         }
         if (c > 0)
         {
-            waitpid(c, NULL, 0);
-            return 0;
+            int status = 0;
+            if (waitpid(c, &status, 0) < 0)
+            {
+                perror("waitpid");
+                exit(1);
+            }
+            if (WIFSIGNALED(status))
+                return 128 + WTERMSIG(status);
+            return WEXITSTATUS(status);
         }
     }
     if (!preserve)
@@ -0,0 +1,188 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// SetAlias sets the command with the given name to run a binary of the
+// application of the given remote, with the given default arguments. The
+// remote is an origin, optionally followed by @ and the version, branch,
+// release or commit to pin; the most recently installed version is run
+// otherwise. The binary defaults to the one of the application named after
+// the alias.
+func (c *Cpak) SetAlias(name string, remote string, binary string, args []string) (err error) {
+	if name == "" || strings.ContainsAny(name, "/\x00") || name == "." || name == ".." {
+		return fmt.Errorf("invalid alias name: %s", name)
+	}
+
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	alias, app, err := resolveAliasRemote(store, remote)
+	if err != nil {
+		return
+	}
+	alias.Name = name
+	alias.Args = args
+
+	switch {
+	case binary == "":
+		if getProvidedBinary(app, name) == "" {
+			return fmt.Errorf("%s does not export a binary named %s, specify the binary to run", app.Origin, name)
+		}
+	case !strings.HasPrefix(binary, "/"):
+		// a binary name refers to one exported by the application
+		alias.Binary = getProvidedBinary(app, binary)
+		if alias.Binary == "" {
+			return fmt.Errorf("%s does not export a binary named %s, use its path to run a binary which is not exported", app.Origin, binary)
+		}
+	default:
+		alias.Binary = filepath.Clean(binary)
+	}
+
+	err = store.SaveAlias(alias)
+	if err != nil {
+		return
+	}
+	return c.updateCommand(store, name, "")
+}
+
+// RemoveAlias removes the alias with the given name, the command is
+// exported again from the applications exporting a binary with that name,
+// if any.
+func (c *Cpak) RemoveAlias(name string) (err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	alias, err := store.GetAlias(name)
+	if err != nil {
+		return
+	}
+	if alias.Name == "" {
+		return fmt.Errorf("alias %s not found", name)
+	}
+
+	err = store.RemoveAlias(name)
+	if err != nil {
+		return
+	}
+	return c.updateCommand(store, name, alias.Origin)
+}
+
+// GetCommands returns the commands of the managed bin directory and the
+// aliases, along with the alternatives to them, sorted by name.
+func (c *Cpak) GetCommands() (commands []types.Command, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	apps, err := store.GetApplications()
+	if err != nil {
+		return
+	}
+	aliases, err := store.GetAliases()
+	if err != nil {
+		return
+	}
+
+	// providers maps the command names to the applications exporting them
+	providers := map[string][]types.Application{}
+	for _, app := range apps {
+		if app.DerivedFrom != "" {
+			continue
+		}
+		for _, binary := range app.ParsedBinaries {
+			name := filepath.Base(binary)
+			providers[name] = append(providers[name], app)
+		}
+	}
+	aliasesByName := map[string]types.Alias{}
+	for _, alias := range aliases {
+		aliasesByName[alias.Name] = alias
+		if _, ok := providers[alias.Name]; !ok {
+			providers[alias.Name] = nil
+		}
+	}
+
+	for name, alternatives := range providers {
+		command := types.Command{Name: name, Alternatives: []string{}}
+		alias, isAlias := aliasesByName[name]
+		command.Alias = isAlias
+		command.Args = alias.Args
+
+		record, err := store.GetExportedFile(filepath.Join(c.GetExportsBinDir(), name))
+		if err != nil {
+			return nil, err
+		}
+		if record.Path != "" {
+			command.Binary = record.Source
+			if owner, err := store.GetApplicationByCpakId(record.AppCpakId); err == nil {
+				command.Origin = getAppRemote(owner)
+				command.Version = owner.Version
+			}
+		} else if isAlias {
+			// the application of the alias is not installed
+			command.Origin = alias.Origin
+			command.Binary = alias.Binary
+		}
+
+		for _, app := range alternatives {
+			if app.CpakId != record.AppCpakId {
+				command.Alternatives = append(command.Alternatives, getAppRemote(app))
+			}
+		}
+		commands = append(commands, command)
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands, nil
+}
+
+// resolveAliasRemote returns the alias selecting the application of the
+// given remote, along with the application itself, which must be
+// installed.
+func resolveAliasRemote(store *Store, remote string) (alias types.Alias, app types.Application, err error) {
+	origin, selector, pinned := strings.Cut(strings.ToLower(remote), "@")
+	alias.Origin = origin
+
+	apps, err := store.GetApplicationsByOrigin(origin, "", "", "", "")
+	if err != nil {
+		return
+	}
+	for _, candidate := range apps {
+		matches := selector == candidate.Version || selector == candidate.Branch || selector == candidate.Release ||
+			(candidate.Commit != "" && strings.HasPrefix(candidate.Commit, selector))
+		if !pinned {
+			return alias, candidate, nil
+		}
+		if matches {
+			alias.Branch, alias.Commit, alias.Release = candidate.Branch, candidate.Commit, candidate.Release
+			return alias, candidate, nil
+		}
+	}
+
+	if pinned {
+		return alias, app, fmt.Errorf("application %s is not installed in version %s", origin, selector)
+	}
+	return alias, app, fmt.Errorf("application %s is not installed", origin)
+}
@@ -0,0 +1,418 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// ErrSystemdNotFound is returned when the services are managed on a host
+// without systemd.
+var ErrSystemdNotFound = errors.New("systemctl not found, services require systemd")
+
+// getSystemdUnitsDir returns the directory the systemd units of the
+// services are exported to, the one for the units installed by packages
+// in the home, so that the ones in ~/.config/systemd/user can override
+// them.
+func getSystemdUnitsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "systemd", "user"), nil
+}
+
+func getDBusServicesDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "dbus-1", "services"), nil
+}
+
+// getDBusWrappersDir returns the directory of the scripts the D-Bus
+// activation files run, which must be absolute paths.
+func (c *Cpak) getDBusWrappersDir() string {
+	return filepath.Join(c.Options.ExportsPath, "libexec")
+}
+
+// GetServiceUnitName returns the name of the systemd unit of the given
+// service of the application.
+func GetServiceUnitName(app types.Application, service types.Service) string {
+	return "cpak-" + exportName(app) + "-" + service.Name + ".service"
+}
+
+// validateServices checks the services and D-Bus services of the given
+// manifest, which the schema does not fully cover.
+func validateServices(manifest *types.CpakManifest) error {
+	names := []string{}
+	for _, service := range manifest.Services {
+		if service.Name == "" || strings.ContainsAny(service.Name, "/ ") {
+			return fmt.Errorf("invalid service name: %q", service.Name)
+		}
+		if slices.Contains(names, service.Name) {
+			return fmt.Errorf("duplicate service %s", service.Name)
+		}
+		if len(service.Exec) == 0 || !filepath.IsAbs(service.Exec[0]) {
+			return fmt.Errorf("the exec of service %s must start with an absolute path", service.Name)
+		}
+		if hasControlChars(service.Name, service.Description, service.Restart, service.Target) || hasControlChars(service.Exec...) {
+			return fmt.Errorf("service %q must not contain control characters", service.Name)
+		}
+		names = append(names, service.Name)
+	}
+
+	busNames := []string{}
+	for _, dbusService := range manifest.DBusServices {
+		if dbusService.Name == "" || strings.ContainsAny(dbusService.Name, "/ ") {
+			return fmt.Errorf("invalid D-Bus service name: %q", dbusService.Name)
+		}
+		if slices.Contains(busNames, dbusService.Name) {
+			return fmt.Errorf("duplicate D-Bus service %s", dbusService.Name)
+		}
+		if len(dbusService.Exec) == 0 || !filepath.IsAbs(dbusService.Exec[0]) {
+			return fmt.Errorf("the exec of D-Bus service %s must start with an absolute path", dbusService.Name)
+		}
+		if hasControlChars(dbusService.Name) || hasControlChars(dbusService.Exec...) {
+			return fmt.Errorf("D-Bus service %q must not contain control characters", dbusService.Name)
+		}
+		if dbusService.Service != "" && !slices.Contains(names, dbusService.Service) {
+			return fmt.Errorf("D-Bus service %s refers to unknown service %s", dbusService.Name, dbusService.Service)
+		}
+		busNames = append(busNames, dbusService.Name)
+	}
+	return nil
+}
+
+// hasControlChars reports whether any of the given strings holds a control
+// character, e.g. a newline which would start a new key in a unit file.
+func hasControlChars(values ...string) bool {
+	for _, value := range values {
+		if strings.ContainsFunc(value, unicode.IsControl) {
+			return true
+		}
+	}
+	return false
+}
+
+// exportServices exports the services of the given application as systemd
+// user units, and its D-Bus services as activation files running scripts
+// in the exports directory. The units run the service with cpak run, which
+// starts the application container if needed and relays the signals to
+// the service process.
+func (c *Cpak) exportServices(store *Store, app types.Application) error {
+	if len(app.ParsedServices)+len(app.ParsedDBusServices) == 0 {
+		return nil
+	}
+	cpakBinary, err := getCpakBinary()
+	if err != nil {
+		return err
+	}
+
+	unitsDir, err := getSystemdUnitsDir()
+	if err != nil {
+		return err
+	}
+	for _, service := range app.ParsedServices {
+		unitPath := filepath.Join(unitsDir, GetServiceUnitName(app, service))
+		unit, err := getServiceUnit(cpakBinary, app, service)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		err = writeExport(store, app, types.ExportSystemdUnit, service.Name, unitPath, unit, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	dbusDir, err := getDBusServicesDir()
+	if err != nil {
+		return err
+	}
+	for _, dbusService := range app.ParsedDBusServices {
+		wrapperPath := filepath.Join(c.getDBusWrappersDir(), "cpak-"+exportName(app)+"-"+dbusService.Name)
+		runArgs := append([]string{cpakBinary}, getServiceRunArgs(app, dbusService.Exec)...)
+		for i, arg := range runArgs {
+			runArgs[i] = shellQuote(arg)
+		}
+		script := fmt.Sprintf("#!/bin/sh\nexec %s \"$@\"\n", strings.Join(runArgs, " "))
+		err = writeExport(store, app, types.ExportDBusService, dbusService.Name, wrapperPath, []byte(script), 0755)
+		if err != nil {
+			return err
+		}
+
+		activation := unitFile{}
+		activation.section("D-BUS Service")
+		activation.set("Name", dbusService.Name)
+		activation.set("Exec", wrapperPath)
+		if index := slices.IndexFunc(app.ParsedServices, func(s types.Service) bool {
+			return s.Name == dbusService.Service
+		}); index >= 0 {
+			activation.set("SystemdService", GetServiceUnitName(app, app.ParsedServices[index]))
+		}
+		data, err := activation.bytes()
+		if err != nil {
+			return fmt.Errorf("D-Bus service %s: %w", dbusService.Name, err)
+		}
+		activationPath := filepath.Join(dbusDir, dbusService.Name+".service")
+		err = writeExport(store, app, types.ExportDBusService, dbusService.Name, activationPath, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	reloadServiceManagers()
+	return nil
+}
+
+// getServiceUnit returns the systemd unit running the given service.
+func getServiceUnit(cpakBinary string, app types.Application, service types.Service) ([]byte, error) {
+	description := service.Description
+	if description == "" {
+		description = app.Name + " " + service.Name
+	}
+	restart := service.Restart
+	if restart == "" {
+		restart = "on-failure"
+	}
+
+	execArgs := append([]string{cpakBinary}, getServiceRunArgs(app, service.Exec)...)
+	for i, arg := range execArgs {
+		execArgs[i] = systemdQuote(arg)
+	}
+
+	unit := unitFile{}
+	unit.section("Unit")
+	unit.set("Description", strings.ReplaceAll(description, "%", "%%"))
+	unit.set(desktopKeyOrigin, app.Origin)
+	unit.set(desktopKeyId, app.CpakId)
+	wantedBy := "default.target"
+	if service.Target == types.ServiceTargetGraphical {
+		wantedBy = "graphical-session.target"
+		unit.set("PartOf", "graphical-session.target")
+		unit.set("After", "graphical-session.target")
+	}
+
+	unit.section("Service")
+	unit.set("Type", "exec")
+	unit.set("ExecStart", strings.Join(execArgs, " "))
+	unit.set("Restart", restart)
+	// the application container may have been started by the service and
+	// is shared with the other processes of the application, so only cpak
+	// run is signaled on stop, which relays it to the service process
+	unit.set("KillMode", "process")
+
+	unit.section("Install")
+	unit.set("WantedBy", wantedBy)
+	return unit.bytes()
+}
+
+// unitFile builds a systemd unit or a D-Bus activation file, both made of
+// sections of key=value lines. Every value is checked, since one holding a
+// control character, e.g. a newline, could add keys to the file.
+type unitFile struct {
+	b   strings.Builder
+	err error
+}
+
+func (u *unitFile) section(name string) {
+	if u.b.Len() > 0 {
+		u.b.WriteString("\n")
+	}
+	u.b.WriteString("[" + name + "]\n")
+}
+
+func (u *unitFile) set(key string, value string) {
+	if hasControlChars(value) {
+		if u.err == nil {
+			u.err = fmt.Errorf("invalid value for %s: %q holds control characters", key, value)
+		}
+		return
+	}
+	u.b.WriteString(key + "=" + value + "\n")
+}
+
+// bytes returns the file content, or the error of the first invalid value.
+func (u *unitFile) bytes() ([]byte, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	return []byte(u.b.String()), nil
+}
+
+// getServiceRunArgs returns the cpak run arguments running the given exec
+// line of a service in the installed version of the application.
+func getServiceRunArgs(app types.Application, execLine []string) []string {
+	args := append([]string{"run"}, getSelectorFlags(app.Branch, app.Commit, app.Release)...)
+	args = append(args, "--", app.Origin, "@"+execLine[0])
+	return append(args, execLine[1:]...)
+}
+
+// systemdQuote quotes the given argument for an Exec line of a systemd
+// unit, escaping the specifiers and the variables.
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
+
+// runSystemctl runs systemctl for the user service manager, returning its
+// trimmed output.
+func runSystemctl(args ...string) (string, error) {
+	path, err := exec.LookPath("systemctl")
+	if err != nil {
+		return "", ErrSystemdNotFound
+	}
+	out, err := exec.Command(path, append([]string{"--user"}, args...)...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// reloadServiceManagers makes the user service manager and the session bus
+// look up the exported units and activation files again.
+func reloadServiceManagers() {
+	_, err := runSystemctl("daemon-reload")
+	if errors.Is(err, ErrSystemdNotFound) {
+		logger.Println("Warning: systemctl not found, the services will not be started")
+	} else if err != nil {
+		logger.Printf("Warning: failed to reload the user service manager: %v", err)
+	}
+
+	// dbus-daemon watches the services directories, dbus-broker needs to
+	// be told
+	if path, lookErr := exec.LookPath("busctl"); lookErr == nil {
+		_ = exec.Command(path, "--user", "call", "org.freedesktop.DBus", "/org/freedesktop/DBus",
+			"org.freedesktop.DBus", "ReloadConfig").Run()
+	}
+}
+
+// enableAutostartServices enables and starts the services of the given
+// application which are meant to start with the session.
+func enableAutostartServices(app types.Application) {
+	for _, service := range app.ParsedServices {
+		if !service.Autostart {
+			continue
+		}
+		unit := GetServiceUnitName(app, service)
+		if _, err := runSystemctl("enable", "--now", unit); err != nil {
+			logger.Printf("Warning: failed to enable service %s of %s: %v", service.Name, app.Name, err)
+			continue
+		}
+		logger.Printf("Enabled service %s of %s (%s)", service.Name, app.Name, unit)
+	}
+}
+
+// EnableServices enables and starts the given services of the application
+// of the given remote, all of them if none is given. Enabled services are
+// started along with the user session.
+func (c *Cpak) EnableServices(origin string, version string, branch string, commit string, release string, names []string) error {
+	return c.manageServices(origin, version, branch, commit, release, names, "enable", "--now")
+}
+
+// DisableServices stops and disables the given services of the application
+// of the given remote, all of them if none is given.
+func (c *Cpak) DisableServices(origin string, version string, branch string, commit string, release string, names []string) error {
+	return c.manageServices(origin, version, branch, commit, release, names, "disable", "--now")
+}
+
+func (c *Cpak) manageServices(origin string, version string, branch string, commit string, release string, names []string, args ...string) error {
+	app, services, err := c.getAppServices(origin, version, branch, commit, release, names)
+	if err != nil {
+		return err
+	}
+
+	unitsDir, err := getSystemdUnitsDir()
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		unit := GetServiceUnitName(app, service)
+		if _, err := os.Stat(filepath.Join(unitsDir, unit)); err != nil {
+			return fmt.Errorf("service %s is not exported, run cpak audit --repair: %w", service.Name, err)
+		}
+		if _, err := runSystemctl(append(args, unit)...); err != nil {
+			return fmt.Errorf("failed to %s service %s: %w", args[0], service.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetServicesStatus returns the state of the services of the application
+// of the given remote, as reported by systemd.
+func (c *Cpak) GetServicesStatus(origin string, version string, branch string, commit string, release string) (statuses []types.ServiceStatus, err error) {
+	app, services, err := c.getAppServices(origin, version, branch, commit, release, nil)
+	if err != nil {
+		return
+	}
+
+	for _, service := range services {
+		status := types.ServiceStatus{
+			Name:     service.Name,
+			Unit:     GetServiceUnitName(app, service),
+			BusNames: []string{},
+		}
+		// both exit with a failure for disabled or inactive units, the
+		// state is printed anyway
+		status.Enabled, err = runSystemctl("is-enabled", status.Unit)
+		if errors.Is(err, ErrSystemdNotFound) {
+			return nil, err
+		}
+		status.Active, _ = runSystemctl("is-active", status.Unit)
+		for _, dbusService := range app.ParsedDBusServices {
+			if dbusService.Service == service.Name {
+				status.BusNames = append(status.BusNames, dbusService.Name)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// getAppServices returns the application of the given remote along with
+// its services with the given names, all of them if none is given.
+func (c *Cpak) getAppServices(origin string, version string, branch string, commit string, release string, names []string) (app types.Application, services []types.Service, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	app, err = store.GetApplicationByOrigin(origin, version, branch, commit, release)
+	if err != nil {
+		return app, nil, fmt.Errorf("application %s not found: %w", origin, err)
+	}
+	if len(app.ParsedServices) == 0 {
+		return app, nil, fmt.Errorf("application %s has no services", origin)
+	}
+
+	if len(names) == 0 {
+		return app, app.ParsedServices, nil
+	}
+	for _, name := range names {
+		index := slices.IndexFunc(app.ParsedServices, func(s types.Service) bool {
+			return s.Name == name
+		})
+		if index < 0 {
+			return app, nil, fmt.Errorf("application %s has no service %s", origin, name)
+		}
+		services = append(services, app.ParsedServices[index])
+	}
+	return app, services, nil
+}
@@ -0,0 +1,493 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// metainfoDirs are the directories the AppStream metainfo files are looked
+// up in, the second one is the legacy location.
+var metainfoDirs = []string{"usr/share/metainfo", "usr/share/appdata"}
+
+// catalogOrigin is the origin of the exported AppStream catalog, software
+// centers show it as the source of the applications.
+const catalogOrigin = "cpak"
+
+// appStreamText is a translatable element of a metainfo file.
+type appStreamText struct {
+	Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+// appStreamComponent is the subset of an AppStream component cpak reads.
+type appStreamComponent struct {
+	XMLName xml.Name        `xml:"component"`
+	Type    string          `xml:"type,attr"`
+	Id      string          `xml:"id"`
+	Names   []appStreamText `xml:"name"`
+	Summary []appStreamText `xml:"summary"`
+	Desc    struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"description"`
+	ProjectLicense string `xml:"project_license"`
+	Urls           []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"url"`
+	Categories  []string        `xml:"categories>category"`
+	Keywords    []appStreamText `xml:"keywords>keyword"`
+	Screenshots []struct {
+		Captions []appStreamText `xml:"caption"`
+		Images   []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"image"`
+	} `xml:"screenshots>screenshot"`
+	ContentRating struct {
+		Attributes []struct {
+			Id    string `xml:"id,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"content_attribute"`
+	} `xml:"content_rating"`
+	Launchables []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"launchable"`
+	ProvidedBinaries []string `xml:"provides>binary"`
+}
+
+// getCatalogPath returns the path of the AppStream catalog listing the
+// installed applications, in the user catalog directory.
+func getCatalogPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "swcatalog", "xml", "cpak.xml"), nil
+}
+
+// getAppInfo returns the metadata of the given application, the one of the
+// manifest completed with the metainfo file shipped in its layers, if any.
+func (c *Cpak) getAppInfo(app types.Application, manifest *types.CpakManifest) types.AppInfo {
+	info := types.AppInfo{AppMetadata: manifest.AppMetadata}
+
+	component, err := c.findMetainfo(app)
+	if err != nil {
+		logger.Printf("Warning: failed to read the metainfo of %s: %v", app.Name, err)
+	}
+	if component != nil {
+		info.Id = component.Id
+		info.Description = appStreamDescriptionText(component.Desc.Inner)
+		if info.Summary == "" {
+			info.Summary = untranslated(component.Summary)
+		}
+		if info.License == "" {
+			info.License = strings.TrimSpace(component.ProjectLicense)
+		}
+		if info.Homepage == "" {
+			for _, url := range component.Urls {
+				if url.Type == "homepage" {
+					info.Homepage = strings.TrimSpace(url.Value)
+				}
+			}
+		}
+		if len(info.Categories) == 0 {
+			info.Categories = component.Categories
+		}
+		if len(info.Keywords) == 0 {
+			for _, keyword := range component.Keywords {
+				if keyword.Lang == "" {
+					info.Keywords = append(info.Keywords, strings.TrimSpace(keyword.Value))
+				}
+			}
+		}
+		if len(info.Screenshots) == 0 {
+			for _, screenshot := range component.Screenshots {
+				for _, image := range screenshot.Images {
+					if image.Type != "thumbnail" {
+						info.Screenshots = append(info.Screenshots, types.Screenshot{
+							Url:     strings.TrimSpace(image.Value),
+							Caption: untranslated(screenshot.Captions),
+						})
+						break
+					}
+				}
+			}
+		}
+		if len(info.ContentRating) == 0 && len(component.ContentRating.Attributes) > 0 {
+			info.ContentRating = map[string]string{}
+			for _, attribute := range component.ContentRating.Attributes {
+				info.ContentRating[attribute.Id] = strings.TrimSpace(attribute.Value)
+			}
+		}
+	}
+
+	if info.Summary == "" {
+		info.Summary = manifest.Description
+	}
+	if info.Description == "" {
+		info.Description = manifest.Description
+	}
+	return info
+}
+
+// findMetainfo returns the AppStream component of the given application,
+// read from the metainfo files of its layers. Images usually ship the
+// metainfo of other packages too, so only a component launching one of
+// the desktop entries or providing one of the binaries of the application
+// is returned, nil if there is none. Invalid metainfo files are skipped.
+func (c *Cpak) findMetainfo(app types.Application) (*appStreamComponent, error) {
+	desktopIds := []string{}
+	for _, entry := range app.ParsedDesktopEntries {
+		desktopIds = append(desktopIds, filepath.Base(entry))
+	}
+	binaryNames := []string{}
+	for _, binary := range app.ParsedBinaries {
+		binaryNames = append(binaryNames, filepath.Base(binary))
+	}
+
+	seen := map[string]bool{}
+	for _, dir := range metainfoDirs {
+		for _, layer := range app.ParsedLayers {
+			files, _ := filepath.Glob(filepath.Join(c.GetInStoreDir("layers", layer), dir, "*.xml"))
+			for _, file := range files {
+				rel := filepath.Join(dir, filepath.Base(file))
+				if seen[rel] {
+					continue
+				}
+				seen[rel] = true
+
+				path := c.findInLayers(app, "/"+rel, 0)
+				if path == "" {
+					continue
+				}
+				component, err := readMetainfo(path)
+				if err != nil {
+					// a broken metainfo of another package must not hide
+					// the one of the application
+					logger.Printf("Skipping invalid metainfo %s: %v", rel, err)
+					continue
+				}
+				if component.matches(desktopIds, binaryNames) {
+					return component, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+func readMetainfo(path string) (*appStreamComponent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	component := &appStreamComponent{}
+	err = xml.Unmarshal(data, component)
+	if err != nil {
+		return nil, err
+	}
+	return component, nil
+}
+
+// matches tells whether the component launches one of the given desktop
+// entries or provides one of the given binaries. Components without
+// launchables are matched through their id, which was the desktop entry
+// name in older metainfo files.
+func (a *appStreamComponent) matches(desktopIds []string, binaryNames []string) bool {
+	for _, launchable := range a.Launchables {
+		if launchable.Type == "desktop-id" && slices.Contains(desktopIds, strings.TrimSpace(launchable.Value)) {
+			return true
+		}
+	}
+	id := strings.TrimSpace(a.Id)
+	if slices.Contains(desktopIds, id) || slices.Contains(desktopIds, id+".desktop") {
+		return true
+	}
+	for _, binary := range a.ProvidedBinaries {
+		if slices.Contains(binaryNames, strings.TrimSpace(binary)) {
+			return true
+		}
+	}
+	return false
+}
+
+// untranslated returns the value of the given element without language,
+// the first one if all have one.
+func untranslated(texts []appStreamText) string {
+	for _, text := range texts {
+		if text.Lang == "" {
+			return strings.TrimSpace(text.Value)
+		}
+	}
+	if len(texts) > 0 {
+		return strings.TrimSpace(texts[0].Value)
+	}
+	return ""
+}
+
+// appStreamDescriptionText returns the given description markup as plain
+// text, paragraphs and lists separated by blank lines. Translated
+// paragraphs, used by older metainfo files, are skipped.
+func appStreamDescriptionText(markup string) string {
+	decoder := xml.NewDecoder(strings.NewReader("<description>" + markup + "</description>"))
+	blocks := []string{}
+	var block strings.Builder
+	skipDepth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 {
+				skipDepth++
+				continue
+			}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "lang" {
+					skipDepth = 1
+				}
+			}
+			if skipDepth == 0 && t.Name.Local == "li" {
+				block.WriteString("• ")
+			}
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			switch t.Name.Local {
+			case "p", "ul", "ol":
+				if text := strings.TrimSpace(block.String()); text != "" {
+					blocks = append(blocks, text)
+				}
+				block.Reset()
+			case "li":
+				block.WriteString("\n")
+			}
+		case xml.CharData:
+			if skipDepth == 0 {
+				block.WriteString(strings.Join(strings.Fields(string(t)), " "))
+			}
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// exportCatalog writes the AppStream catalog listing the installed
+// applications, so that software centers show them. Each origin is listed
+// once, with its most recently installed version.
+func (c *Cpak) exportCatalog(store *Store) {
+	catalogPath, err := getCatalogPath()
+	if err != nil {
+		return
+	}
+	apps, err := store.GetApplications()
+	if err != nil {
+		logger.Printf("Warning: failed to export the AppStream catalog: %v", err)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString("<components version=\"1.0\"" + xmlAttr("origin", catalogOrigin) + ">\n")
+	listed := map[string]bool{}
+	for _, app := range apps {
+		if app.DerivedFrom != "" || listed[app.Origin] {
+			continue
+		}
+		listed[app.Origin] = true
+		writeCatalogComponent(&b, app, getExportedIcon(store, app))
+	}
+	b.WriteString("</components>\n")
+
+	if len(listed) == 0 {
+		if err := os.Remove(catalogPath); err != nil && !os.IsNotExist(err) {
+			logger.Printf("Warning: failed to remove the AppStream catalog: %v", err)
+		}
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(catalogPath), 0755)
+	if err == nil {
+		err = os.WriteFile(catalogPath, []byte(b.String()), 0644)
+	}
+	if err != nil {
+		logger.Printf("Warning: failed to export the AppStream catalog: %v", err)
+	}
+}
+
+// writeCatalogComponent writes the AppStream component of the given
+// application, with the given stock icon if not empty.
+func writeCatalogComponent(b *strings.Builder, app types.Application, icon string) {
+	info := app.ParsedInfo
+	componentType := "console-application"
+	if len(app.ParsedDesktopEntries) > 0 {
+		componentType = "desktop-application"
+	}
+	id := info.Id
+	if id == "" {
+		id = "cpak." + exportName(app)
+	}
+
+	element := func(indent string, name string, attrs string, value string) {
+		if value == "" {
+			return
+		}
+		b.WriteString(indent + "<" + name + attrs + ">")
+		_ = xml.EscapeText(b, []byte(value))
+		b.WriteString("</" + name + ">\n")
+	}
+
+	b.WriteString("  <component" + xmlAttr("type", componentType) + ">\n")
+	element("    ", "id", "", id)
+	element("    ", "name", "", app.Name)
+	element("    ", "summary", "", info.Summary)
+	if info.Description != "" {
+		b.WriteString("    <description>\n")
+		for _, paragraph := range strings.Split(info.Description, "\n\n") {
+			if !strings.HasPrefix(paragraph, "• ") {
+				element("      ", "p", "", paragraph)
+				continue
+			}
+			b.WriteString("      <ul>\n")
+			for _, item := range strings.Split(paragraph, "\n") {
+				element("        ", "li", "", strings.TrimPrefix(item, "• "))
+			}
+			b.WriteString("      </ul>\n")
+		}
+		b.WriteString("    </description>\n")
+	}
+	element("    ", "project_license", "", info.License)
+	element("    ", "url", ` type="homepage"`, info.Homepage)
+	element("    ", "icon", ` type="stock"`, icon)
+	for _, entry := range app.ParsedDesktopEntries {
+		element("    ", "launchable", ` type="desktop-id"`, filepath.Base(entry))
+	}
+	if len(info.Categories) > 0 {
+		b.WriteString("    <categories>\n")
+		for _, category := range info.Categories {
+			element("      ", "category", "", category)
+		}
+		b.WriteString("    </categories>\n")
+	}
+	if len(info.Keywords) > 0 {
+		b.WriteString("    <keywords>\n")
+		for _, keyword := range info.Keywords {
+			element("      ", "keyword", "", keyword)
+		}
+		b.WriteString("    </keywords>\n")
+	}
+	if len(info.Screenshots) > 0 {
+		b.WriteString("    <screenshots>\n")
+		for i, screenshot := range info.Screenshots {
+			if i == 0 {
+				b.WriteString("      <screenshot type=\"default\">\n")
+			} else {
+				b.WriteString("      <screenshot>\n")
+			}
+			element("        ", "caption", "", screenshot.Caption)
+			element("        ", "image", ` type="source"`, screenshot.Url)
+			b.WriteString("      </screenshot>\n")
+		}
+		b.WriteString("    </screenshots>\n")
+	}
+	if info.ContentRating != nil {
+		b.WriteString("    <content_rating type=\"oars-1.1\">\n")
+		attributes := make([]string, 0, len(info.ContentRating))
+		for attribute := range info.ContentRating {
+			attributes = append(attributes, attribute)
+		}
+		sort.Strings(attributes)
+		for _, attribute := range attributes {
+			element("      ", "content_attribute", xmlAttr("id", attribute), info.ContentRating[attribute])
+		}
+		b.WriteString("    </content_rating>\n")
+	}
+	if len(app.ParsedBinaries) > 0 {
+		b.WriteString("    <provides>\n")
+		for _, binary := range app.ParsedBinaries {
+			element("      ", "binary", "", filepath.Base(binary))
+		}
+		b.WriteString("    </provides>\n")
+	}
+	if app.Version != "" {
+		b.WriteString("    <releases>\n")
+		b.WriteString("      <release" + xmlAttr("version", app.Version) + xmlAttr("timestamp", strconv.FormatInt(app.InstallTimestamp.Unix(), 10)) + "/>\n")
+		b.WriteString("    </releases>\n")
+	}
+	b.WriteString("    <custom>\n")
+	element("      ", "value", ` key="cpak::origin"`, app.Origin)
+	b.WriteString("    </custom>\n")
+	b.WriteString("  </component>\n")
+}
+
+// xmlAttr returns the given XML attribute, preceded by a space, with its
+// value escaped.
+func xmlAttr(name string, value string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(value))
+	return " " + name + "=\"" + b.String() + "\""
+}
+
+// getExportedIcon returns the name of the icon the desktop entries of the
+// given application were exported with, empty if there is none.
+func getExportedIcon(store *Store, app types.Application) string {
+	files, err := store.GetExportedFiles(app.CpakId)
+	if err != nil {
+		return ""
+	}
+	for _, file := range files {
+		if file.Kind != types.ExportDesktopEntry {
+			continue
+		}
+		data, err := os.ReadFile(file.Path)
+		if err != nil {
+			continue
+		}
+		entry, err := tools.ParseDesktopEntry(data)
+		if err != nil {
+			continue
+		}
+		if icon, ok := entry.Main().Get("Icon"); ok && icon != "" {
+			return icon
+		}
+	}
+	return ""
+}
+
+// GetAppInfo returns the most recently installed version of the
+// application of the given origin matching the given version, branch,
+// commit or release, its metadata is in ParsedInfo.
+func (c *Cpak) GetAppInfo(origin string, version string, branch string, commit string, release string) (app types.Application, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	apps, err := store.GetApplicationsByOrigin(origin, version, branch, commit, release)
+	if err != nil {
+		return
+	}
+	if len(apps) == 0 {
+		return app, fmt.Errorf("application %s is not installed", origin)
+	}
+	return apps[0], nil
+}
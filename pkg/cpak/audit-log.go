@@ -0,0 +1,180 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"golang.org/x/sys/unix"
+)
+
+// auditLogMaxSize is the size after which the audit log is rotated.
+const auditLogMaxSize = 10 * 1024 * 1024
+
+// auditLogBackups is the number of rotated audit logs which are kept.
+const auditLogBackups = 5
+
+// AuditLogFilter selects the events returned by ReadAuditLog, empty fields
+// match any event.
+type AuditLogFilter struct {
+	AppIds []string
+	Type   string
+	Since  time.Time
+	Until  time.Time
+}
+
+// GetAuditLogPath returns the path to the audit log of the current user,
+// which lives in $XDG_STATE_HOME, so that it is shared by all the cpak
+// processes, including the hostexec servers, regardless of the options.
+func GetAuditLogPath() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateHome, "cpak", "audit.log"), nil
+}
+
+// RecordAuditEvent appends the given event to the audit log, its time is
+// set if missing. Failures are only logged, since auditing must not break
+// the operation being audited.
+func RecordAuditEvent(event types.AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	err := appendAuditEvent(event)
+	if err != nil {
+		logger.Printf("Warning: failed to record audit event: %v", err)
+	}
+}
+
+func appendAuditEvent(event types.AuditEvent) error {
+	path, err := GetAuditLogPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// the lock file serializes writers and rotation across processes, the
+	// log itself cannot be used since it is replaced when rotated
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	err = unix.Flock(int(lock.Fd()), unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	if info, statErr := os.Stat(path); statErr == nil && info.Size()+int64(len(line)) >= auditLogMaxSize {
+		err = rotateAuditLog(path)
+		if err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// rotateAuditLog shifts the rotated logs, dropping the oldest one, and
+// moves the current log to audit.log.1.
+func rotateAuditLog(path string) error {
+	_ = os.Remove(fmt.Sprintf("%s.%d", path, auditLogBackups))
+	for i := auditLogBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+// ReadAuditLog returns the events of the audit log, rotated logs included,
+// matching the given filter, from the oldest to the newest.
+func ReadAuditLog(filter AuditLogFilter) (events []types.AuditEvent, err error) {
+	path, err := GetAuditLogPath()
+	if err != nil {
+		return
+	}
+
+	paths := []string{}
+	for i := auditLogBackups; i >= 1; i-- {
+		paths = append(paths, fmt.Sprintf("%s.%d", path, i))
+	}
+	paths = append(paths, path)
+
+	for _, logPath := range paths {
+		file, openErr := os.Open(logPath)
+		if openErr != nil {
+			if os.IsNotExist(openErr) {
+				continue
+			}
+			return nil, openErr
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event types.AuditEvent
+			if json.Unmarshal(scanner.Bytes(), &event) != nil {
+				continue
+			}
+			if filter.matches(event) {
+				events = append(events, event)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", logPath, err)
+		}
+	}
+	return events, nil
+}
+
+func (f AuditLogFilter) matches(event types.AuditEvent) bool {
+	if len(f.AppIds) > 0 && !slices.Contains(f.AppIds, event.AppId) {
+		return false
+	}
+	if f.Type != "" && event.Type != f.Type {
+		return false
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && event.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,508 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// catalogNameRegex matches the valid names of the catalogs, they are used
+// as file names in the cache.
+var catalogNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// catalogIndexMaxSize is the maximum size of a catalog index, bigger ones
+// are refused.
+const catalogIndexMaxSize = 32 << 20
+
+// ErrCatalogAppNotFound is returned when no catalog lists an application
+// with the given name.
+var ErrCatalogAppNotFound = errors.New("application not found in the catalogs")
+
+// IsCatalogName tells whether the given remote is the short name of an
+// application in the catalogs rather than its origin.
+func IsCatalogName(remote string) bool {
+	return remote != "" && !strings.Contains(remote, "/")
+}
+
+// isRemoteCatalog tells whether the given catalog location is an HTTP(S)
+// URL rather than a local path.
+func isRemoteCatalog(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// getCatalogCachePath returns the path the verified index of the given
+// catalog is cached at.
+func (c *Cpak) getCatalogCachePath(name string) string {
+	return filepath.Join(c.Options.CachePath, "catalogs", name+".json")
+}
+
+// GetCatalogSources returns the catalogs of the configuration file followed
+// by the ones added by the user.
+func (c *Cpak) GetCatalogSources() (sources []types.CatalogSource, err error) {
+	for _, source := range c.Options.Sources {
+		source.Builtin = true
+		sources = append(sources, source)
+	}
+
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	userSources, err := store.GetCatalogSources()
+	if err != nil {
+		return
+	}
+	return append(sources, userSources...), nil
+}
+
+// AddCatalogSource adds the catalog with the given name, after fetching
+// and verifying its index. A local index can only be added without public
+// key if insecure is set.
+func (c *Cpak) AddCatalogSource(name string, url string, publicKey string, insecure bool) (err error) {
+	if !catalogNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid catalog name %s: only lowercase letters, digits, dots, dashes and underscores are allowed", name)
+	}
+	if !isRemoteCatalog(url) {
+		url, err = filepath.Abs(url)
+		if err != nil {
+			return
+		}
+	}
+
+	sources, err := c.GetCatalogSources()
+	if err != nil {
+		return
+	}
+	for _, source := range sources {
+		if source.Name == name {
+			return fmt.Errorf("catalog %s already exists", name)
+		}
+	}
+
+	if insecure && isRemoteCatalog(url) {
+		return errors.New("remote indexes must be signed, only local ones can be added as insecure")
+	}
+	source := types.CatalogSource{Name: name, Url: url, PublicKey: publicKey, Insecure: insecure && publicKey == ""}
+	index, err := c.refreshCatalog(source)
+	if err != nil {
+		return
+	}
+
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	err = store.SaveCatalogSource(source)
+	if err != nil {
+		return
+	}
+	logger.Printf("Catalog %s added, %d applications available", name, len(index.Apps))
+	return nil
+}
+
+// RemoveCatalogSource removes the catalog with the given name along with
+// its cached index. The catalogs of the configuration file cannot be
+// removed.
+func (c *Cpak) RemoveCatalogSource(name string) (err error) {
+	sources, err := c.GetCatalogSources()
+	if err != nil {
+		return
+	}
+	found := false
+	for _, source := range sources {
+		if source.Name != name {
+			continue
+		}
+		if source.Builtin {
+			return fmt.Errorf("catalog %s is set in the configuration file", name)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("catalog %s not found", name)
+	}
+
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	err = store.RemoveCatalogSource(name)
+	if err != nil {
+		return
+	}
+	err = os.Remove(c.getCatalogCachePath(name))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the cached index of %s: %w", name, err)
+	}
+	return nil
+}
+
+// RefreshCatalogs fetches again the index of every catalog, the cached
+// index of the catalogs which fail is kept.
+func (c *Cpak) RefreshCatalogs() error {
+	sources, err := c.GetCatalogSources()
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		logger.Println("No catalogs configured, add one with cpak remote add")
+		return nil
+	}
+
+	errs := []error{}
+	for _, source := range sources {
+		index, err := c.refreshCatalog(source)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Printf("Catalog %s refreshed, %d applications available", source.Name, len(index.Apps))
+	}
+	return errors.Join(errs...)
+}
+
+// refreshCatalog fetches the index of the given catalog and caches it,
+// once its signature and content are verified.
+func (c *Cpak) refreshCatalog(source types.CatalogSource) (index types.CatalogIndex, err error) {
+	data, err := readCatalogFile(source.Url)
+	if err != nil {
+		return index, fmt.Errorf("failed to fetch catalog %s: %w", source.Name, err)
+	}
+
+	if source.PublicKey == "" {
+		if isRemoteCatalog(source.Url) {
+			return index, fmt.Errorf("catalog %s has no public key, remote indexes must be signed", source.Name)
+		}
+		if !source.Insecure {
+			return index, fmt.Errorf("catalog %s has no public key, local indexes without key must be added as insecure", source.Name)
+		}
+		logger.Printf("Warning: catalog %s is insecure, its index is not verified", source.Name)
+	} else {
+		signature, err := readCatalogFile(source.Url + ".sig")
+		if err != nil {
+			return index, fmt.Errorf("failed to fetch the signature of catalog %s: %w", source.Name, err)
+		}
+		err = verifyCatalogSignature(data, signature, source.PublicKey)
+		if err != nil {
+			return index, fmt.Errorf("catalog %s: %w", source.Name, err)
+		}
+	}
+
+	index, err = parseCatalogIndex(data)
+	if err != nil {
+		return index, fmt.Errorf("invalid index of catalog %s: %w", source.Name, err)
+	}
+
+	cachePath := c.getCatalogCachePath(source.Name)
+	err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err != nil {
+		return
+	}
+	tmpPath := cachePath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpPath, cachePath)
+	return
+}
+
+// readCatalogFile reads the file at the given HTTP(S) URL or local path.
+func readCatalogFile(location string) ([]byte, error) {
+	var reader io.Reader
+	if isRemoteCatalog(location) {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", location, resp.Status)
+		}
+		reader = resp.Body
+	} else {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, catalogIndexMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > catalogIndexMaxSize {
+		return nil, fmt.Errorf("%s is bigger than %d bytes", location, catalogIndexMaxSize)
+	}
+	return data, nil
+}
+
+// verifyCatalogSignature verifies the given ed25519 signature of a catalog
+// index, either raw or encoded in base64.
+func verifyCatalogSignature(data []byte, signature []byte, publicKey string) error {
+	key, err := parseCatalogPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		signature, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return errors.New("the signature of the index does not match its public key")
+	}
+	return nil
+}
+
+// parseCatalogPublicKey parses an ed25519 public key encoded in base64,
+// either raw or in the PKIX DER form, e.g. the output of
+// openssl pkey -pubout -outform DER.
+func parseCatalogPublicKey(publicKey string) (ed25519.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(der) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(der), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid public key: not an ed25519 key")
+	}
+	return edKey, nil
+}
+
+// parseCatalogIndex parses and validates a catalog index.
+func parseCatalogIndex(data []byte) (index types.CatalogIndex, err error) {
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return
+	}
+
+	for i, app := range index.Apps {
+		if !IsCatalogName(app.Name) {
+			return index, fmt.Errorf("invalid name of application %d: %q", i, app.Name)
+		}
+		if len(strings.Split(app.Origin, "/")) != 3 {
+			return index, fmt.Errorf("invalid origin of %s: %q", app.Name, app.Origin)
+		}
+		for _, version := range app.Versions {
+			selectors := 0
+			for _, selector := range []string{version.Branch, version.Release, version.Commit} {
+				if selector != "" {
+					selectors++
+				}
+			}
+			if selectors != 1 {
+				return index, fmt.Errorf("version %s of %s must have one of branch, release and commit", version, app.Name)
+			}
+		}
+		index.Apps[i].Name = strings.ToLower(app.Name)
+		index.Apps[i].Origin = strings.ToLower(app.Origin)
+	}
+	return index, nil
+}
+
+// getCatalogApps returns the applications of every catalog, from their
+// cached index. Catalogs which were never refreshed are refreshed first.
+func (c *Cpak) getCatalogApps() (apps []types.CatalogApp, err error) {
+	sources, err := c.GetCatalogSources()
+	if err != nil {
+		return
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("no catalogs configured, add one with cpak remote add")
+	}
+
+	for _, source := range sources {
+		var index types.CatalogIndex
+		data, err := os.ReadFile(c.getCatalogCachePath(source.Name))
+		switch {
+		case os.IsNotExist(err):
+			index, err = c.refreshCatalog(source)
+		case err == nil:
+			index, err = parseCatalogIndex(data)
+		}
+		if err != nil {
+			logger.Printf("Warning: catalog %s skipped: %v", source.Name, err)
+			continue
+		}
+
+		for _, app := range index.Apps {
+			app.Catalog = source.Name
+			apps = append(apps, app)
+		}
+	}
+	return apps, nil
+}
+
+// SearchCatalogs returns the applications of the catalogs matching all the
+// words of the given term in their name, description or keywords, once
+// even if listed by several catalogs, the best matches first: matches in
+// the name rank higher than the ones in the keywords, which rank higher
+// than the ones in the description.
+func (c *Cpak) SearchCatalogs(term string) (results []types.CatalogApp, err error) {
+	apps, err := c.getCatalogApps()
+	if err != nil {
+		return
+	}
+
+	type match struct {
+		app   types.CatalogApp
+		score int
+	}
+	words := strings.Fields(strings.ToLower(term))
+	matches := []match{}
+	listed := map[string]bool{}
+	for _, app := range apps {
+		if listed[app.Origin] {
+			continue
+		}
+		if score := rankCatalogApp(app, words); score > 0 {
+			matches = append(matches, match{app, score})
+			listed[app.Origin] = true
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].app.Name < matches[j].app.Name
+	})
+
+	for _, match := range matches {
+		results = append(results, match.app)
+	}
+	return results, nil
+}
+
+// rankCatalogApp returns the score of the given application for the given
+// words, 0 if one of them does not match.
+func rankCatalogApp(app types.CatalogApp, words []string) int {
+	name := strings.ToLower(app.Name)
+	description := strings.ToLower(app.Summary + " " + app.Description)
+
+	score := 0
+	for _, word := range words {
+		wordScore := 0
+		switch {
+		case name == word:
+			wordScore = 100
+		case strings.HasPrefix(name, word):
+			wordScore = 50
+		case strings.Contains(name, word):
+			wordScore = 30
+		}
+		for _, keyword := range app.Keywords {
+			keyword = strings.ToLower(keyword)
+			if keyword == word {
+				wordScore = max(wordScore, 20)
+			} else if strings.Contains(keyword, word) {
+				wordScore = max(wordScore, 10)
+			}
+		}
+		if strings.Contains(description, word) {
+			wordScore = max(wordScore, 5)
+		}
+		if strings.Contains(app.Origin, word) {
+			wordScore = max(wordScore, 3)
+		}
+
+		if wordScore == 0 {
+			return 0
+		}
+		score += wordScore
+	}
+	return score
+}
+
+// ResolveCatalogName returns the application of the catalogs with the
+// given short name. The same application may be listed by several
+// catalogs, the first one is returned, but different applications with
+// the same name must be installed by origin.
+func (c *Cpak) ResolveCatalogName(name string) (app types.CatalogApp, err error) {
+	apps, err := c.getCatalogApps()
+	if err != nil {
+		return
+	}
+
+	matches := []types.CatalogApp{}
+	listed := map[string]bool{}
+	for _, candidate := range apps {
+		if candidate.Name != name || listed[candidate.Origin] {
+			continue
+		}
+		matches = append(matches, candidate)
+		listed[candidate.Origin] = true
+	}
+
+	switch len(matches) {
+	case 0:
+		return app, fmt.Errorf("%s: %w", name, ErrCatalogAppNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	candidates := []string{}
+	for _, match := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s (%s)", match.Origin, match.Catalog))
+	}
+	return app, fmt.Errorf("%s is provided by several applications, install it by origin: %s", name, strings.Join(candidates, ", "))
+}
+
+// ResolveRemote returns the origin of the given remote, resolving the
+// short names through the catalogs. The first version listed by the
+// catalog is returned when no branch, release or commit is given.
+func (c *Cpak) ResolveRemote(remote string, branch string, release string, commit string) (origin string, resolvedBranch string, resolvedRelease string, resolvedCommit string, err error) {
+	if !IsCatalogName(remote) {
+		return remote, branch, release, commit, nil
+	}
+
+	app, err := c.ResolveCatalogName(remote)
+	if err != nil {
+		return
+	}
+	logger.Printf("Resolved %s to %s from catalog %s", remote, app.Origin, app.Catalog)
+
+	if branch == "" && release == "" && commit == "" && len(app.Versions) > 0 {
+		return app.Origin, app.Versions[0].Branch, app.Versions[0].Release, app.Versions[0].Commit, nil
+	}
+	return app.Origin, branch, release, commit, nil
+}
@@ -0,0 +1,164 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// CdiSpecDirs are the directories the Container Device Interface specs are
+// read from, the later ones take precedence.
+var CdiSpecDirs = []string{"/etc/cdi", "/var/run/cdi"}
+
+// cdiSpec is the subset of a CDI spec supported by cpak, see
+// https://github.com/cncf-tags/container-device-interface/blob/main/SPEC.md
+// YAML is a superset of JSON, so both spec formats are read as YAML.
+type cdiSpec struct {
+	Kind           string            `yaml:"kind"`
+	Devices        []cdiDevice       `yaml:"devices"`
+	ContainerEdits cdiContainerEdits `yaml:"containerEdits"`
+}
+
+type cdiDevice struct {
+	Name           string            `yaml:"name"`
+	ContainerEdits cdiContainerEdits `yaml:"containerEdits"`
+}
+
+type cdiContainerEdits struct {
+	Env         []string        `yaml:"env"`
+	DeviceNodes []cdiDeviceNode `yaml:"deviceNodes"`
+	Mounts      []cdiMount      `yaml:"mounts"`
+	Hooks       []any           `yaml:"hooks"`
+}
+
+type cdiDeviceNode struct {
+	Path     string `yaml:"path"`
+	HostPath string `yaml:"hostPath"`
+}
+
+type cdiMount struct {
+	HostPath      string `yaml:"hostPath"`
+	ContainerPath string `yaml:"containerPath"`
+}
+
+// readCdiSpecs returns the CDI specs found in the CdiSpecDirs, by kind. A
+// spec which cannot be read is reported and skipped.
+func readCdiSpecs() map[string]cdiSpec {
+	specs := map[string]cdiSpec{}
+	for _, dir := range CdiSpecDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if entry.IsDir() || (ext != ".json" && ext != ".yaml") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Printf("Warning: failed to read the CDI spec %s: %v", path, err)
+				continue
+			}
+			spec := cdiSpec{}
+			err = yaml.Unmarshal(data, &spec)
+			if err != nil || spec.Kind == "" {
+				logger.Printf("Warning: invalid CDI spec %s: %v", path, err)
+				continue
+			}
+			specs[spec.Kind] = spec
+		}
+	}
+	return specs
+}
+
+// GetCdiDevices returns the fully qualified names of the CDI devices
+// available on the host, e.g. nvidia.com/gpu=0.
+func GetCdiDevices() (names []string) {
+	for kind, spec := range readCdiSpecs() {
+		for _, device := range spec.Devices {
+			names = append(names, kind+"="+device.Name)
+		}
+	}
+	slices.Sort(names)
+	return
+}
+
+// ResolveCdiDevices returns the files to bind and the environment variables
+// to set in a container for the given CDI devices, in the kind=name form;
+// kind=all selects all the devices of a kind. Hooks are not supported and
+// are skipped.
+func ResolveCdiDevices(names []string) (files []types.GpuFile, env []string, err error) {
+	if len(names) == 0 {
+		return
+	}
+	specs := readCdiSpecs()
+
+	set := gpuFileSet{}
+	applyEdits := func(edits cdiContainerEdits, origin string) {
+		if len(edits.Hooks) > 0 {
+			logger.Printf("Warning: the CDI hooks of %s are not supported and will be skipped", origin)
+		}
+		env = append(env, edits.Env...)
+		for _, node := range edits.DeviceNodes {
+			hostPath := node.HostPath
+			if hostPath == "" {
+				hostPath = node.Path
+			}
+			set.add(cdiGpuFile(hostPath, node.Path, types.GpuFileDevice))
+		}
+		for _, mount := range edits.Mounts {
+			set.add(cdiGpuFile(mount.HostPath, mount.ContainerPath, types.GpuFileMount))
+		}
+	}
+
+	appliedKinds := map[string]bool{}
+	for _, qualifiedName := range names {
+		kind, name, ok := strings.Cut(qualifiedName, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid CDI device %s, expected kind=name", qualifiedName)
+		}
+		spec, ok := specs[kind]
+		if !ok {
+			return nil, nil, fmt.Errorf("no CDI spec found for %s", kind)
+		}
+
+		found := false
+		for _, device := range spec.Devices {
+			if name == "all" || device.Name == name {
+				applyEdits(device.ContainerEdits, kind+"="+device.Name)
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("CDI device %s not found", qualifiedName)
+		}
+
+		// the spec edits apply once, whatever the number of devices
+		if !appliedKinds[kind] {
+			applyEdits(spec.ContainerEdits, kind)
+			appliedKinds[kind] = true
+		}
+	}
+	return set.files, env, nil
+}
+
+func cdiGpuFile(hostPath string, containerPath string, kind string) types.GpuFile {
+	file := types.GpuFile{Path: hostPath, Kind: kind}
+	if containerPath != "" && containerPath != hostPath {
+		file.Target = containerPath
+	}
+	return file
+}
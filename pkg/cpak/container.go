@@ -53,30 +53,44 @@ func (c *Cpak) PrepareContainer(app types.Application, override types.Override)
 		return
 	}
 
-	// If a container already exists, check if it is running
-	if len(containers) > 0 {
-		container = containers[0]
-		logger.Println("Container found:", container.CpakId)
-
-		// If the container is not running, we clean it up and create a new one
-		// by escaping the if statement
-		container.Pid, err = getPidFromEnvContainerId(container.CpakId)
-		if err != nil || container.Pid == 0 {
-			logger.Println("Container not running, cleaning it up:", container.CpakId)
-			err = c.CleanupContainer(container)
-			if err != nil {
-				return
-			}
-		} else {
-			logger.Println("Container already running, attaching to it:", container.CpakId)
+	// If a container already exists, check if it is running, the stopped
+	// ones are cleaned up, except for the persistent changes which are
+	// picked up by the new container when the override asks for them
+	var persistent types.Container
+	for _, existing := range containers {
+		logger.Println("Container found:", existing.CpakId)
+
+		running, _ := c.Runtime.Status(existing)
+		if running {
+			logger.Println("Container already running, attaching to it:", existing.CpakId)
+			return existing, nil
+		}
+
+		logger.Println("Container not running, cleaning it up:", existing.CpakId)
+		err = c.CleanupContainer(existing)
+		if err != nil {
 			return
 		}
+		if existing.Persistent && persistent.CpakId == "" {
+			persistent = existing
+		}
 	}
 
 	// If no container exists, create a new one and store it
 	// Note: the container's pid is not set here, it will be set when the
 	// container is started by the StartContainer function
 	newContainerCpakId := uuid.New().String()
+	createTimestamp := time.Now()
+	if override.Persistent && persistent.CpakId != "" {
+		logger.Println("Resuming persistent container:", persistent.CpakId)
+		newContainerCpakId = persistent.CpakId
+		createTimestamp = persistent.CreateTimestamp
+		err = store.RemoveContainerByCpakId(persistent.CpakId)
+		if err != nil {
+			return
+		}
+	}
+
 	statePath, err := c.GetInStoreDirMkdir("states", newContainerCpakId)
 	if err != nil {
 		return
@@ -92,13 +106,14 @@ func (c *Cpak) PrepareContainer(app types.Application, override types.Override)
 		CpakId:            newContainerCpakId,
 		ApplicationCpakId: app.CpakId,
 		StatePath:         statePath,
-		CreateTimestamp:   time.Now(),
+		CreateTimestamp:   createTimestamp,
+		Persistent:        override.Persistent,
 	}
 
 	container.HostExecSocketPath = filepath.Join(container.StatePath, "hostexec.sock")
 
 	// Start the hostexec server process
-	container.HostExecPid, err = c.startHostExecServerProcess(container.HostExecSocketPath, override.AllowedHostCommands)
+	container.HostExecPid, err = c.startHostExecServerProcess(app, container, override)
 	if err != nil {
 		logger.Println("Error starting hostexec server, cleaning up partially created container...")
 		os.Remove(container.HostExecSocketPath)
@@ -132,8 +147,8 @@ func (c *Cpak) PrepareContainer(app types.Application, override types.Override)
 // The config is used to set the environment the way the developer wants.
 // The container is started by calling our spawn function, which is the
 // responsible for setting up the pivot root, mounting the layers and
-// starting the init process, this via the rootlesskit binary which creates
-// a new namespace for the container.
+// starting the init process, this via the configured runtime which creates
+// the new namespaces for the container.
 func (c *Cpak) StartContainer(container types.Container, app types.Application, config *v1.ConfigFile, override types.Override) (rootfs string, pid int, err error) {
 	layers := ""
 	for _, layer := range app.ParsedLayers {
@@ -151,20 +166,19 @@ func (c *Cpak) StartContainer(container types.Container, app types.Application,
 	layersPath := c.GetInStoreDir("layers")
 	rootfs = c.GetInStoreDir("containers", container.CpakId, "rootfs")
 	overrideMounts, overrideShims := GetOverrideMounts(override)
-	cmds := []string{}
-	if isVerbose {
-		cmds = append(cmds, "--debug")
-	}
-	//"--net=slirp4netns",
-	cmds = append(cmds, []string{
-		"--cgroupns=true",
-		"--utsns=true",
-		"--ipcns=true",
-		"--copy-up=/etc",
-		"--propagation=rslave",
-		cpakBinary,
-		"spawn",
-	}...)
+	for _, mount := range overrideMounts {
+		// missing paths are skipped by spawn, so they are not granted
+		if _, statErr := os.Stat(mount); statErr == nil {
+			RecordAuditEvent(types.AuditEvent{
+				AppId:       app.CpakId,
+				ContainerId: container.CpakId,
+				Type:        types.AuditEventMount,
+				Decision:    types.AuditDecisionAllowed,
+				Detail:      mount,
+			})
+		}
+	}
+	cmds := []string{"spawn"}
 	if isVerbose {
 		cmds = append(cmds, "--verbose")
 	}
@@ -175,9 +189,34 @@ func (c *Cpak) StartContainer(container types.Container, app types.Application,
 	cmds = append(cmds, "--state-dir", container.StatePath)
 	cmds = append(cmds, "--layers", layers)
 	cmds = append(cmds, "--layers-dir", layersPath)
+	cmds = append(cmds, "--mount-method", c.Options.MountMethod)
+
+	serviceSocketDir, err := GetServiceSocketDir()
+	if err != nil {
+		return
+	}
+	cmds = append(cmds, "--service-socket-dir", serviceSocketDir)
+
+	// the devices plugged in while the container is running are bound by
+	// the service, one node at a time
+	deviceRules, err := GetDeviceRules(override)
+	if err != nil {
+		return
+	}
+	err = writeContainerDeviceRules(container, deviceRules)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write device rules: %w", err)
+	}
+
+	if container.Persistent {
+		persistDir, mkErr := c.getPersistentDirMkdir(container)
+		if mkErr != nil {
+			return "", 0, fmt.Errorf("failed to prepare persistent directory: %w", mkErr)
+		}
+		cmds = append(cmds, "--persist-dir", persistDir)
+	}
 
 	// Mount the main cpak binary into a known location inside the container
-	cpakInContainerPath := "/usr/local/bin/cpak"
 	cmds = append(cmds, "--extra-links", cpakBinary+":"+cpakInContainerPath)
 
 	// Pass AllowedHostCommands and SocketPath via environment variables to spawn
@@ -187,8 +226,8 @@ func (c *Cpak) StartContainer(container types.Container, app types.Application,
 		logger.Printf("Warning: HostExec socket path is empty for container %s during start.", container.CpakId)
 	}
 	// Join allowed commands into a single string (e.g., colon-separated) for the env var
-	allowedCmdsStr := strings.Join(override.AllowedHostCommands, ":")
-	cmds = append(cmds, "--env", "CPAK_ALLOWED_HOST_CMDS=xdg-open:"+allowedCmdsStr)
+	allowedCmdsStr := strings.Join(GetHostExecCommands(override), ":")
+	cmds = append(cmds, "--env", "CPAK_ALLOWED_HOST_CMDS="+allowedCmdsStr)
 
 	for _, envVar := range config.Config.Env {
 		cmds = append(cmds, "--env", envVar)
@@ -202,30 +241,43 @@ func (c *Cpak) StartContainer(container types.Container, app types.Application,
 		cmds = append(cmds, "--mount-shims", shim)
 	}
 
+	// the GPU drivers are discovered here rather than by spawn, so that the
+	// discovery cached in the store is used
+	gpuFiles, gpuEnv, err := c.GetGpuFiles(override)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get the GPU files: %w", err)
+	}
+	gpuFiles = c.dropImageGpuDependencies(app, gpuFiles)
+	for _, file := range gpuFiles {
+		if file.Target != "" {
+			cmds = append(cmds, "--gpu-files", file.Path+":"+file.Target)
+		} else {
+			cmds = append(cmds, "--gpu-files", file.Path)
+		}
+	}
+	for _, dir := range GetGpuLibraryDirs(gpuFiles) {
+		cmds = append(cmds, "--gpu-lib-dirs", dir)
+	}
+	for _, envVar := range gpuEnv {
+		cmds = append(cmds, "--env", envVar)
+	}
+
 	// following is where dependencies and addons are exported
 	cmds = append(cmds, "--env", "PATH="+fmt.Sprintf("%s/%s", c.Options.ExportsPath, app.CpakId)+":$PATH")
 
-	cmd := exec.Command(c.Options.RotlesskitBinPath, cmds...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), config.Config.Env...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Foreground: false,
-		Setsid:     true,
-	}
-
-	err = cmd.Run()
+	err = c.Runtime.Spawn(cpakBinary, cmds, config.Config.Env, override.Network)
 	if err != nil {
 		return
 	}
 
-	// The pid of the container is the pid of the init process
-	// and it is stored so that we can attach to it later
-	pid, err = getPidFromEnvContainerId(container.CpakId)
+	// The pid of the container is the pid of the init process, written by
+	// the spawn command to the pid file, it is stored so that we can attach
+	// to it later
+	pidFile, err := readContainerPidFile(container)
 	if err != nil {
 		return
 	}
+	pid = pidFile.Pid
 	store, err := NewStore(c.Options.StorePath)
 	if err != nil {
 		return
@@ -253,13 +305,12 @@ func (c *Cpak) StopContainer(app types.Application) (err error) {
 	}
 
 	for _, container := range containers {
-		currentPid := container.Pid
-		if currentPid == 0 {
-			currentPid, _ = getPidFromEnvContainerId(container.CpakId)
-		}
-		if currentPid != 0 {
-			logger.Println("Stopping container process:", currentPid)
-			syscall.Kill(currentPid, syscall.SIGTERM)
+		if container.Pid != 0 {
+			logger.Println("Stopping container process:", container.Pid)
+			stopErr := c.Runtime.Stop(container)
+			if stopErr != nil {
+				logger.Printf("Warning: error stopping container process %d: %v", container.Pid, stopErr)
+			}
 		}
 		cleanupErr := c.CleanupContainer(container)
 		if cleanupErr != nil {
@@ -293,97 +344,136 @@ func (c *Cpak) Stop(origin, version, branch, commit, release string) (err error)
 	return
 }
 
-// ExecInContainer uses nsenter to enter the pid namespace of the given
+// ExecInContainer uses the runtime to enter the namespaces of the given
 // container and execute the given command.
 func (c *Cpak) ExecInContainer(app types.Application, container types.Container, command []string) (err error) {
 	pidToEnter := container.Pid
 	if pidToEnter == 0 {
-		pidToEnter, err = getPidFromEnvContainerId(container.CpakId)
-		if err != nil {
-			return fmt.Errorf("container process %s not found: %w", container.CpakId, err)
+		pidFile, readErr := readContainerPidFile(container)
+		if readErr != nil {
+			return fmt.Errorf("container process %s not found: %w", container.CpakId, readErr)
 		}
+		pidToEnter = pidFile.Pid
 	}
 
-	uid := fmt.Sprintf("%d", os.Getuid())
-	gid := fmt.Sprintf("%d", os.Getgid())
-
-	cmds := []string{
-		"-m",
-		"-u",
-		"-U",
-		"--preserve-credentials",
-		"-i",
-		//"-n",
-		// "-p",
-		// "-S", strconv.FormatInt(int64(os.Getuid()), 10),
-		// "-G", strconv.FormatInt(int64(os.Getgid()), 10),
-		"-t",
-		fmt.Sprintf("%d", pidToEnter),
-		"--",
-	}
-
-	if !app.ParsedOverride.AsRoot {
-		cmds = append(
-			cmds,
-			"unshare",
-			"-U",
-			"--map-user="+uid,
-			"--map-group="+gid,
-			"--",
-		)
-	}
-	cmds = append(cmds, command...)
-
 	envVars := os.Environ()
 	envVars = append(envVars, "CPAK_CONTAINER_ID="+container.CpakId)
 	envVars = append(envVars, "CPAK_HOSTEXEC_SOCKET="+container.HostExecSocketPath)
+	if socketPath, socketErr := GetServiceSocketPath(); socketErr == nil {
+		envVars = append(envVars, "CPAK_SERVICE_SOCKET="+socketPath)
+	}
 
-	cmd := exec.Command(c.Options.NsenterBinPath, cmds...)
+	cmd := c.Runtime.Exec(pidToEnter, command, app.ParsedOverride.AsRoot)
 	logger.Println("Executing command:", cmd.String())
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Env = envVars
 
-	err = cmd.Run()
+	err = cmd.Start()
 	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			if exitErr.ExitCode() == 2 {
-				err = nil
-			}
-		}
 		return
 	}
+
+	// signals received by cpak are relayed to the containerized process, so
+	// that wrappers and service managers can control it as if it was run
+	// directly on the host
+	stopForwarding := tools.ForwardSignals(cmd.Process, forwardedSignals...)
+	err = cmd.Wait()
+	stopForwarding()
+
+	if code, ok := tools.ExitCode(err); ok {
+		return &ExitStatusError{Code: code}
+	}
 	return
 }
 
-// getPidFromEnvContainerId returns the pid of the process with the given containerId
-// by looking at the environment variables of all the processes.
-func getPidFromEnvContainerId(containerCpakId string) (pid int, err error) {
-	env := "CPAK_CONTAINER_ID=" + containerCpakId
-	pid, err = tools.GetPidFromEnv(env)
+// forwardedSignals is the list of signals relayed by cpak to the process
+// running in the container.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGWINCH,
+}
+
+// ExitStatusError is returned when the process executed in the container
+// terminates with a non-zero status. Code follows the shell convention, so
+// a process killed by signal N reports 128+N.
+type ExitStatusError struct {
+	Code int
+}
+
+func (e *ExitStatusError) Error() string {
+	return fmt.Sprintf("process exited with status %d", e.Code)
+}
+
+// cpakInContainerPath is where the cpak binary is bound inside the
+// containers.
+const cpakInContainerPath = "/usr/local/bin/cpak"
+
+// HostRootInContainer is the directory the host files passed to cpak run
+// are bound under inside the containers, each at its host path, see
+// cmd/host_path_attach.go.
+const HostRootInContainer = "/run/host"
+
+// ContainerPidFileName is the name of the pid file written by the spawn
+// command in the container's state directory.
+const ContainerPidFileName = "cpak.pid"
+
+// FuseOverlayfsPidFileName is the name of the pid file of the fuse-overlayfs
+// process serving the container's root filesystem, if any.
+const FuseOverlayfsPidFileName = "fuse-overlayfs.pid"
+
+// containerPidFilePath returns the path to the pid file of the given
+// container.
+func containerPidFilePath(container types.Container) string {
+	return filepath.Join(container.StatePath, ContainerPidFileName)
+}
+
+// readContainerPidFile reads the pid file of the given container and
+// verifies that it still describes a running process.
+func readContainerPidFile(container types.Container) (pidFile tools.PidFile, err error) {
+	pidFile, err = tools.ReadPidFile(containerPidFilePath(container))
 	if err != nil {
-		err = fmt.Errorf("no process with containerId %s found", containerCpakId)
-		return
+		return pidFile, fmt.Errorf("no pid file for container %s: %w", container.CpakId, err)
+	}
+
+	err = pidFile.Verify()
+	if err != nil {
+		return pidFile, fmt.Errorf("container %s is not running: %w", container.CpakId, err)
 	}
 	if isVerbose {
-		logger.Println("PID found:", pid)
+		logger.Println("PID found:", pidFile.Pid)
 	}
 	return
 }
 
-// CleanupContainer removes the container with the given id.
+// CleanupContainer removes the runtime files of the given container, and
+// its record unless the container is persistent, in which case the record
+// and the persistent changes are kept for the next run. Use
+// DiscardContainer to remove a persistent container entirely.
 func (c *Cpak) CleanupContainer(container types.Container) (err error) {
 	// Stop hostexec server first
 	stopHostExecServer(container.HostExecPid)
 
+	// fuse-overlayfs, when used, would otherwise keep the container's
+	// mount namespace alive
+	fusePidFile, err := tools.ReadPidFile(filepath.Join(container.StatePath, FuseOverlayfsPidFileName))
+	if err == nil {
+		_ = fusePidFile.Signal(syscall.SIGTERM)
+	}
+
 	// we don't care about the error here, we just want to make sure that
 	// the container filesystem is getting deleted
 	os.RemoveAll(container.StatePath)
 	os.RemoveAll(c.GetInStoreDir("containers", container.CpakId))
 	os.RemoveAll(c.GetInStoreDir("states", container.CpakId))
 
+	if container.Persistent {
+		return nil
+	}
+
 	store, err := NewStore(c.Options.StorePath)
 	if err != nil {
 		return
@@ -397,6 +487,17 @@ func (c *Cpak) CleanupContainer(container types.Container) (err error) {
 	return
 }
 
+// DiscardContainer removes the given container along with its persistent
+// changes, if any. The container must not be running.
+func (c *Cpak) DiscardContainer(container types.Container) (err error) {
+	container.Persistent = false
+	err = c.CleanupContainer(container)
+	if err != nil {
+		return
+	}
+	return os.RemoveAll(c.GetPersistentDir(container))
+}
+
 // getCpakBinary returns the path to the cpak binary.
 func getCpakBinary() (cpakBinary string, err error) {
 	cpakBinary = os.Args[0]
@@ -445,21 +546,29 @@ func getNested() (parentAppCpakId string, nested bool) {
 
 // startHostExecServerProcess starts the 'cpak hostexec-server' in the background.
 // It redirects server logs to a file within the container's state directory.
-func (c *Cpak) startHostExecServerProcess(socketPath string, allowedCmds []string) (pid int, err error) {
+func (c *Cpak) startHostExecServerProcess(app types.Application, container types.Container, override types.Override) (pid int, err error) {
 	cpakBinary, err := getCpakBinary()
 	if err != nil {
 		return 0, fmt.Errorf("cannot find cpak binary for hostexec server: %w", err)
 	}
 
+	socketPath := container.HostExecSocketPath
 	args := []string{
 		"hostexec-server",
 		"--socket-path", socketPath,
+		"--app-id", app.CpakId,
+		"--container-id", container.CpakId,
 	}
-	for _, cmdName := range allowedCmds {
+	for _, cmdName := range GetHostExecCommands(override) {
 		if cmdName != "" {
 			args = append(args, "--allowed-cmd", cmdName)
 		}
 	}
+	policiesJson, err := json.Marshal(GetHostCommandPolicies(override))
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode hostexec policies: %w", err)
+	}
+	args = append(args, "--policies", string(policiesJson))
 
 	// Log file setup (use container state dir for logs)
 	logDir := filepath.Dir(socketPath)
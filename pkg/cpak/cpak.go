@@ -11,7 +11,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/mirkobrombin/cpak/pkg/logger"
 	"github.com/mirkobrombin/cpak/pkg/tools"
@@ -23,6 +22,11 @@ import (
 type Cpak struct {
 	Options types.CpakOptions
 	Ctx     context.Context
+	Runtime Runtime
+
+	// ConfirmPermissions, when set, is asked to approve the permissions
+	// of every application being installed, dependencies included.
+	ConfirmPermissions PermissionConsentFunc
 }
 
 // NewCpak creates a new cpak instance.
@@ -32,6 +36,11 @@ func NewCpak() (cpak Cpak, err error) {
 		return
 	}
 
+	cpak.Runtime, err = NewRuntime(cpak.Options)
+	if err != nil {
+		return
+	}
+
 	cpak.Ctx = context.Background()
 	return
 }
@@ -53,8 +62,9 @@ func NewCpak() (cpak Cpak, err error) {
 //     is used: "~/.local/share/cpak".
 //  5. Necessary directories for cpak are then created, if they don't exist,
 //     based on the installation path.
-//  6. The function ensures that the system meets the required dependencies,
-//     such as the presence of "rootlesskit" in the specified bin directory.
+//  6. The function ensures that the system meets the required dependencies
+//     of the selected runtime, such as the presence of "rootlesskit" in the
+//     specified bin directory.
 func getCpakOptions() (options types.CpakOptions, err error) {
 	homedir, err := os.UserHomeDir()
 	if err != nil {
@@ -103,6 +113,23 @@ func getCpakOptions() (options types.CpakOptions, err error) {
 		}
 	}
 
+	// The runtime defaults to rootlesskit, unless it was not embedded
+	if options.Runtime == "" {
+		options.Runtime = types.RuntimeRootlesskit
+		if !tools.EmbeddedRootlesskit {
+			options.Runtime = types.RuntimeUnshare
+		}
+	}
+
+	// The layers mount method defaults to the automatic detection
+	switch options.MountMethod {
+	case "":
+		options.MountMethod = types.MountMethodAuto
+	case types.MountMethodAuto, types.MountMethodOverlay, types.MountMethodFuseOverlayfs, types.MountMethodCopy:
+	default:
+		return options, fmt.Errorf("invalid mount method: %s", options.MountMethod)
+	}
+
 	// Other store paths are generated from the store path
 	options.StoreLayersPath = filepath.Join(options.StorePath, "layers")
 	options.StoreContainersPath = filepath.Join(options.StorePath, "containers")
@@ -117,7 +144,7 @@ func getCpakOptions() (options types.CpakOptions, err error) {
 	}
 
 	// Ensure the system meets the dependencies
-	err = tools.EnsureUnixDeps(options.BinPath, "rootlesskit")
+	err = tools.EnsureUnixDeps(options.BinPath, options.Runtime)
 	if err != nil {
 		return
 	}
@@ -258,6 +285,13 @@ func (c *Cpak) Audit(repair bool) (err error) {
 		logger.Printf("  Auditing container: %s (App CpakId: %s)", container.CpakId, container.ApplicationCpakId)
 		validContainer := true
 
+		// stopped persistent containers only keep their record and their
+		// persistent directory, until they are started again
+		if container.Persistent && container.Pid == 0 {
+			logger.Printf("    [INFO] Persistent container %s is stopped.", container.CpakId)
+			continue
+		}
+
 		if _, statErr := os.Stat(container.StatePath); os.IsNotExist(statErr) {
 			logger.Printf("    [ERROR] State path %s for container %s not found.", container.StatePath, container.CpakId)
 			validContainer = false
@@ -268,14 +302,13 @@ func (c *Cpak) Audit(repair bool) (err error) {
 			validContainer = false
 		}
 
-		if container.Pid != 0 {
-			pidExists, _ := process.PidExists(int32(container.Pid))
-			if !pidExists {
-				logger.Printf("    [INFO] Main process PID %d for container %s is not running.", container.Pid, container.CpakId)
-				if repair {
-					logger.Printf("      Repair: Container %s main process is not running. Cleaning up associated files and DB entry.", container.CpakId)
-					validContainer = false
-				}
+		// the store resolves the pid from the container's pid file, a zero
+		// pid means that the main process is not running anymore
+		if container.Pid == 0 {
+			logger.Printf("    [INFO] Main process for container %s is not running.", container.CpakId)
+			if repair {
+				logger.Printf("      Repair: Container %s main process is not running. Cleaning up associated files and DB entry.", container.CpakId)
+				validContainer = false
 			}
 		}
 
@@ -354,95 +387,150 @@ func (c *Cpak) Audit(repair bool) (err error) {
 
 	checkOrphanedDirs(c.Options.StoreContainersPath, "container rootfs", getContainerDbIds)
 	checkOrphanedDirs(c.Options.StoreStatesPath, "state", getContainerDbIds)
+	checkOrphanedDirs(c.GetInStoreDir("persistent"), "persistent", func() (map[string]bool, error) {
+		ids := make(map[string]bool)
+		for _, cont := range allDbContainers {
+			if cont.Persistent {
+				ids[cont.CpakId] = true
+			}
+		}
+		return ids, nil
+	})
 
-	// --- 5. Exports (Binaries and .desktop Files) ---
-	logger.Println("\nChecking application exports (binaries and .desktop files)...")
-	homeDir, _ := os.UserHomeDir()
-	desktopEntriesPath := filepath.Join(homeDir, ".local", "share", "applications")
-
-	expectedExports := make(map[string]string)
+	// --- 5. Exports ---
+	// exports are checked against the files recorded in the store, so that
+	// only the files written by cpak are regenerated or removed
+	logger.Println("\nChecking application exports...")
+	exportedFiles, err := store.GetExportedFiles("")
+	if err != nil {
+		return fmt.Errorf("audit: failed to get exported files from DB: %w", err)
+	}
 
+	appsById := make(map[string]types.Application)
 	for _, app := range allDbApps {
-		for _, binaryName := range app.ParsedBinaries {
-			exportPath := filepath.Join(c.Options.ExportsPath, filepath.Join(strings.Split(app.Origin, "/")...), filepath.Base(binaryName))
-			expectedExports[exportPath] = app.CpakId
-			if _, statErr := os.Stat(exportPath); os.IsNotExist(statErr) {
-				logger.Printf("  [WARNING] Exported binary %s for app %s (Origin: %s) not found.", exportPath, app.Name, app.Origin)
-				if repair {
-					logger.Printf("    Repair: Recreating binary export for %s...", exportPath)
-					if c.exportBinary(app, binaryName) != nil {
-						logger.Printf("      [ERROR] Failed to recreate binary %s.", exportPath)
+		appsById[app.CpakId] = app
+	}
+
+	appsWithExports := make(map[string]bool)
+	regenerated := make(map[string]bool)
+	exportsChanged := false
+	for _, file := range exportedFiles {
+		app, found := appsById[file.AppCpakId]
+		if !found {
+			logger.Printf("  Orphaned %s export found: %s", file.Kind, file.Path)
+			if repair {
+				// another version of the application sharing the file
+				// takes it over
+				handedOver, handOverErr := handOverExport(store, file)
+				if handOverErr != nil {
+					logger.Printf("      [ERROR] Failed to hand over %s: %v", file.Path, handOverErr)
+				}
+				if !handedOver {
+					logger.Printf("    Repair: Removing orphaned export %s...", file.Path)
+					if removeErr := removeExport(store, file); removeErr != nil {
+						logger.Printf("      [ERROR] Failed to remove %s: %v", file.Path, removeErr)
 					}
 				}
+				exportsChanged = true
 			}
+			continue
+		}
+		appsWithExports[app.CpakId] = true
+		for _, sharer := range getExportSharers(file) {
+			appsWithExports[sharer] = true
 		}
-		for _, desktopEntryName := range app.ParsedDesktopEntries {
-			baseName := filepath.Base(desktopEntryName)
-			exportPath := filepath.Join(desktopEntriesPath, baseName)
-			expectedExports[exportPath] = app.CpakId
-			if _, statErr := os.Stat(exportPath); os.IsNotExist(statErr) {
-				logger.Printf("  [WARNING] Exported .desktop file %s for app %s (Origin: %s) not found.", exportPath, app.Name, app.Origin)
-				if repair {
-					logger.Printf("    Repair: Recreating .desktop entry for %s...", exportPath)
-					logger.Println("      Automatic recreation of .desktop file during audit is complex. Please reinstall or re-export if needed.")
+
+		switch getExportState(file) {
+		case exportMissing:
+			logger.Printf("  [WARNING] Exported %s %s for app %s (Origin: %s) not found.", file.Kind, file.Path, app.Name, app.Origin)
+			// the files exported from the same desktop entry are written
+			// again together
+			source := app.CpakId + ":" + file.Source
+			if repair && !regenerated[source] {
+				logger.Printf("    Repair: Recreating the exports of %s...", file.Source)
+				if regenErr := c.regenerateExport(store, app, file); regenErr != nil {
+					logger.Printf("      [ERROR] Failed to recreate %s: %v", file.Path, regenErr)
 				}
+				regenerated[source] = true
+				exportsChanged = true
 			}
+		case exportModified:
+			logger.Printf("  [WARNING] Exported %s %s for app %s (Origin: %s) was modified, it will not be updated.", file.Kind, file.Path, app.Name, app.Origin)
 		}
 	}
 
-	// Check for orphaned exports
-	// Binaries
+	// applications installed by older versions of cpak have no exports
+	// recorded, exporting them again records the files with the same content
+	for _, app := range allDbApps {
+		if appsWithExports[app.CpakId] || len(app.ParsedBinaries)+len(app.ParsedDesktopEntries)+len(app.ParsedServices)+len(app.ParsedDBusServices) == 0 {
+			continue
+		}
+		logger.Printf("  [WARNING] Exports of app %s (Origin: %s) are not tracked.", app.Name, app.Origin)
+		if repair {
+			logger.Printf("    Repair: Exporting %s again...", app.Name)
+			if exportErr := c.createExports(store, app); exportErr != nil {
+				logger.Printf("      [ERROR] Failed to export %s: %v", app.Name, exportErr)
+			}
+		}
+	}
+
+	// applications installed by older versions of cpak have no commands in
+	// the managed bin directory, a name already exported is a collision
+	exportedCommands := make(map[string]bool)
+	for _, file := range exportedFiles {
+		if file.Kind == types.ExportCommand {
+			exportedCommands[filepath.Base(file.Path)] = true
+		}
+	}
+	for _, app := range allDbApps {
+		if !appsWithExports[app.CpakId] || app.DerivedFrom != "" {
+			continue
+		}
+		for _, binary := range app.ParsedBinaries {
+			name := filepath.Base(binary)
+			if exportedCommands[name] {
+				continue
+			}
+			logger.Printf("  [WARNING] Command %s of app %s (Origin: %s) is not exported.", name, app.Name, app.Origin)
+			if repair {
+				logger.Printf("    Repair: Exporting command %s...", name)
+				if exportErr := c.updateCommand(store, name, app.Origin); exportErr != nil {
+					logger.Printf("      [ERROR] Failed to export %s: %v", name, exportErr)
+				}
+				exportedCommands[name] = true
+			}
+		}
+	}
+
+	// the exports directory only holds the files written by cpak
+	trackedPaths := make(map[string]bool)
+	if exportedFiles, err = store.GetExportedFiles(""); err != nil {
+		return fmt.Errorf("audit: failed to get exported files from DB: %w", err)
+	}
+	for _, file := range exportedFiles {
+		trackedPaths[file.Path] = true
+	}
 	filepath.WalkDir(c.Options.ExportsPath, func(path string, d os.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
-		if !d.IsDir() {
-			if _, expected := expectedExports[path]; !expected {
-				logger.Printf("  Orphaned binary export found: %s", path)
-				if repair {
-					logger.Printf("    Repair: Removing orphaned binary export %s...", path)
-					if removeErr := os.Remove(path); removeErr != nil {
-						logger.Printf("      [ERROR] Failed to remove %s: %v", path, removeErr)
-					}
+		if !d.IsDir() && !trackedPaths[path] {
+			logger.Printf("  Untracked export found: %s", path)
+			if repair {
+				logger.Printf("    Repair: Removing untracked export %s...", path)
+				if removeErr := os.Remove(path); removeErr != nil {
+					logger.Printf("      [ERROR] Failed to remove %s: %v", path, removeErr)
 				}
 			}
 		}
 		return nil
 	})
 
-	// .desktop files (only those that appear to be managed by cpak)
-	desktopFiles, _ := os.ReadDir(desktopEntriesPath)
-	for _, df := range desktopFiles {
-		if !df.IsDir() {
-			fullDesktopPath := filepath.Join(desktopEntriesPath, df.Name())
-			content, readErr := os.ReadFile(fullDesktopPath)
-			if readErr == nil && strings.Contains(string(content), "Exec=cpak run") {
-				if _, expected := expectedExports[fullDesktopPath]; !expected {
-					appExistsForThisExport := false
-					for _, app := range allDbApps {
-						for _, deName := range app.ParsedDesktopEntries {
-							if filepath.Base(deName) == df.Name() {
-								appExistsForThisExport = true
-								break
-							}
-						}
-						if appExistsForThisExport {
-							break
-						}
-					}
-
-					if !appExistsForThisExport {
-						logger.Printf("  Potentially orphaned .desktop file found: %s (managed by cpak)", fullDesktopPath)
-						if repair {
-							logger.Printf("    Repair: Removing orphaned .desktop file %s...", fullDesktopPath)
-							if removeErr := os.Remove(fullDesktopPath); removeErr != nil {
-								logger.Printf("      [ERROR] Failed to remove %s: %v", fullDesktopPath, removeErr)
-							}
-						}
-					}
-				}
-			}
-		}
+	if exportsChanged {
+		refreshDesktopDatabases()
+	}
+	if repair {
+		c.exportCatalog(store)
 	}
 
 	logger.Println("\nAudit finished.")
@@ -0,0 +1,368 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// The keys added to the exported desktop entries, to tell them apart from
+// the ones not managed by cpak.
+const (
+	desktopKeyOrigin = "X-Cpak-Origin"
+	desktopKeyId     = "X-Cpak-Id"
+)
+
+// desktopDeprecatedFieldCodes are the field codes the specification
+// deprecates, they are dropped from the exported Exec keys.
+var desktopDeprecatedFieldCodes = []string{"%d", "%D", "%n", "%N", "%v", "%m"}
+
+// mimePackagesDir is where applications ship their MIME type definitions.
+const mimePackagesDir = "usr/share/mime/packages"
+
+// mimeBasePackage is the package of shared-mime-info, defining the common
+// types, which is never exported.
+const mimeBasePackage = "freedesktop.org.xml"
+
+// GetDesktopEntriesDir returns the directory the desktop entries are
+// exported to, scanned by the desktops along with the system ones.
+func GetDesktopEntriesDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "applications"), nil
+}
+
+func getMimeDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "mime"), nil
+}
+
+// exportName returns the name identifying the exports of the given
+// application in the shared directories of the user. It is a short hash of
+// the CpakId, which is standard base64 and too long for icon names.
+func exportName(app types.Application) string {
+	sum := sha256.Sum256([]byte(app.CpakId))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// exportDesktopEntries exports all the desktop entries of the given
+// application, looking for them in its layers, and refreshes the desktop
+// databases.
+func (c *Cpak) exportDesktopEntries(store *Store, app types.Application) {
+	if len(app.ParsedDesktopEntries) == 0 {
+		return
+	}
+
+	for _, entry := range app.ParsedDesktopEntries {
+		var err error
+		for _, layer := range app.ParsedLayers {
+			err = c.exportDesktopEntry(store, c.GetInStoreDir("layers", layer), app, entry)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("Warning: failed to export the desktop entry %s: %v", entry, err)
+		}
+	}
+	refreshDesktopDatabases()
+}
+
+// exportDesktopEntry exports a desktop entry to the user's applications
+// directory, keeping its name so that desktops can match the application
+// windows through StartupWMClass or the entry name. The Exec keys of the
+// entry and of its actions run the command in the container, the MIME
+// types it handles are registered along with the definitions the
+// application ships. The icon is exported in all its sizes, see exportIcon.
+// All the exported files are recorded in the store, an entry which is not
+// managed by cpak is never replaced.
+func (c *Cpak) exportDesktopEntry(store *Store, rootFs string, app types.Application, desktopEntry string) error {
+	var originalPath string
+	entryBase := filepath.Base(desktopEntry)
+	direct := filepath.Join(rootFs, strings.TrimLeft(desktopEntry, "/"))
+	if _, err := os.Stat(direct); err == nil {
+		originalPath = direct
+	} else {
+		_ = filepath.Walk(rootFs, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			if filepath.Base(path) == entryBase {
+				originalPath = path
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+	if originalPath == "" {
+		return fmt.Errorf("desktop entry %s not found under %s", entryBase, rootFs)
+	}
+
+	desktopDir, err := GetDesktopEntriesDir()
+	if err != nil {
+		return err
+	}
+	desktopDest := filepath.Join(desktopDir, entryBase)
+
+	data, err := os.ReadFile(originalPath)
+	if err != nil {
+		return err
+	}
+	entry, err := tools.ParseDesktopEntry(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", entryBase, err)
+	}
+	main := entry.Main()
+
+	for _, group := range append([]*tools.DesktopEntryGroup{main}, entry.Actions()...) {
+		value, ok := group.Get("Exec")
+		if !ok {
+			continue
+		}
+		execValue, err := rewriteDesktopExec(app, value)
+		if err != nil {
+			return fmt.Errorf("invalid Exec key in %s: %w", entryBase, err)
+		}
+		group.Set("Exec", execValue)
+	}
+
+	// TryExec would look for the command on the host, and D-Bus activation
+	// would start it there, the Exec key is used instead
+	main.Delete("TryExec")
+	main.Delete("DBusActivatable")
+	main.Set(desktopKeyOrigin, app.Origin)
+	main.Set(desktopKeyId, app.CpakId)
+
+	if iconName, ok := main.Get("Icon"); ok && iconName != "" {
+		exportedIcon, err := c.exportIcon(store, app, iconName, desktopEntry)
+		if err != nil {
+			return err
+		}
+		if exportedIcon != "" {
+			main.Set("Icon", exportedIcon)
+		}
+	}
+
+	mimeTypes, _ := main.Get("MimeType")
+	err = c.exportMimePackages(store, app, tools.SplitDesktopEntryList(mimeTypes), desktopEntry)
+	if err != nil {
+		logger.Printf("Warning: failed to export the MIME types of %s: %v", entryBase, err)
+	}
+
+	return writeExport(store, app, types.ExportDesktopEntry, desktopEntry, desktopDest, entry.Bytes(), 0644)
+}
+
+// desktopFileFieldCodes are the field codes expanding to the files or URLs
+// to open, an Exec key holds at most one of them.
+var desktopFileFieldCodes = []string{"%f", "%F", "%u", "%U"}
+
+// rewriteDesktopExec returns the given Exec value, running the command in
+// the application container. The field codes are kept, so that the desktop
+// still passes the files and URLs to open, which cpak run translates to
+// the paths the container sees them at.
+func rewriteDesktopExec(app types.Application, value string) (string, error) {
+	args, err := tools.ParseDesktopExec(value)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+
+	cmdArgs := []string{}
+	hostPathsFrom := -1
+	for _, arg := range args[1:] {
+		if slices.Contains(desktopDeprecatedFieldCodes, arg) {
+			continue
+		}
+		if hostPathsFrom < 0 && slices.Contains(desktopFileFieldCodes, arg) {
+			hostPathsFrom = len(cmdArgs)
+		}
+		cmdArgs = append(cmdArgs, arg)
+	}
+
+	// the arguments before the field code are part of the command, they
+	// are container paths and are not translated
+	execArgs := []string{"cpak", "run"}
+	if hostPathsFrom >= 0 {
+		execArgs = append(execArgs, fmt.Sprintf("--host-paths-from=%d", hostPathsFrom))
+	}
+	execArgs = append(execArgs, "--", app.Origin, "@"+args[0])
+	return tools.FormatDesktopExec(append(execArgs, cmdArgs...)), nil
+}
+
+// exportMimePackages exports the MIME type definitions shipped with the
+// application which define any of the given types, so that the desktop
+// recognizes the files the application handles.
+func (c *Cpak) exportMimePackages(store *Store, app types.Application, mimeTypes []string, desktopEntry string) error {
+	if len(mimeTypes) == 0 {
+		return nil
+	}
+	mimeDir, err := getMimeDir()
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range app.ParsedLayers {
+		packages, _ := filepath.Glob(filepath.Join(c.GetInStoreDir("layers", layer), mimePackagesDir, "*.xml"))
+		for _, pkg := range packages {
+			if filepath.Base(pkg) == mimeBasePackage {
+				continue
+			}
+			defined, err := readMimePackageTypes(pkg)
+			if err != nil || !slices.ContainsFunc(defined, func(t string) bool {
+				return slices.Contains(mimeTypes, t)
+			}) {
+				continue
+			}
+
+			data, err := os.ReadFile(pkg)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(mimeDir, "packages", "cpak-"+exportName(app)+"-"+filepath.Base(pkg))
+			err = writeExport(store, app, types.ExportMimePackage, desktopEntry, dest, data, 0644)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readMimePackageTypes returns the MIME types defined by the given
+// shared-mime-info package.
+func readMimePackageTypes(path string) (mimeTypes []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	info := struct {
+		Types []struct {
+			Type string `xml:"type,attr"`
+		} `xml:"mime-type"`
+	}{}
+	err = xml.Unmarshal(data, &info)
+	if err != nil {
+		return
+	}
+	for _, t := range info.Types {
+		mimeTypes = append(mimeTypes, t.Type)
+	}
+	return
+}
+
+// refreshDesktopDatabases updates the MIME handlers cache of the user's
+// applications directory, with update-desktop-database if available or by
+// writing it directly otherwise, the MIME database of the user, which
+// needs update-mime-database, and the icon cache.
+func refreshDesktopDatabases() {
+	refreshIconCache()
+
+	desktopDir, err := GetDesktopEntriesDir()
+	if err != nil {
+		return
+	}
+	if _, statErr := os.Stat(desktopDir); statErr != nil {
+		return
+	}
+	if path, lookErr := exec.LookPath("update-desktop-database"); lookErr == nil {
+		err = exec.Command(path, "-q", desktopDir).Run()
+	} else {
+		err = writeMimeInfoCache(desktopDir)
+	}
+	if err != nil {
+		logger.Printf("Warning: failed to update the desktop database: %v", err)
+	}
+
+	mimeDir, err := getMimeDir()
+	if err != nil {
+		return
+	}
+	if _, statErr := os.Stat(filepath.Join(mimeDir, "packages")); statErr != nil {
+		return
+	}
+	path, err := exec.LookPath("update-mime-database")
+	if err != nil {
+		logger.Println("Warning: update-mime-database not found, the MIME types of the applications will not be recognized")
+		return
+	}
+	err = exec.Command(path, mimeDir).Run()
+	if err != nil {
+		logger.Printf("Warning: failed to update the MIME database: %v", err)
+	}
+}
+
+// writeMimeInfoCache writes the mimeinfo.cache file of the given
+// applications directory, as update-desktop-database does, mapping each
+// MIME type to the desktop entries handling it.
+func writeMimeInfoCache(desktopDir string) error {
+	handlers := map[string][]string{}
+	err := filepath.WalkDir(desktopDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".desktop" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		entry, err := tools.ParseDesktopEntry(data)
+		if err != nil {
+			return nil
+		}
+		if hidden, _ := entry.Main().Get("Hidden"); hidden == "true" {
+			return nil
+		}
+
+		// the desktop file id of entries in subdirectories is prefixed
+		// by the subdirectories, separated by dashes
+		rel, _ := filepath.Rel(desktopDir, path)
+		id := strings.ReplaceAll(rel, string(filepath.Separator), "-")
+		mimeTypes, _ := entry.Main().Get("MimeType")
+		for _, mimeType := range tools.SplitDesktopEntryList(mimeTypes) {
+			if !slices.Contains(handlers[mimeType], id) {
+				handlers[mimeType] = append(handlers[mimeType], id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	mimeTypes := make([]string, 0, len(handlers))
+	for mimeType := range handlers {
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	sort.Strings(mimeTypes)
+
+	var b strings.Builder
+	b.WriteString("[MIME Cache]\n")
+	for _, mimeType := range mimeTypes {
+		sort.Strings(handlers[mimeType])
+		b.WriteString(mimeType + "=" + strings.Join(handlers[mimeType], ";") + ";\n")
+	}
+	return os.WriteFile(filepath.Join(desktopDir, "mimeinfo.cache"), []byte(b.String()), 0644)
+}
@@ -0,0 +1,419 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"golang.org/x/sys/unix"
+)
+
+// deviceRulesFileName is the name of the file holding the device rules of a
+// container, in its state directory. It is written when the container is
+// started, so that the rules follow the override it was started with.
+const deviceRulesFileName = "device-rules.json"
+
+// devicePollInterval is the interval at which the running containers are
+// checked, and /dev is scanned if the uevents are not available.
+const devicePollInterval = 2 * time.Second
+
+// deviceSettleDelay is the time waited after a uevent before scanning
+// /dev, since a device plug usually comes with a burst of events.
+const deviceSettleDelay = 200 * time.Millisecond
+
+// deviceSkipDirs are the directories of /dev which do not hold device
+// nodes.
+var deviceSkipDirs = []string{"/dev/pts", "/dev/shm", "/dev/mqueue", "/dev/hugepages"}
+
+// GetDeviceRules returns the rules of the devices exposed to a container
+// using the given override while it is running. Video devices are only
+// listed when the container starts, so they get a rule as well.
+func GetDeviceRules(o types.Override) (rules []types.DeviceRule, err error) {
+	if o.DeviceAll {
+		// the whole /dev is already bound
+		return nil, nil
+	}
+	if o.DeviceVideo {
+		rules = append(rules, types.DeviceRule{Subsystem: "video4linux"})
+	}
+	for _, rule := range o.DeviceRules {
+		parsed, parseErr := types.ParseDeviceRule(rule)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		rules = append(rules, parsed)
+	}
+	return rules, nil
+}
+
+// writeContainerDeviceRules writes the device rules of the given container
+// in its state directory, removing them if there are none.
+func writeContainerDeviceRules(container types.Container, rules []types.DeviceRule) error {
+	path := filepath.Join(container.StatePath, deviceRulesFileName)
+	if len(rules) == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func readContainerDeviceRules(container types.Container) (rules []types.DeviceRule, err error) {
+	data, err := os.ReadFile(filepath.Join(container.StatePath, deviceRulesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, &rules)
+	return
+}
+
+// deviceContainer is a running container with device rules.
+type deviceContainer struct {
+	container types.Container
+	pid       int
+	rules     []types.DeviceRule
+}
+
+// attachedDevices are the device nodes bound by the device manager in a
+// container, with the identity of each node when it was bound. The pid
+// tells a restarted container apart.
+type attachedDevices struct {
+	pid   int
+	nodes map[string]nodeIdentity
+}
+
+// nodeIdentity tells a device node apart from the one previously found at
+// the same path. devtmpfs creates a new node when a device is plugged in,
+// which may get the device number of the unplugged one.
+type nodeIdentity struct {
+	rdev  uint64
+	ino   uint64
+	ctime unix.Timespec
+}
+
+// cachedDevice is a device read from /sys, along with the identity of its
+// node when it was read.
+type cachedDevice struct {
+	identity nodeIdentity
+	device   types.Device
+}
+
+// deviceManager binds the devices plugged in while the containers are
+// running in their mount namespace, according to their device rules.
+type deviceManager struct {
+	cpak     *Cpak
+	attached map[string]*attachedDevices
+	cache    map[string]cachedDevice
+	devices  []types.Device
+}
+
+// watchDevices runs the device manager of the service. Devices are noticed
+// through the kernel uevents, or by polling /dev if those are not
+// available.
+func (c *Cpak) watchDevices() {
+	m := &deviceManager{
+		cpak:     c,
+		attached: map[string]*attachedDevices{},
+		cache:    map[string]cachedDevice{},
+	}
+
+	events, err := tools.WatchUevents()
+	if err != nil {
+		logger.Printf("Device events not available, polling /dev: %v", err)
+	}
+
+	ticker := time.NewTicker(devicePollInterval)
+	defer ticker.Stop()
+	var settle <-chan time.Time
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				logger.Println("Device events stopped, polling /dev")
+				events = nil
+				continue
+			}
+			// a node removed and created again within the settle delay
+			// must not be taken for the old device
+			if event.Action == "remove" && event.DevName != "" {
+				delete(m.cache, filepath.Join("/dev", event.DevName))
+			}
+			// devtmpfs creates the node before the event is sent, so it
+			// can be picked once the burst of events is over
+			if event.DevName != "" && settle == nil {
+				settle = time.After(deviceSettleDelay)
+			}
+		case <-settle:
+			settle = nil
+			m.sync(true)
+		case <-ticker.C:
+			m.sync(events == nil)
+		}
+	}
+}
+
+// sync binds the new matching devices in the running containers and
+// unbinds the removed ones. /dev is only scanned if rescan is set or a new
+// container is found.
+func (m *deviceManager) sync(rescan bool) {
+	containers, err := m.runningContainers()
+	if err != nil {
+		logger.Printf("Device manager: %v", err)
+		return
+	}
+
+	changed := rescan || m.devices == nil
+	running := map[string]bool{}
+	for _, dc := range containers {
+		running[dc.container.CpakId] = true
+		state, ok := m.attached[dc.container.CpakId]
+		if !ok || state.pid != dc.pid {
+			m.attached[dc.container.CpakId] = &attachedDevices{pid: dc.pid, nodes: map[string]nodeIdentity{}}
+			changed = true
+		}
+	}
+	// the bind mounts of stopped containers are gone with their namespace
+	for id := range m.attached {
+		if !running[id] {
+			delete(m.attached, id)
+		}
+	}
+	if !changed {
+		return
+	}
+
+	if rescan || m.devices == nil {
+		m.devices, err = m.scanDevices()
+		if err != nil {
+			logger.Printf("Device manager: failed to scan /dev: %v", err)
+			return
+		}
+	}
+	for _, dc := range containers {
+		m.syncContainer(dc)
+	}
+}
+
+// runningContainers returns the running containers which have device
+// rules.
+func (m *deviceManager) runningContainers() (containers []deviceContainer, err error) {
+	store, err := NewStore(m.cpak.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	apps, err := store.GetApplications()
+	if err != nil {
+		return
+	}
+	for _, app := range apps {
+		appContainers, containersErr := store.GetApplicationContainers(app)
+		if containersErr != nil {
+			return nil, containersErr
+		}
+		for _, container := range appContainers {
+			if container.Pid == 0 {
+				continue
+			}
+			rules, rulesErr := readContainerDeviceRules(container)
+			if rulesErr != nil {
+				logger.Printf("Device manager: invalid device rules for container %s: %v", container.CpakId, rulesErr)
+				continue
+			}
+			if len(rules) > 0 {
+				containers = append(containers, deviceContainer{container: container, pid: container.Pid, rules: rules})
+			}
+		}
+	}
+	return
+}
+
+// syncContainer binds the devices matching the rules of the given
+// container which are not bound yet, and unbinds the ones which are gone
+// or no longer match. A bound node replaced by a new one is bound again,
+// since the bind mount still refers to the old node.
+func (m *deviceManager) syncContainer(dc deviceContainer) {
+	state := m.attached[dc.container.CpakId]
+
+	wanted := map[string]nodeIdentity{}
+	for _, device := range m.devices {
+		for _, rule := range dc.rules {
+			if rule.Matches(device) {
+				wanted[device.Node] = m.cache[device.Node].identity
+				break
+			}
+		}
+	}
+
+	detach := []string{}
+	for node, identity := range state.nodes {
+		if current, ok := wanted[node]; !ok || current != identity {
+			detach = append(detach, node)
+		}
+	}
+	attach := []string{}
+	for node, identity := range wanted {
+		if bound, ok := state.nodes[node]; !ok || bound != identity {
+			attach = append(attach, node)
+		}
+	}
+	slices.Sort(attach)
+	slices.Sort(detach)
+
+	if len(detach) > 0 {
+		err := m.cpak.execDeviceAttach(dc.pid, detach, true)
+		if err != nil {
+			logger.Printf("Device manager: failed to unbind devices from container %s: %v", dc.container.CpakId, err)
+		}
+		for _, node := range detach {
+			delete(state.nodes, node)
+		}
+	}
+
+	if len(attach) > 0 {
+		err := m.cpak.execDeviceAttach(dc.pid, attach, false)
+		if err != nil {
+			logger.Printf("Device manager: failed to bind devices in container %s: %v", dc.container.CpakId, err)
+			return
+		}
+		for _, node := range attach {
+			state.nodes[node] = wanted[node]
+			RecordAuditEvent(types.AuditEvent{
+				AppId:       dc.container.ApplicationCpakId,
+				ContainerId: dc.container.CpakId,
+				Type:        types.AuditEventMount,
+				Decision:    types.AuditDecisionAllowed,
+				Detail:      node,
+			})
+		}
+	}
+}
+
+// execDeviceAttach runs the device-attach command in the user namespace of
+// the container with the given main process, to bind or unbind the given
+// device nodes. The command stays in the host mount namespace, so that it
+// can take each host node on its own and move it in the container one.
+func (c *Cpak) execDeviceAttach(pid int, nodes []string, detach bool) error {
+	cpakBinary, err := getCpakBinary()
+	if err != nil {
+		return err
+	}
+
+	command := []string{cpakBinary, "device-attach", "--init-pid", strconv.Itoa(pid)}
+	if detach {
+		command = append(command, "--detach")
+	}
+	command = append(command, nodes...)
+
+	out, err := nsenterUserCommand(c.Options.NsenterBinPath, pid, command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// scanDevices returns the device nodes in /dev, with their properties read
+// from /sys.
+func (m *deviceManager) scanDevices() (devices []types.Device, err error) {
+	seen := map[string]bool{}
+	err = filepath.WalkDir("/dev", func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if path == "/dev" {
+				return walkErr
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if slices.Contains(deviceSkipDirs, path) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeDevice == 0 {
+			return nil
+		}
+
+		device, ok := m.readDevice(path)
+		if ok {
+			seen[path] = true
+			devices = append(devices, device)
+		}
+		return nil
+	})
+
+	for node := range m.cache {
+		if !seen[node] {
+			delete(m.cache, node)
+		}
+	}
+	return
+}
+
+// readDevice returns the properties of the given device node, the subsystem
+// and, for USB devices, the vendor and product identifiers.
+func (m *deviceManager) readDevice(node string) (device types.Device, ok bool) {
+	var stat unix.Stat_t
+	err := unix.Stat(node, &stat)
+	if err != nil {
+		return device, false
+	}
+	identity := nodeIdentity{rdev: stat.Rdev, ino: stat.Ino, ctime: stat.Ctim}
+	if cached, found := m.cache[node]; found && cached.identity == identity {
+		return cached.device, true
+	}
+
+	kind := "char"
+	if stat.Mode&unix.S_IFMT == unix.S_IFBLK {
+		kind = "block"
+	}
+	sysPath, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/%s/%d:%d", kind, unix.Major(stat.Rdev), unix.Minor(stat.Rdev)))
+	if err != nil {
+		return device, false
+	}
+
+	device.Node = node
+	if subsystem, err := filepath.EvalSymlinks(filepath.Join(sysPath, "subsystem")); err == nil {
+		device.Subsystem = filepath.Base(subsystem)
+	}
+
+	// the USB identifiers belong to the USB device the node is part of,
+	// which is one of its parents, e.g. for a tty of a USB interface
+	for dir := sysPath; strings.HasPrefix(dir, "/sys/devices/"); dir = filepath.Dir(dir) {
+		vendor, err := os.ReadFile(filepath.Join(dir, "idVendor"))
+		if err != nil {
+			continue
+		}
+		product, _ := os.ReadFile(filepath.Join(dir, "idProduct"))
+		device.Vendor = strings.ToLower(strings.TrimSpace(string(vendor)))
+		device.Product = strings.ToLower(strings.TrimSpace(string(product)))
+		break
+	}
+
+	m.cache[node] = cachedDevice{identity: identity, device: device}
+	return device, true
+}
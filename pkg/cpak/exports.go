@@ -0,0 +1,330 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// ErrExportConflict is returned when an export would replace a file which
+// was not written by cpak, which the user modified since, or which was
+// exported for another application.
+var ErrExportConflict = errors.New("file not written by cpak for this application or modified by the user")
+
+// The states of an exported file, as found on disk.
+const (
+	exportOk = iota
+	exportMissing
+	exportModified
+)
+
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeExport writes a file exported for the given application and records
+// it in the store, with the hash of its content. A file which was not
+// written by cpak, or which the user modified since, is only replaced if
+// it already holds the same content, while a file exported for another
+// installed application is never taken over, since removing either of them
+// would break the other one. The versions of the same application share
+// the files they export with the same content instead. ErrExportConflict
+// is returned otherwise.
+func writeExport(store *Store, app types.Application, kind string, source string, path string, data []byte, perm os.FileMode) error {
+	hash := hashContent(data)
+	record, err := store.GetExportedFile(path)
+	if err != nil {
+		return err
+	}
+
+	shared := false
+	if record.Path != "" && record.AppCpakId != app.CpakId {
+		// a record left by a removed application is taken over
+		if owner, ownerErr := store.GetApplicationByCpakId(record.AppCpakId); ownerErr == nil {
+			if owner.Origin != app.Origin || record.Hash != hash {
+				return fmt.Errorf("%s: exported for %s: %w", path, getAppRemote(owner), ErrExportConflict)
+			}
+			shared = true
+		}
+	}
+
+	if current, readErr := os.ReadFile(path); readErr == nil && hashContent(current) != hash {
+		if record.Path == "" || record.Hash != hashContent(current) {
+			return fmt.Errorf("%s: %w", path, ErrExportConflict)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	if shared {
+		sharers := getExportSharers(record)
+		if slices.Contains(sharers, app.CpakId) {
+			return nil
+		}
+		record.SharedWith = strings.Join(append(sharers, app.CpakId), ",")
+		return store.SaveExportedFile(record)
+	}
+
+	// the other versions only share the content they exported
+	sharedWith := ""
+	if record.AppCpakId == app.CpakId && record.Hash == hash {
+		sharedWith = record.SharedWith
+	}
+	return store.SaveExportedFile(types.ExportedFile{
+		AppCpakId:  app.CpakId,
+		Path:       path,
+		Kind:       kind,
+		Source:     source,
+		Hash:       hash,
+		SharedWith: sharedWith,
+	})
+}
+
+// getExportSharers returns the CpakIds of the applications sharing the
+// given exported file with its owner.
+func getExportSharers(file types.ExportedFile) []string {
+	if file.SharedWith == "" {
+		return []string{}
+	}
+	return strings.Split(file.SharedWith, ",")
+}
+
+// handOverExport hands the given exported file over to the first of the
+// applications sharing it which is still installed, it returns false if
+// there is none and the file has to be removed.
+func handOverExport(store *Store, file types.ExportedFile) (bool, error) {
+	sharers := getExportSharers(file)
+	for i, sharer := range sharers {
+		if _, err := store.GetApplicationByCpakId(sharer); err != nil {
+			continue
+		}
+		file.AppCpakId = sharer
+		file.SharedWith = strings.Join(sharers[i+1:], ",")
+		return true, store.SaveExportedFile(file)
+	}
+	return false, nil
+}
+
+// unshareExports stops the given application from sharing the files
+// exported for the other versions of it.
+func unshareExports(store *Store, app types.Application) error {
+	files, err := store.GetExportedFiles("")
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		sharers := getExportSharers(file)
+		if !slices.Contains(sharers, app.CpakId) {
+			continue
+		}
+		file.SharedWith = strings.Join(slices.DeleteFunc(sharers, func(sharer string) bool {
+			return sharer == app.CpakId
+		}), ",")
+		if err := store.SaveExportedFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getExportState tells whether the given exported file is still on disk as
+// cpak wrote it.
+func getExportState(file types.ExportedFile) int {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return exportMissing
+	}
+	if hashContent(data) != file.Hash {
+		return exportModified
+	}
+	return exportOk
+}
+
+// removeExport removes the given exported file along with its record. The
+// file is kept if the user modified it.
+func removeExport(store *Store, file types.ExportedFile) error {
+	switch getExportState(file) {
+	case exportModified:
+		logger.Printf("Warning: %s was modified, keeping it", file.Path)
+	case exportOk:
+		if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return store.RemoveExportedFile(file.Path)
+}
+
+// createExports creates the exports for a given application. Files which
+// would replace ones not written by cpak are skipped.
+func (c *Cpak) createExports(store *Store, app types.Application) (err error) {
+	c.exportDesktopEntries(store, app)
+
+	err = c.exportServices(store, app)
+	if errors.Is(err, ErrExportConflict) {
+		logger.Printf("Warning: services not exported: %v", err)
+	} else if err != nil {
+		return
+	}
+
+	for _, binary := range app.ParsedBinaries {
+		// the command is exported even if the wrapper of the exports
+		// directory is not, so that collisions are still reported
+		err = c.exportBinary(store, app, binary)
+		if errors.Is(err, ErrExportConflict) {
+			logger.Printf("Warning: binary %s not exported: %v", binary, err)
+		} else if err != nil {
+			return
+		}
+
+		err = c.exportCommand(store, app, binary)
+		if err != nil {
+			logger.Printf("Warning: command %s not exported: %v", filepath.Base(binary), err)
+		}
+	}
+	return nil
+}
+
+func (c *Cpak) exportBinary(store *Store, app types.Application, binary string) error {
+	destinationItems := []string{c.Options.ExportsPath}
+	destinationItems = append(destinationItems, strings.Split(app.Origin, "/")...)
+	destinationItems = append(destinationItems, filepath.Base(binary))
+	destinationPath := filepath.Join(destinationItems...)
+
+	scriptContent := fmt.Sprintf("#!/bin/sh\ncpak run -- %s %s \"$@\"\n", shellQuote(app.Origin), shellQuote("@"+binary))
+	return writeExport(store, app, types.ExportBinary, binary, destinationPath, []byte(scriptContent), 0755)
+}
+
+// regenerateExport writes the given exported file again, along with the
+// other files exported from the same source.
+func (c *Cpak) regenerateExport(store *Store, app types.Application, file types.ExportedFile) error {
+	switch file.Kind {
+	case types.ExportBinary:
+		return c.exportBinary(store, app, file.Source)
+	case types.ExportCommand:
+		return c.updateCommand(store, filepath.Base(file.Path), app.Origin)
+	case types.ExportCompletion, types.ExportManPage:
+		return c.updateCommand(store, filepath.Base(file.Source), app.Origin)
+	case types.ExportSystemdUnit, types.ExportDBusService:
+		return c.exportServices(store, app)
+	}
+
+	var err error
+	for _, layer := range app.ParsedLayers {
+		err = c.exportDesktopEntry(store, c.GetInStoreDir("layers", layer), app, file.Source)
+		if err == nil {
+			break
+		}
+	}
+	return err
+}
+
+// removeExports removes the files exported for the given application, as
+// recorded in the store, and refreshes the desktop databases. The files it
+// shared with other versions of it are handed over to them, and the
+// commands it provided are exported again from the other applications
+// providing them, if any.
+func (c *Cpak) removeExports(store *Store, app types.Application) error {
+	files, err := store.GetExportedFiles(app.CpakId)
+	if err != nil {
+		return err
+	}
+	releasedCommands := []string{}
+	servicesRemoved := false
+	for _, file := range files {
+		handedOver, err := handOverExport(store, file)
+		if err != nil {
+			logger.Printf("Warning: could not hand over exported file %s: %v", file.Path, err)
+		}
+		if handedOver {
+			continue
+		}
+
+		switch file.Kind {
+		case types.ExportSystemdUnit:
+			if _, err := runSystemctl("disable", "--now", filepath.Base(file.Path)); err != nil && !errors.Is(err, ErrSystemdNotFound) {
+				logger.Printf("Warning: could not stop service %s: %v", file.Source, err)
+			}
+			servicesRemoved = true
+		case types.ExportDBusService:
+			servicesRemoved = true
+		}
+		if err := removeExport(store, file); err != nil {
+			logger.Printf("Warning: could not remove exported file %s: %v", file.Path, err)
+		}
+		if file.Kind == types.ExportCommand {
+			releasedCommands = append(releasedCommands, filepath.Base(file.Path))
+		}
+		c.removeEmptyExportDirs(filepath.Dir(file.Path))
+	}
+	if err := unshareExports(store, app); err != nil {
+		logger.Printf("Warning: could not release the exports shared with %s: %v", app.Name, err)
+	}
+
+	c.reassignCommands(store, app, releasedCommands)
+	if servicesRemoved {
+		reloadServiceManagers()
+	}
+	removeLegacyExports(app)
+	refreshDesktopDatabases()
+	return nil
+}
+
+// removeEmptyExportDirs removes the given directory of the exports
+// directory and its parents, as long as they are empty.
+func (c *Cpak) removeEmptyExportDirs(dir string) {
+	for dir != c.Options.ExportsPath && strings.HasPrefix(dir, c.Options.ExportsPath) {
+		entries, err := os.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			break
+		}
+		os.Remove(dir)
+		dir = filepath.Dir(dir)
+	}
+}
+
+// removeLegacyExports removes the desktop entries and icons exported by
+// older versions of cpak, which did not record them in the store: both
+// were named after the CpakId.
+func removeLegacyExports(app types.Application) {
+	home := os.Getenv("HOME")
+
+	desktopDir := filepath.Join(home, ".local", "share", "applications", app.CpakId)
+	if err := os.RemoveAll(desktopDir); err != nil {
+		logger.Printf("Warning: could not remove desktop entries dir %s: %v", desktopDir, err)
+	}
+
+	iconsDir := filepath.Join(home, ".local", "share", "icons")
+	entries, err := os.ReadDir(iconsDir)
+	if err == nil {
+		for _, e := range entries {
+			name := e.Name()
+			if strings.HasPrefix(name, app.CpakId+".") {
+				path := filepath.Join(iconsDir, name)
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					logger.Printf("Warning: could not remove icon %s: %v", path, err)
+				}
+			}
+		}
+	}
+}
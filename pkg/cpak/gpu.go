@@ -0,0 +1,359 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// GpuProvider discovers the files of a host GPU driver, which are injected
+// in the containers.
+type GpuProvider interface {
+	// Vendor returns the name of the provider, e.g. nvidia, as used to
+	// cache its discovery in the store.
+	Vendor() string
+
+	// Detect returns the version of the host driver, found is false if the
+	// driver is not in use on the host.
+	Detect() (version string, found bool, err error)
+
+	// ScannedPaths returns the paths the discovery depends on, a change in
+	// their mtime runs the discovery again.
+	ScannedPaths() []string
+
+	// Discover returns the files of the given driver version.
+	Discover(version string) ([]types.GpuFile, error)
+
+	// Devices returns the device nodes of the driver, which are looked up
+	// every time since they can be created on demand.
+	Devices() []string
+
+	// HostByDefault reports whether the host driver is injected when the
+	// gpuDrivers override key is auto, i.e. whether images cannot be
+	// expected to ship a working driver.
+	HostByDefault() bool
+}
+
+// GpuFilesPrefix is where the host GPU libraries and driver modules are
+// bound in the containers, each at its host path under it, so that the
+// image files are never overmounted.
+const GpuFilesPrefix = "/run/cpak/gpu"
+
+// gpuDiscoveryFormat is part of the discovery cache key, it is bumped when
+// the discovered files change in a way the older cached ones do not match.
+const gpuDiscoveryFormat = 3
+
+// gpuProviders are the supported GPU drivers.
+var gpuProviders = []GpuProvider{
+	nvidiaProvider{},
+	newAmdProvider(),
+	newIntelProvider(),
+}
+
+// HostGpuDriver is a GPU driver in use on the host.
+type HostGpuDriver struct {
+	Driver        types.GpuDriver `json:"driver"`
+	Devices       []string        `json:"devices"`
+	Cached        bool            `json:"cached"`
+	HostByDefault bool            `json:"hostByDefault"`
+}
+
+// GetHostGpuDrivers returns the GPU drivers in use on the host, along with
+// their files. The discovery is cached in the store and only run again if
+// the driver version or the scanned paths changed, or if refresh is set.
+// Drivers which cannot be discovered are reported and skipped.
+func (c *Cpak) GetHostGpuDrivers(refresh bool) (drivers []HostGpuDriver, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	for _, provider := range gpuProviders {
+		driver, cached, found, discoverErr := getGpuDriver(store, provider, refresh)
+		if discoverErr != nil {
+			logger.Printf("Warning: %s driver discovery failed, the driver will not be available: %v", provider.Vendor(), discoverErr)
+			continue
+		}
+		if !found {
+			continue
+		}
+		drivers = append(drivers, HostGpuDriver{
+			Driver:        driver,
+			Devices:       provider.Devices(),
+			Cached:        cached,
+			HostByDefault: provider.HostByDefault(),
+		})
+	}
+	return drivers, nil
+}
+
+func getGpuDriver(store *Store, provider GpuProvider, refresh bool) (driver types.GpuDriver, cached bool, found bool, err error) {
+	version, found, err := provider.Detect()
+	if err != nil || !found {
+		return
+	}
+	key := gpuDiscoveryKey(version, provider.ScannedPaths())
+
+	if !refresh {
+		driver, err = store.GetGpuDriver(provider.Vendor())
+		if err != nil {
+			return
+		}
+		if driver.Key == key {
+			return driver, true, true, nil
+		}
+	}
+
+	files, err := provider.Discover(version)
+	if err != nil {
+		return types.GpuDriver{}, false, true, err
+	}
+	driver = types.GpuDriver{
+		Vendor:      provider.Vendor(),
+		Version:     version,
+		Key:         key,
+		ParsedFiles: files,
+	}
+	err = store.SaveGpuDriver(driver)
+	return driver, false, true, err
+}
+
+// gpuDiscoveryKey returns the cache key of a discovery for the given driver
+// version: any change of the given paths gives a different key.
+func gpuDiscoveryKey(version string, paths []string) string {
+	hash := sha256.New()
+	fmt.Fprintln(hash, gpuDiscoveryFormat, version)
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintln(hash, path, info.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// GetGpuFiles returns the files to bind in a container using the given
+// override: the files of the host GPU drivers selected by the gpuDrivers
+// key and the ones of the CDI devices, along with the environment variables
+// the CDI devices set and the ones pointing the image loaders to the host
+// driver modules.
+func (c *Cpak) GetGpuFiles(o types.Override) (files []types.GpuFile, env []string, err error) {
+	if o.GpuDrivers != types.GpuDriversImage {
+		drivers, driversErr := c.GetHostGpuDrivers(false)
+		if driversErr != nil {
+			return nil, nil, driversErr
+		}
+		for _, driver := range drivers {
+			if o.GpuDrivers != types.GpuDriversHost && !driver.HostByDefault {
+				continue
+			}
+			for _, file := range driver.Driver.ParsedFiles {
+				file, relocateErr := c.relocateVulkanIcd(file)
+				if relocateErr != nil {
+					logger.Printf("Warning: skipping the Vulkan ICD %s: %v", file.Path, relocateErr)
+					continue
+				}
+				files = append(files, file)
+			}
+			// the device nodes are exposed along with /dev/dri, while the
+			// whole /dev is already bound with deviceAll
+			if o.DeviceDri && !o.DeviceAll {
+				for _, device := range driver.Devices {
+					files = append(files, types.GpuFile{Path: device, Kind: types.GpuFileDevice})
+				}
+			}
+		}
+	}
+
+	cdiFiles, cdiEnv, err := ResolveCdiDevices(o.CdiDevices)
+	if err != nil {
+		return nil, nil, err
+	}
+	env = append(getGpuModuleEnv(files), cdiEnv...)
+	return append(files, cdiFiles...), env, nil
+}
+
+// imageLibraryDirs are the directories the libraries of the images are
+// looked up in when they have no ld.so cache.
+var imageLibraryDirs = []string{"lib", "lib64", "usr/lib", "usr/lib64", "lib/*-linux-gnu", "usr/lib/*-linux-gnu"}
+
+// dropImageGpuDependencies returns the given GPU files without the
+// libraries the drivers depend on which the image of the given application
+// ships too. The libraries under the GpuFilesPrefix are looked up by the
+// dynamic linker before the image ones, so the host copies would replace
+// them for every binary, not only for the drivers, and could require a
+// newer C library than the image one.
+func (c *Cpak) dropImageGpuDependencies(app types.Application, files []types.GpuFile) []types.GpuFile {
+	sonames := c.getImageSonames(app)
+	kept := []types.GpuFile{}
+	for _, file := range files {
+		if file.Dependency && sonames[file.Soname] {
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// getImageSonames returns the SONAMEs of the libraries shipped with the
+// image of the given application, as listed in its ld.so cache or, without
+// one, as found in the usual library directories.
+func (c *Cpak) getImageSonames(app types.Application) map[string]bool {
+	sonames := map[string]bool{}
+	if cachePath := c.findInLayers(app, "/etc/ld.so.cache", 0); cachePath != "" {
+		entries, err := tools.ReadLdCache(cachePath)
+		if err == nil {
+			for _, entry := range entries {
+				sonames[entry.Name] = true
+			}
+			return sonames
+		}
+		logger.Printf("Warning: failed to read the ld.so cache of %s: %v", app.Name, err)
+	}
+
+	for _, layer := range app.ParsedLayers {
+		for _, dir := range imageLibraryDirs {
+			matches, _ := filepath.Glob(filepath.Join(c.GetInStoreDir("layers", layer), dir, "*.so*"))
+			for _, match := range matches {
+				sonames[filepath.Base(match)] = true
+			}
+		}
+	}
+	return sonames
+}
+
+// GetGpuLibraryDirs returns the directories of the given libraries, as seen
+// in the container, so that the dynamic linker of the image finds them
+// under the GpuFilesPrefix.
+func GetGpuLibraryDirs(files []types.GpuFile) (dirs []string) {
+	for _, file := range files {
+		if file.Kind != types.GpuFileLibrary {
+			continue
+		}
+		target := file.Path
+		if file.Target != "" {
+			target = file.Target
+		}
+		dir := filepath.Dir(target)
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return
+}
+
+// ldCacheIndex indexes the ld.so cache entries by SONAME.
+type ldCacheIndex map[string][]tools.LdCacheEntry
+
+func newLdCacheIndex() (ldCacheIndex, error) {
+	entries, err := tools.ReadLdCache(tools.LdCachePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ld.so cache: %w", err)
+	}
+	index := ldCacheIndex{}
+	for _, entry := range entries {
+		index[entry.Name] = append(index[entry.Name], entry)
+	}
+	return index, nil
+}
+
+// glibcLibraries are the SONAMEs of the C library, which is never injected
+// since it must match the dynamic linker of the image.
+var glibcLibraries = []string{
+	"libc.so.6",
+	"libm.so.6",
+	"libdl.so.2",
+	"libpthread.so.0",
+	"librt.so.1",
+	"libresolv.so.2",
+	"libutil.so.1",
+	"libmvec.so.1",
+}
+
+// gpuFileSet collects the driver files, skipping duplicates.
+type gpuFileSet struct {
+	files []types.GpuFile
+	seen  map[string]bool
+}
+
+// add adds the given file, the libraries and the modules are bound under the
+// GpuFilesPrefix.
+func (s *gpuFileSet) add(file types.GpuFile) bool {
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+	if s.seen[file.Path] {
+		return false
+	}
+	s.seen[file.Path] = true
+	if file.Target == "" && (file.Kind == types.GpuFileLibrary || file.Kind == types.GpuFileModule) {
+		file.Target = filepath.Join(GpuFilesPrefix, file.Path)
+	}
+	s.files = append(s.files, file)
+	return true
+}
+
+// addLibrary adds the given library file, both as the path it is loaded
+// from and as the file that path points to.
+func (s *gpuFileSet) addLibrary(path string, kind string, soname string) (realPath string, err error) {
+	return s.addFile(types.GpuFile{Path: path, Kind: kind, Soname: soname})
+}
+
+// addFile adds the given file, both as its path and as the file that path
+// points to.
+func (s *gpuFileSet) addFile(file types.GpuFile) (realPath string, err error) {
+	realPath, err = filepath.EvalSymlinks(file.Path)
+	if err != nil {
+		return
+	}
+	s.add(file)
+	if realPath != file.Path {
+		file.Path = realPath
+		s.add(file)
+	}
+	return
+}
+
+// addDependencies adds the libraries the given ELF file depends on, found
+// through the ld.so cache, and their dependencies, marked as such. The C
+// library is left out.
+func (s *gpuFileSet) addDependencies(index ldCacheIndex, path string) {
+	dyn, err := tools.ReadElfDynamic(path)
+	if err != nil {
+		return
+	}
+	for _, needed := range dyn.Needed {
+		if slices.Contains(glibcLibraries, needed) || strings.HasPrefix(needed, "ld-linux") {
+			continue
+		}
+		for _, entry := range index[needed] {
+			// the cache lists every architecture, only the one of the
+			// dependent file is taken
+			depDyn, err := tools.ReadElfDynamic(entry.Path)
+			if err != nil || depDyn.Class != dyn.Class || depDyn.Machine != dyn.Machine {
+				continue
+			}
+			if s.seen[entry.Path] {
+				break
+			}
+			realPath, err := s.addFile(types.GpuFile{Path: entry.Path, Kind: types.GpuFileLibrary, Soname: needed, Dependency: true})
+			if err == nil {
+				s.addDependencies(index, realPath)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,468 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+
+	hrun_server "github.com/containerpak/hrun/pkg/server"
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"golang.org/x/term"
+)
+
+// hostExecDefaultCommands are the host commands every container can run,
+// regardless of its override.
+var hostExecDefaultCommands = []string{"xdg-open"}
+
+// GetHostExecCommands returns the host commands the containers using the
+// given override can run.
+func GetHostExecCommands(o types.Override) []string {
+	_, shims := GetOverrideMounts(o)
+
+	commands := slices.Clone(hostExecDefaultCommands)
+	for _, command := range append(o.AllowedHostCommands, shims...) {
+		if command != "" && !slices.Contains(commands, command) {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// GetHostCommandPolicies returns the policies for the host commands of the
+// given override, falling back to the default ones for the commands the
+// override does not define a policy for.
+func GetHostCommandPolicies(o types.Override) map[string]types.HostCommandPolicy {
+	policies := map[string]types.HostCommandPolicy{}
+	for command, policy := range types.DefaultHostCommandPolicies {
+		policies[command] = policy
+	}
+	for command, policy := range o.HostCommandPolicies {
+		policies[command] = policy
+	}
+	return policies
+}
+
+// HostExecServer runs the host commands requested by a container, after
+// checking them against the container policies.
+type HostExecServer struct {
+	SocketPath string
+	Allowed    []string
+	Policies   map[string]types.HostCommandPolicy
+
+	// ContainerPidFile is the pid file of the container, its mount table
+	// is used to translate the paths passed as arguments.
+	ContainerPidFile string
+
+	// AppId and ContainerId identify the container in the audit log.
+	AppId       string
+	ContainerId string
+
+	// emptyDir is the directory the commands run in when the client
+	// working directory is not shared with the host.
+	emptyDir string
+}
+
+// Serve listens on the server socket until a termination signal is
+// received.
+func (s *HostExecServer) Serve() error {
+	listener, err := net.Listen("unix", s.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	logger.Printf("Server is running on %s", listener.Addr())
+
+	// the directory is read-only, so that the commands cannot write in it
+	s.emptyDir, err = os.MkdirTemp("", "cpak-hostexec-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.emptyDir)
+	err = os.Chmod(s.emptyDir, 0500)
+	if err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	go func() {
+		<-sigCh
+		logger.Println("Shutdown signal received, closing server...")
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			logger.Printf("Error accepting connection: %v", err)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle checks the request sent on the given connection and hands it over
+// to hrun, which takes care of the PTY and the streams.
+func (s *HostExecServer) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		logger.Printf("Failed to read command: %v", err)
+		conn.Close()
+		return
+	}
+
+	var req types.HostExecRequest
+	err = json.Unmarshal(line, &req)
+	if err != nil || len(req.CommandAndArgs) == 0 {
+		logger.Printf("Invalid command request: %s", line)
+		conn.Close()
+		return
+	}
+
+	command, err := s.authorize(req)
+	if err != nil {
+		logger.Printf("DENIED %q: %v", req.CommandAndArgs, err)
+		s.audit(types.AuditDecisionDenied, fmt.Sprintf("%q: %v", req.CommandAndArgs, err))
+		fmt.Fprintf(conn, "cpak: host command denied: %v\r\n", err)
+		conn.Close()
+		return
+	}
+	logger.Printf("ALLOWED %q as %q", req.CommandAndArgs, command)
+	s.audit(types.AuditDecisionAllowed, fmt.Sprintf("%q", command))
+
+	// the command has already been checked, so hrun gets no allowlist, the
+	// request is replaced with the rewritten one and the rest of the
+	// stream is forwarded untouched
+	rewritten, err := json.Marshal(types.HostExecRequest{
+		CommandAndArgs: command,
+		Width:          req.Width,
+		Height:         req.Height,
+	})
+	if err != nil {
+		conn.Close()
+		return
+	}
+	hrun_server.HandleConnection(&replayConn{
+		Conn:   conn,
+		reader: io.MultiReader(bytes.NewReader(append(rewritten, '\n')), reader),
+	}, nil)
+}
+
+func (s *HostExecServer) audit(decision string, detail string) {
+	RecordAuditEvent(types.AuditEvent{
+		AppId:       s.AppId,
+		ContainerId: s.ContainerId,
+		Type:        types.AuditEventHostExec,
+		Decision:    decision,
+		Detail:      detail,
+	})
+}
+
+// authorize checks the given request against the allowlist and the policy
+// of the requested command, it returns the command to run on the host.
+func (s *HostExecServer) authorize(req types.HostExecRequest) (command []string, err error) {
+	name := req.CommandAndArgs[0]
+	if strings.ContainsAny(name, "/=") || strings.HasPrefix(name, "-") || !slices.Contains(s.Allowed, name) {
+		return nil, fmt.Errorf("%s is not an allowed host command", name)
+	}
+	policy := s.Policies[name]
+
+	// paths are only meaningful inside the container, they are translated
+	// to the host ones through the mount tables and refused when they point
+	// to files the host cannot see
+	var containerMounts, hostMounts []tools.MountInfo
+	translate := func(path string) (string, error) {
+		if containerMounts == nil {
+			var tablesErr error
+			containerMounts, hostMounts, tablesErr = s.readMountTables()
+			if tablesErr != nil {
+				return "", fmt.Errorf("unable to translate paths: %w", tablesErr)
+			}
+		}
+		hostPath, ok := tools.TranslatePath(path, containerMounts, hostMounts)
+		if !ok {
+			return "", fmt.Errorf("path %s is not shared with the host", path)
+		}
+		return hostPath, nil
+	}
+
+	args := []string{}
+	endOfFlags := false
+	subcommand := ""
+	for _, arg := range req.CommandAndArgs[1:] {
+		if !endOfFlags {
+			if arg == "--" {
+				endOfFlags = true
+			} else if flag, forbidden := matchForbiddenFlag(arg, policy.ForbiddenFlags); forbidden {
+				return nil, fmt.Errorf("flag %s is forbidden for %s", flag, name)
+			}
+		}
+
+		if len(policy.Subcommands) > 0 && subcommand == "" {
+			if endOfFlags || !strings.HasPrefix(arg, "-") {
+				if !slices.Contains(policy.Subcommands, arg) {
+					return nil, fmt.Errorf("%s %s is not an allowed subcommand", name, arg)
+				}
+				subcommand = arg
+			}
+		}
+
+		if len(policy.AllowedArgs) > 0 && !matchAllowedArg(arg, policy.AllowedArgs) {
+			return nil, fmt.Errorf("argument %q does not match the %s policy", arg, name)
+		}
+
+		// the value of "--flag=value" and "-Cvalue" arguments is checked
+		// as well, the value attached to an unknown short flag cannot be
+		// told apart from other flags, so it cannot hold a path
+		prefix, value := "", arg
+		if strings.HasPrefix(arg, "-") && !endOfFlags {
+			isLong := strings.HasPrefix(arg, "--")
+			flag, flagValue, found := strings.Cut(arg, "=")
+			if isLong && found {
+				prefix, value = flag+"=", flagValue
+			} else if !isLong && len(arg) > 2 && slices.Contains(policy.ValueFlags, arg[:2]) {
+				prefix, value = arg[:2], arg[2:]
+			} else {
+				if strings.Contains(arg, "..") || (!isLong && strings.Contains(arg, "/")) {
+					return nil, fmt.Errorf("path in flag %s cannot be resolved", arg)
+				}
+				args = append(args, arg)
+				continue
+			}
+		}
+
+		path := ""
+		isURL := false
+		if u, parseErr := url.Parse(value); parseErr == nil && isURLArg(value, u) {
+			if len(policy.AllowedSchemes) > 0 && !slices.Contains(policy.AllowedSchemes, strings.ToLower(u.Scheme)) {
+				return nil, fmt.Errorf("URL scheme %s is not allowed for %s", u.Scheme, name)
+			}
+			if strings.EqualFold(u.Scheme, "file") {
+				path = u.Path
+				isURL = true
+			}
+		} else if filepath.IsAbs(value) {
+			path = value
+		} else if escapesDir(value) {
+			// relative paths leaving the working directory are resolved
+			// in the container, the host one can be a different directory
+			if !filepath.IsAbs(req.Cwd) {
+				return nil, fmt.Errorf("relative path %s cannot be resolved", value)
+			}
+			path = filepath.Join(req.Cwd, value)
+		}
+
+		if path != "" {
+			hostPath, translateErr := translate(path)
+			if translateErr != nil {
+				return nil, translateErr
+			}
+			if isURL {
+				hostPath = (&url.URL{Scheme: "file", Path: hostPath}).String()
+			}
+			arg = prefix + hostPath
+		}
+		args = append(args, arg)
+	}
+	if len(policy.Subcommands) > 0 && subcommand == "" {
+		return nil, fmt.Errorf("a subcommand is required for %s", name)
+	}
+
+	// the command runs in the host directory matching the client one, so
+	// that the remaining relative paths point to the same files, or in an
+	// empty directory when the client one is not shared with the host
+	dir := s.emptyDir
+	if filepath.IsAbs(req.Cwd) {
+		if hostCwd, translateErr := translate(req.Cwd); translateErr == nil {
+			dir = hostCwd
+		}
+	}
+
+	// hrun runs the commands with the server environment and working
+	// directory, so both are set through env
+	command = []string{"env"}
+	if dir != "" {
+		command = append(command, "-C", dir)
+	}
+	for _, envVar := range req.Env {
+		key, _, found := strings.Cut(envVar, "=")
+		if found && key != "" && slices.Contains(policy.EnvPassthrough, key) {
+			command = append(command, envVar)
+		}
+	}
+	for _, envVar := range policy.Env {
+		// anything else would be run by env as the command
+		if key, _, found := strings.Cut(envVar, "="); found && key != "" && !strings.HasPrefix(key, "-") {
+			command = append(command, envVar)
+		}
+	}
+	command = append(command, name)
+	command = append(command, policy.Args...)
+	return append(command, args...), nil
+}
+
+// escapesDir checks if the given relative path has ".." elements, which
+// could make it point outside of the working directory.
+func escapesDir(path string) bool {
+	return slices.Contains(strings.Split(path, "/"), "..")
+}
+
+// readMountTables returns the mount tables of the container and the host.
+func (s *HostExecServer) readMountTables() (container []tools.MountInfo, host []tools.MountInfo, err error) {
+	pidFile, err := tools.ReadPidFile(s.ContainerPidFile)
+	if err != nil {
+		return
+	}
+	err = pidFile.Verify()
+	if err != nil {
+		return
+	}
+
+	container, err = tools.ReadMountInfo(pidFile.Pid)
+	if err != nil {
+		return
+	}
+	host, err = tools.ReadMountInfo(os.Getpid())
+	return
+}
+
+// matchForbiddenFlag checks the given argument against the forbidden flags,
+// in their "-c", "-cvalue" and "--flag=value" forms. Abbreviated long flags
+// are not matched: a prefix can stand for other flags of the command,
+// which cannot be told apart here.
+func matchForbiddenFlag(arg string, forbidden []string) (string, bool) {
+	for _, flag := range forbidden {
+		if arg == flag {
+			return flag, true
+		}
+		if strings.HasPrefix(flag, "--") {
+			if strings.HasPrefix(arg, flag+"=") {
+				return flag, true
+			}
+		} else if len(flag) == 2 && flag[0] == '-' && strings.HasPrefix(arg, flag) && !strings.HasPrefix(arg, "--") {
+			return flag, true
+		}
+	}
+	return "", false
+}
+
+// matchAllowedArg checks the given argument against the allowed patterns.
+func matchAllowedArg(arg string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err == nil && re.MatchString(arg) {
+				return true
+			}
+			continue
+		}
+		if matched, err := filepath.Match(pattern, arg); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isURLArg checks if the given argument, parsed as u, is a URL rather than
+// a flag or a plain path.
+func isURLArg(arg string, u *url.URL) bool {
+	return len(u.Scheme) > 1 && !strings.HasPrefix(arg, "-") && strings.Contains(arg, ":")
+}
+
+// replayConn is a connection whose reads come from the given reader, used
+// to hand over a connection whose first bytes have already been read.
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// HostExec runs the given command on the host through the hostexec server
+// listening on the given socket, attaching it to the current terminal.
+func HostExec(command []string, socketPath string) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the hostexec server: %w", err)
+	}
+	defer conn.Close()
+
+	stdinFd := int(os.Stdin.Fd())
+	width, height, err := term.GetSize(stdinFd)
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	// the whole environment is sent, the server only keeps the variables
+	// allowed by the command policy
+	cwd, _ := os.Getwd()
+	req, err := json.Marshal(types.HostExecRequest{
+		CommandAndArgs: command,
+		Width:          uint16(width),
+		Height:         uint16(height),
+		Env:            os.Environ(),
+		Cwd:            cwd,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(req, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to send the command: %w", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+	defer signal.Stop(sigCh)
+	go func() {
+		for range sigCh {
+			if width, height, err := term.GetSize(stdinFd); err == nil {
+				fmt.Fprintf(conn, "resize:%d:%d\n", width, height)
+			}
+		}
+	}()
+
+	if term.IsTerminal(stdinFd) {
+		state, rawErr := term.MakeRaw(stdinFd)
+		if rawErr == nil {
+			defer term.Restore(stdinFd, state)
+		}
+	}
+
+	doneCh := make(chan struct{}, 2)
+	go func() {
+		io.Copy(conn, os.Stdin)
+		doneCh <- struct{}{}
+	}()
+	go func() {
+		io.Copy(os.Stdout, conn)
+		doneCh <- struct{}{}
+	}()
+	<-doneCh
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// gitServer returns a server allowing git with the default policy.
+func gitServer() *HostExecServer {
+	return &HostExecServer{
+		Allowed:  []string{"git"},
+		Policies: GetHostCommandPolicies(types.NewOverride()),
+	}
+}
+
+func TestAuthorizeGitDenied(t *testing.T) {
+	requests := [][]string{
+		{"git"},
+		{"git", "--version"},
+		{"git", "-c", "core.sshCommand=evil", "fetch"},
+		{"git", "-ccore.pager=evil", "log"},
+		{"git", "--config-env=core.pager=EVIL", "log"},
+		{"git", "--exec-path=/tmp", "status"},
+		{"git", "config", "core.fsmonitor", "evil"},
+		{"git", "rebase", "-x", "evil", "HEAD~1"},
+		{"git", "rebase", "--exec=evil", "HEAD~1"},
+		{"git", "difftool", "-x", "evil"},
+		{"git", "difftool", "--extcmd=evil"},
+		{"git", "grep", "-O", "pattern"},
+		{"git", "grep", "--open-files-in-pager=evil", "pattern"},
+		{"git", "bisect", "run", "evil"},
+		{"git", "st"},
+		{"git", "fetch", "--upload-pack=evil", "origin"},
+		{"git", "push", "--receive-pack=evil", "origin"},
+		{"git", "diff", "--ext-diff"},
+		{"git", "log", "--textconv", "-p"},
+		{"ssh", "host"},
+		{"/usr/bin/git", "status"},
+	}
+	s := gitServer()
+	for _, request := range requests {
+		command, err := s.authorize(types.HostExecRequest{CommandAndArgs: request})
+		if err == nil {
+			t.Errorf("authorize(%q) = %q, want an error", request, command)
+		}
+	}
+}
+
+func TestAuthorizeGitAllowed(t *testing.T) {
+	s := gitServer()
+	command, err := s.authorize(types.HostExecRequest{
+		CommandAndArgs: []string{"git", "--no-pager", "commit", "-m", "message", "--", "file"},
+		Env:            []string{"GIT_CONFIG_NOSYSTEM=0", "GIT_DIR=evil"},
+	})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+
+	git := slices.Index(command, "git")
+	if git < 0 {
+		t.Fatalf("command = %q, want git to run", command)
+	}
+	env, args := command[:git], command[git+1:]
+	if !slices.Contains(env, "GIT_CONFIG_NOSYSTEM=1") || slices.Contains(env, "GIT_DIR=evil") || slices.Contains(env, "GIT_CONFIG_NOSYSTEM=0") {
+		t.Errorf("environment = %q, want only GIT_CONFIG_NOSYSTEM=1", env)
+	}
+	for _, setting := range []string{
+		"core.hooksPath=/dev/null",
+		"core.fsmonitor=false",
+		"core.sshCommand=ssh",
+		"core.pager=cat",
+		"protocol.ext.allow=never",
+	} {
+		i := slices.Index(args, setting)
+		if i < 1 || args[i-1] != "-c" {
+			t.Errorf("arguments = %q, want -c %s", args, setting)
+		}
+	}
+	if want := []string{"--no-pager", "commit", "-m", "message", "--", "file"}; !slices.Equal(args[len(args)-len(want):], want) {
+		t.Errorf("arguments = %q, want them to end with %q", args, want)
+	}
+}
+
+func TestAuthorizeEnv(t *testing.T) {
+	s := &HostExecServer{
+		Allowed: []string{"tool"},
+		Policies: map[string]types.HostCommandPolicy{
+			"tool": {
+				EnvPassthrough: []string{"LANG"},
+				Env:            []string{"FIXED=1", "-i", "evil"},
+			},
+		},
+	}
+	command, err := s.authorize(types.HostExecRequest{
+		CommandAndArgs: []string{"tool"},
+		Env:            []string{"LANG=C", "PATH=/evil"},
+	})
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	if want := []string{"env", "LANG=C", "FIXED=1", "tool"}; !slices.Equal(command, want) {
+		t.Errorf("command = %q, want %q", command, want)
+	}
+}
+
+func TestAuthorizeAttachedValues(t *testing.T) {
+	s := &HostExecServer{
+		Allowed: []string{"tool"},
+		Policies: map[string]types.HostCommandPolicy{
+			"tool": {ValueFlags: []string{"-m", "-C"}},
+		},
+		ContainerPidFile: "/nonexistent",
+	}
+
+	// paths are translated, which fails without a container here
+	for _, arg := range []string{"-C/etc", "-ofile/etc", "-o/etc", "-C../dir", "-x=/etc", "--dir=/etc", "--dir=../etc"} {
+		command, err := s.authorize(types.HostExecRequest{CommandAndArgs: []string{"tool", arg}})
+		if err == nil {
+			t.Errorf("authorize(%q) = %q, want an error", arg, command)
+		}
+	}
+
+	for _, arg := range []string{"-mfix a typo", "-abc", "--dir=relative", "--flag"} {
+		command, err := s.authorize(types.HostExecRequest{CommandAndArgs: []string{"tool", arg}})
+		if err != nil {
+			t.Errorf("authorize(%q): %v", arg, err)
+			continue
+		}
+		if command[len(command)-1] != arg {
+			t.Errorf("authorize(%q) = %q, want the argument untouched", arg, command)
+		}
+	}
+}
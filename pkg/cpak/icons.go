@@ -0,0 +1,213 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// hicolorDirs are the directories of the hicolor theme, as listed in its
+// index.theme, the desktops do not look up icons of other sizes.
+var hicolorDirs = []string{
+	"16x16", "22x22", "24x24", "32x32", "36x36", "48x48", "64x64", "72x72",
+	"96x96", "128x128", "192x192", "256x256", "512x512", "scalable", "symbolic",
+}
+
+// iconExtensions are the supported icon formats, by preference.
+var iconExtensions = []string{".svg", ".svgz", ".png", ".xpm"}
+
+// iconSymlinkDepth limits the symlinks followed while looking up a file in
+// the application layers.
+const iconSymlinkDepth = 8
+
+func getIconsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "icons"), nil
+}
+
+// exportIcon exports the given icon of a desktop entry into the hicolor
+// theme of the user, in all the sizes found in the application layers, and
+// returns the name it has been exported as, which is specific to the
+// application so that it does not clash with other icons. Theme icons are
+// looked up in the hicolor theme and in the pixmaps, absolute paths are
+// exported in their own size. If the icon is not found, an empty name is
+// returned. The exported files are recorded in the store along with the
+// given desktop entry.
+func (c *Cpak) exportIcon(store *Store, app types.Application, icon string, desktopEntry string) (string, error) {
+	iconsDir, err := getIconsDir()
+	if err != nil {
+		return "", err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(icon), filepath.Ext(icon))
+	exportedName := "cpak-" + exportName(app) + "-" + base
+
+	// sources maps the exported files to the ones found in the layers
+	sources := map[string]string{}
+	addSource := func(themeDir string, name string, path string) {
+		ext := filepath.Ext(path)
+		if themeDir == "" {
+			// not a hicolor size, the unthemed icons are looked up in the
+			// icons directory itself
+			sources[filepath.Join(iconsDir, name+ext)] = path
+			return
+		}
+		sources[filepath.Join(iconsDir, "hicolor", themeDir, "apps", name+ext)] = path
+	}
+
+	if filepath.IsAbs(icon) {
+		if path := c.findInLayers(app, icon, 0); path != "" {
+			addSource(getIconThemeDir(path), exportedName, path)
+		}
+	} else {
+		for _, dir := range hicolorDirs {
+			for _, suffix := range []string{"", "-symbolic"} {
+				for _, ext := range iconExtensions {
+					rel := filepath.Join("/usr/share/icons/hicolor", dir, "apps", icon+suffix+ext)
+					if path := c.findInLayers(app, rel, 0); path != "" {
+						addSource(dir, exportedName+suffix, path)
+						break
+					}
+				}
+			}
+		}
+		if len(sources) == 0 {
+			for _, ext := range iconExtensions {
+				if path := c.findInLayers(app, filepath.Join("/usr/share/pixmaps", icon+ext), 0); path != "" {
+					addSource(getIconThemeDir(path), exportedName, path)
+					break
+				}
+			}
+		}
+	}
+
+	if len(sources) == 0 {
+		logger.Printf("Warning: icon %s not found for app %s", icon, app.Name)
+		return "", nil
+	}
+
+	exported := 0
+	for dest, src := range sources {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return "", err
+		}
+		err = writeExport(store, app, types.ExportIcon, desktopEntry, dest, data, 0644)
+		if errors.Is(err, ErrExportConflict) {
+			logger.Printf("Warning: icon not exported: %v", err)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		exported++
+	}
+	logger.Printf("Exported icon %s in %d sizes", exportedName, exported)
+	return exportedName, nil
+}
+
+// findInLayers returns the path of the given file in the topmost layer of
+// the application holding it, following the symlinks across the layers.
+// Only regular files are returned, an empty path is returned otherwise.
+func (c *Cpak) findInLayers(app types.Application, path string, depth int) string {
+	if depth > iconSymlinkDepth {
+		return ""
+	}
+	// layers are listed from the topmost one, as they are mounted
+	for _, layer := range app.ParsedLayers {
+		candidate := filepath.Join(c.GetInStoreDir("layers", layer), path)
+		info, err := os.Lstat(candidate)
+		if err != nil {
+			continue
+		}
+		if info.Mode().IsRegular() {
+			return candidate
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			// e.g. a whiteout hiding the file of the lower layers
+			return ""
+		}
+
+		target, err := os.Readlink(candidate)
+		if err != nil {
+			return ""
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return c.findInLayers(app, target, depth+1)
+	}
+	return ""
+}
+
+// getIconThemeDir returns the hicolor directory matching the size of the
+// given icon file, empty if it does not match any.
+func getIconThemeDir(path string) string {
+	switch filepath.Ext(path) {
+	case ".svg", ".svgz":
+		return "scalable"
+	case ".png":
+		file, err := os.Open(path)
+		if err != nil {
+			return ""
+		}
+		defer file.Close()
+		config, _, err := image.DecodeConfig(file)
+		if err != nil || config.Width != config.Height {
+			return ""
+		}
+		dir := fmt.Sprintf("%dx%d", config.Width, config.Height)
+		if slices.Contains(hicolorDirs, dir) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// refreshIconCache updates the icon cache of the hicolor theme of the user,
+// if it has one, so that the exported icons are found. The theme directory
+// mtime is updated anyway, which makes the desktops look it up again.
+func refreshIconCache() {
+	iconsDir, err := getIconsDir()
+	if err != nil {
+		return
+	}
+	hicolorDir := filepath.Join(iconsDir, "hicolor")
+	if _, err := os.Stat(hicolorDir); err != nil {
+		return
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(hicolorDir, now, now)
+
+	if _, err := os.Stat(filepath.Join(hicolorDir, "icon-theme.cache")); err != nil {
+		return
+	}
+	for _, tool := range []string{"gtk-update-icon-cache", "gtk4-update-icon-cache"} {
+		if path, err := exec.LookPath(tool); err == nil {
+			err = exec.Command(path, "-q", "-t", "-f", hicolorDir).Run()
+			if err != nil {
+				logger.Printf("Warning: failed to update the icon cache: %v", err)
+			}
+			return
+		}
+	}
+}
@@ -8,18 +8,16 @@ package cpak
 import (
 	"encoding/base64"
 	"fmt"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/mirkobrombin/cpak/pkg/logger"
-	"github.com/mirkobrombin/cpak/pkg/tools"
 	"github.com/mirkobrombin/cpak/pkg/types"
 )
 
 // Install installs a package from a given origin. The origin must be a git
-// repository with a valid cpak manifest file in the root directory.
+// repository with a valid cpak manifest file in the root directory, or the
+// short name of an application of the catalogs.
 // The branch, release and commit parameters are used to select the version of
 // the package to install. Note that those parameters are mutually exclusive,
 // the installation will fail if more than one of them is specified.
@@ -29,7 +27,10 @@ import (
 // InstallCpak functions instead, that way they can implement their own
 // installation logic, by showing more detailed information to the user.
 func (c *Cpak) Install(origin, branch, release, commit string) (err error) {
-	origin = strings.ToLower(origin)
+	origin, branch, release, commit, err = c.ResolveRemote(strings.ToLower(origin), branch, release, commit)
+	if err != nil {
+		return err
+	}
 
 	versionParams := []string{branch, release, commit}
 	versionParamsCount := 0
@@ -96,6 +97,27 @@ func (c *Cpak) InstallCpak(origin string, manifest *types.CpakManifest, branch s
 		return
 	}
 
+	// the profile extended by the manifest is expanded, so that its
+	// permissions are reviewed and stored along with the manifest ones
+	err = ExpandManifestOverride(manifest)
+	if err != nil {
+		return fmt.Errorf("invalid manifest override: %w", err)
+	}
+
+	// permissions are compared to the ones granted to the installed versions
+	// of the same origin, if any, so that updates only ask for escalations
+	if c.ConfirmPermissions != nil {
+		granted, installed, grantedErr := getGrantedPermissions(store, origin)
+		if grantedErr != nil {
+			return grantedErr
+		}
+		override, approved := c.ConfirmPermissions(origin, manifest, granted, installed)
+		if !approved {
+			return ErrPermissionsDenied
+		}
+		manifest.Override = override
+	}
+
 	// first we resolve its dependencies
 	var parsedManifestDependencies []types.Dependency
 	for _, depManifest := range manifest.Dependencies {
@@ -153,9 +175,12 @@ func (c *Cpak) InstallCpak(origin string, manifest *types.CpakManifest, branch s
 		ParsedLayers:         layers,
 		Config:               config,
 		ParsedOverride:       manifest.Override,
+		ParsedServices:       manifest.Services,
+		ParsedDBusServices:   manifest.DBusServices,
 	}
+	app.ParsedInfo = c.getAppInfo(app, manifest)
 
-	err = c.createExports(app)
+	err = c.createExports(store, app)
 	if err != nil {
 		return
 	}
@@ -164,148 +189,19 @@ func (c *Cpak) InstallCpak(origin string, manifest *types.CpakManifest, branch s
 	if err != nil {
 		return
 	}
+	enableAutostartServices(app)
+	c.exportCatalog(store)
 
-	return nil
-}
-
-func isURL(s string) bool {
-	return len(s) > 3 && (strings.HasPrefix(s, "http") || strings.Contains(s, "/"))
-}
-
-// createExports creates the exports for a given application.
-func (c *Cpak) createExports(app types.Application) (err error) {
-	for _, entry := range app.ParsedDesktopEntries {
-		for _, layer := range app.ParsedLayers {
-			layerDir := c.GetInStoreDir("layers", layer)
-			err = c.exportDesktopEntry(layerDir, app, entry)
-			if err == nil {
-				break
-			}
-		}
-	}
-
-	for _, binary := range app.ParsedBinaries {
-		err = c.exportBinary(app, binary)
-		if err != nil {
-			return
-		}
-	}
-	return
-}
-
-// exportDesktopEntry exports a desktop entry to the user's home directory
-// it also exports the icon defined in the desktop entry. If the icon is not
-// an absolute path, it looks for it in the common directories, preferring the
-// one with the highest resolution.
-func (c *Cpak) exportDesktopEntry(rootFs string, app types.Application, desktopEntry string) error {
-	home := os.Getenv("HOME")
-
-	var originalPath string
-	entryBase := filepath.Base(desktopEntry)
-	direct := filepath.Join(rootFs, strings.TrimLeft(desktopEntry, "/"))
-	if _, err := os.Stat(direct); err == nil {
-		originalPath = direct
-	} else {
-		_ = filepath.Walk(rootFs, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info.IsDir() {
-				return nil
-			}
-			if filepath.Base(path) == entryBase {
-				originalPath = path
-				return filepath.SkipDir
-			}
-			return nil
-		})
-	}
-	if originalPath == "" {
-		return fmt.Errorf("desktop entry %s not found under %s", entryBase, rootFs)
-	}
-
-	desktopDir := filepath.Join(home, ".local", "share", "applications", app.CpakId)
-	if err := os.MkdirAll(desktopDir, 0755); err != nil {
-		return err
-	}
-	desktopDest := filepath.Join(desktopDir, entryBase)
-
-	data, err := os.ReadFile(originalPath)
+	err = inheritOverride(store, app)
 	if err != nil {
-		return err
-	}
-	content := string(data)
-
-	var iconName string
-	for _, line := range strings.Split(content, "\n") {
-		if strings.HasPrefix(line, "Icon=") {
-			iconName = strings.TrimPrefix(line, "Icon=")
-			break
-		}
-	}
-	if iconName == "" {
-		return nil
-	}
-
-	var absIconPath string
-	for _, layer := range app.ParsedLayers {
-		layerDir := c.GetInStoreDir("layers", layer)
-		cand := filepath.Clean(layerDir + iconName)
-		if _, err := os.Stat(cand); err == nil {
-			absIconPath = cand
-			break
-		}
-	}
-
-	if absIconPath == "" && filepath.IsAbs(iconName) {
-		if _, err := os.Stat(iconName); err == nil {
-			absIconPath = iconName
-		}
-	}
-
-	if absIconPath != "" {
-		ext := filepath.Ext(absIconPath)
-		iconDest := filepath.Join(os.Getenv("HOME"), ".local", "share", "icons", app.CpakId+ext)
-		if err := os.MkdirAll(filepath.Dir(iconDest), 0755); err != nil {
-			return err
-		}
-		if err := tools.CopyFile(absIconPath, iconDest); err != nil {
-			return err
-		}
-		logger.Printf("Exported icon to %s", iconDest)
-		iconName = iconDest
-	} else {
-		logger.Printf("Warning: icon %s not found for app %s", iconName, app.Name)
+		logger.Printf("Warning: failed to keep the override of %s: %v", origin, err)
 	}
 
-	lines := strings.Split(content, "\n")
-	for i, line := range lines {
-		if strings.HasPrefix(line, "Exec=") {
-			cmdPart := strings.TrimPrefix(line, "Exec=")
-			lines[i] = "Exec=cpak run " + app.Origin + " @" + cmdPart
-		}
-		if strings.HasPrefix(line, "Icon=") && iconName != "" {
-			lines[i] = "Icon=" + iconName
-		}
-	}
-	newContent := strings.Join(lines, "\n")
-	return os.WriteFile(desktopDest, []byte(newContent), 0755)
+	return nil
 }
 
-func (c *Cpak) exportBinary(app types.Application, binary string) error {
-	destinationItems := []string{c.Options.ExportsPath}
-	destinationItems = append(destinationItems, strings.Split(app.Origin, "/")...)
-	destinationItems = append(destinationItems, filepath.Base(binary))
-	destinationPath := filepath.Join(destinationItems...)
-
-	err := os.MkdirAll(filepath.Dir(destinationPath), 0755)
-	if err != nil {
-		return err
-	}
-
-	scriptContent := fmt.Sprintf("#!/bin/sh\ncpak run %s @%s \"$@\"\n", app.Origin, binary)
-	err = os.WriteFile(destinationPath, []byte(scriptContent), 0755)
-	if err != nil {
-		return err
-	}
-	return nil
+func isURL(s string) bool {
+	return len(s) > 3 && (strings.HasPrefix(s, "http") || strings.Contains(s, "/"))
 }
 
 // Remove removes a package from the local store, including all the containers
@@ -329,6 +225,19 @@ func (c *Cpak) Remove(origin string, branch string, commit string, release strin
 		return fmt.Errorf("failed to stop containers for %s: %w", appToRemove.Name, err)
 	}
 
+	// the stopped persistent containers keep their record, they are
+	// discarded along with the application
+	containers, err := store.GetApplicationContainers(appToRemove)
+	if err != nil {
+		return fmt.Errorf("failed to get containers for %s: %w", appToRemove.Name, err)
+	}
+	for _, container := range containers {
+		discardErr := c.DiscardContainer(container)
+		if discardErr != nil {
+			logger.Printf("Warning: failed to discard container %s: %v", container.CpakId, discardErr)
+		}
+	}
+
 	switch {
 	case branch != "":
 		err = store.RemoveApplicationByOriginAndBranch(origin, branch)
@@ -344,10 +253,16 @@ func (c *Cpak) Remove(origin string, branch string, commit string, release strin
 		return fmt.Errorf("failed to remove application from store: %w", err)
 	}
 
-	err = c.removeExports(appToRemove)
+	err = c.removeExports(store, appToRemove)
 	if err != nil {
 		logger.Printf("Warning: failed to remove all exports for %s: %v", appToRemove.Name, err)
 	}
+	c.exportCatalog(store)
+
+	err = DeleteOverride(appToRemove)
+	if err != nil {
+		logger.Printf("Warning: failed to remove the override of %s: %v", appToRemove.Name, err)
+	}
 
 	// an Audit is needed to remove resources (containers, exports, etc.)
 	// which are not used anymore
@@ -357,46 +272,3 @@ func (c *Cpak) Remove(origin string, branch string, commit string, release strin
 	}
 	return
 }
-
-func (c *Cpak) removeExports(app types.Application) error {
-	home := os.Getenv("HOME")
-
-	desktopDir := filepath.Join(home, ".local", "share", "applications", app.CpakId)
-	if err := os.RemoveAll(desktopDir); err != nil {
-		logger.Printf("Warning: could not remove desktop entries dir %s: %v", desktopDir, err)
-	}
-
-	iconsDir := filepath.Join(home, ".local", "share", "icons")
-	entries, err := os.ReadDir(iconsDir)
-	if err == nil {
-		for _, e := range entries {
-			name := e.Name()
-			if strings.HasPrefix(name, app.CpakId+".") {
-				path := filepath.Join(iconsDir, name)
-				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-					logger.Printf("Warning: could not remove icon %s: %v", path, err)
-				}
-			}
-		}
-	}
-
-	for _, binary := range app.ParsedBinaries {
-		dst := filepath.Join(append([]string{c.Options.ExportsPath}, strings.Split(app.Origin, "/")...)...)
-		dst = filepath.Join(dst, filepath.Base(binary))
-		if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
-			logger.Printf("Warning: could not remove binary export %s: %v", dst, err)
-		}
-
-		dir := filepath.Dir(dst)
-		for dir != c.Options.ExportsPath && dir != "/" {
-			entries, err := os.ReadDir(dir)
-			if err != nil || len(entries) > 0 {
-				break
-			}
-			os.Remove(dir)
-			dir = filepath.Dir(dir)
-		}
-	}
-
-	return nil
-}
@@ -9,7 +9,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"unicode"
 
 	"github.com/mirkobrombin/cpak/pkg/types"
 )
@@ -29,6 +31,25 @@ func (c *Cpak) ValidateManifest(manifest *types.CpakManifest) (err error) {
 	if len(manifest.Binaries) == 0 {
 		return errors.New("binaries is mandatory and must be populated")
 	}
+	for _, binary := range manifest.Binaries {
+		err = validateBinaryPath(binary)
+		if err != nil {
+			return err
+		}
+	}
+	return validateServices(manifest)
+}
+
+// validateBinaryPath checks that the given exported binary is a clean
+// absolute path to a file, without control characters, since it is
+// written in the exported scripts and its name is the exported file one.
+func validateBinaryPath(binary string) error {
+	if !filepath.IsAbs(binary) || filepath.Clean(binary) != binary || binary == "/" {
+		return fmt.Errorf("invalid binary %q: must be a clean absolute path", binary)
+	}
+	if strings.ContainsFunc(binary, unicode.IsControl) {
+		return fmt.Errorf("invalid binary %q: must not contain control characters", binary)
+	}
 	return nil
 }
 
@@ -0,0 +1,313 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+	"golang.org/x/sys/unix"
+)
+
+// The vendor names of the Mesa drivers discovery.
+const (
+	GpuVendorAmd   = "amd"
+	GpuVendorIntel = "intel"
+)
+
+// mesaLibraries are the Mesa libraries loaded by the GL, EGL and GBM
+// dispatchers of the images, shared by all the vendors.
+var mesaLibraries = []string{
+	"libEGL_mesa.so.0",
+	"libGLX_mesa.so.0",
+	"libgbm.so.1",
+}
+
+// mesaModules are the Mesa driver modules shared by all the vendors,
+// relative to the directory of the mesaLibraries.
+var mesaModules = []string{
+	"dri/libdril_dri.so",
+	"gbm/dri_gbm.so",
+}
+
+// mesaConfigs are the configuration files of Mesa, as glob patterns.
+var mesaConfigs = []string{
+	"/usr/share/glvnd/egl_vendor.d/50_mesa.json",
+	"/usr/share/drirc.d/*.conf",
+}
+
+// mesaVulkanIcdDirs are the directories holding the Vulkan ICD manifests.
+var mesaVulkanIcdDirs = []string{"/usr/share/vulkan/icd.d", "/etc/vulkan/icd.d"}
+
+// mesaModuleEnv maps the directories of the Mesa driver modules to the
+// variables pointing the image loaders to them, since the host modules are
+// not bound at the paths built in the image loaders.
+var mesaModuleEnv = map[string][]string{
+	"dri":   {"LIBGL_DRIVERS_PATH", "LIBVA_DRIVERS_PATH"},
+	"gbm":   {"GBM_BACKENDS_PATH"},
+	"vdpau": {"VDPAU_DRIVER_PATH"},
+}
+
+// mesaProvider is the GpuProvider of the Mesa drivers of a vendor. Images
+// usually ship Mesa as well, so the host one is only injected when the
+// gpuDrivers override key is host, e.g. when the image Mesa is too old for
+// the host GPU.
+type mesaProvider struct {
+	vendor string
+
+	// kernelDrivers are the kernel drivers of the vendor GPUs, as linked
+	// in /sys/class/drm/card*/device/driver.
+	kernelDrivers []string
+
+	// vulkanIcds are the names of the Vulkan ICD manifests of the vendor,
+	// as glob patterns.
+	vulkanIcds []string
+
+	// modules are the DRI, VA-API and VDPAU drivers of the vendor, as glob
+	// patterns relative to the directory of the mesaLibraries.
+	modules []string
+}
+
+func newAmdProvider() mesaProvider {
+	return mesaProvider{
+		vendor:        GpuVendorAmd,
+		kernelDrivers: []string{"amdgpu", "radeon"},
+		vulkanIcds:    []string{"radeon_icd.*.json"},
+		modules: []string{
+			"dri/radeonsi_*.so",
+			"dri/r600_*.so",
+			"dri/r300_*.so",
+			"vdpau/libvdpau_radeonsi.so*",
+			"vdpau/libvdpau_r600.so*",
+		},
+	}
+}
+
+func newIntelProvider() mesaProvider {
+	return mesaProvider{
+		vendor:        GpuVendorIntel,
+		kernelDrivers: []string{"i915", "xe"},
+		vulkanIcds:    []string{"intel_icd.*.json", "intel_hasvk_icd.*.json"},
+		modules: []string{
+			"dri/iris_*.so",
+			"dri/crocus_*.so",
+			"dri/i965_*.so",
+			"dri/iHD_drv_video.so",
+		},
+	}
+}
+
+func (p mesaProvider) Vendor() string {
+	return p.vendor
+}
+
+// Detect looks for a GPU driven by one of the vendor kernel drivers, which
+// are part of the kernel, so the kernel release is their version.
+func (p mesaProvider) Detect() (version string, found bool, err error) {
+	drivers, _ := filepath.Glob("/sys/class/drm/card[0-9]*/device/driver")
+	for _, driver := range drivers {
+		target, err := filepath.EvalSymlinks(driver)
+		if err != nil || !slices.Contains(p.kernelDrivers, filepath.Base(target)) {
+			continue
+		}
+
+		var uts unix.Utsname
+		err = unix.Uname(&uts)
+		if err != nil {
+			return "", false, err
+		}
+		return unix.ByteSliceToString(uts.Release[:]), true, nil
+	}
+	return "", false, nil
+}
+
+func (p mesaProvider) ScannedPaths() []string {
+	paths := []string{tools.LdCachePath, "/usr/share/glvnd/egl_vendor.d", "/usr/share/drirc.d"}
+	return append(paths, mesaVulkanIcdDirs...)
+}
+
+// Discover returns the Mesa libraries and the vendor drivers, along with
+// the libraries they depend on, since the image ones could be too old.
+func (p mesaProvider) Discover(version string) ([]types.GpuFile, error) {
+	index, err := newLdCacheIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	set := gpuFileSet{}
+	libDirs := []string{}
+	for _, name := range mesaLibraries {
+		for _, entry := range index[name] {
+			realPath, err := set.addLibrary(entry.Path, types.GpuFileLibrary, name)
+			if err != nil {
+				continue
+			}
+			set.addDependencies(index, realPath)
+			if dir := filepath.Dir(entry.Path); !slices.Contains(libDirs, dir) {
+				libDirs = append(libDirs, dir)
+			}
+		}
+	}
+
+	// the drivers are next to the libraries, for every architecture
+	for _, dir := range libDirs {
+		for _, pattern := range append(slices.Clone(mesaModules), p.modules...) {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, match := range matches {
+				realPath, err := set.addLibrary(match, types.GpuFileModule, "")
+				if err == nil {
+					set.addDependencies(index, realPath)
+				}
+			}
+		}
+	}
+
+	for _, dir := range mesaVulkanIcdDirs {
+		for _, pattern := range p.vulkanIcds {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			for _, match := range matches {
+				libPath, err := readVulkanIcdLibrary(match)
+				if err != nil {
+					continue
+				}
+				set.add(types.GpuFile{Path: match, Kind: types.GpuFileConfig})
+
+				libPaths := []string{libPath}
+				if !filepath.IsAbs(libPath) {
+					libPaths = []string{}
+					for _, entry := range index[libPath] {
+						libPaths = append(libPaths, entry.Path)
+					}
+				}
+				for _, path := range libPaths {
+					realPath, err := set.addLibrary(path, types.GpuFileModule, "")
+					if err == nil {
+						set.addDependencies(index, realPath)
+					}
+				}
+			}
+		}
+	}
+
+	for _, pattern := range mesaConfigs {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			set.add(types.GpuFile{Path: match, Kind: types.GpuFileConfig})
+		}
+	}
+	return set.files, nil
+}
+
+// Devices returns no device, the Mesa drivers only use /dev/dri which is
+// exposed through the deviceDri override key.
+func (p mesaProvider) Devices() []string {
+	return nil
+}
+
+func (p mesaProvider) HostByDefault() bool {
+	return false
+}
+
+// readVulkanIcdLibrary returns the library of the given Vulkan ICD
+// manifest, either an absolute path or a SONAME.
+func readVulkanIcdLibrary(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	manifest := struct {
+		ICD struct {
+			LibraryPath string `json:"library_path"`
+		} `json:"ICD"`
+	}{}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return "", err
+	}
+	return manifest.ICD.LibraryPath, nil
+}
+
+// getGpuModuleEnv returns the variables pointing the image loaders to the
+// given driver modules, one directory per architecture. VDPAU only takes a
+// single directory, the first one is used.
+func getGpuModuleEnv(files []types.GpuFile) (env []string) {
+	dirs := map[string][]string{}
+	for _, file := range files {
+		if file.Kind != types.GpuFileModule || file.Target == "" {
+			continue
+		}
+		dir := filepath.Dir(file.Target)
+		for _, name := range mesaModuleEnv[filepath.Base(dir)] {
+			if !slices.Contains(dirs[name], dir) {
+				dirs[name] = append(dirs[name], dir)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range dirs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name == "VDPAU_DRIVER_PATH" {
+			env = append(env, name+"="+dirs[name][0])
+			continue
+		}
+		env = append(env, name+"="+strings.Join(dirs[name], ":"))
+	}
+	return
+}
+
+// relocateVulkanIcd returns the given file, or if it is a Vulkan ICD
+// manifest pointing to an absolute library path, a copy of it in the cache
+// pointing to the library under the GpuFilesPrefix, bound in place of the
+// original manifest.
+func (c *Cpak) relocateVulkanIcd(file types.GpuFile) (types.GpuFile, error) {
+	if file.Kind != types.GpuFileConfig || !slices.Contains(mesaVulkanIcdDirs, filepath.Dir(file.Path)) {
+		return file, nil
+	}
+
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return file, err
+	}
+	manifest := map[string]any{}
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return file, err
+	}
+	icd, ok := manifest["ICD"].(map[string]any)
+	if !ok {
+		return file, nil
+	}
+	libPath, ok := icd["library_path"].(string)
+	if !ok || !filepath.IsAbs(libPath) {
+		// SONAMEs are found through the ld.so cache of the container
+		return file, nil
+	}
+	icd["library_path"] = filepath.Join(GpuFilesPrefix, libPath)
+
+	dir, err := c.GetInCacheDirMkdir("gpu", filepath.Dir(file.Path))
+	if err != nil {
+		return file, err
+	}
+	data, err = json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return file, err
+	}
+	copyPath := filepath.Join(dir, filepath.Base(file.Path))
+	err = os.WriteFile(copyPath, append(data, '\n'), 0644)
+	if err != nil {
+		return file, err
+	}
+	return types.GpuFile{Path: copyPath, Kind: file.Kind, Target: file.Path}, nil
+}
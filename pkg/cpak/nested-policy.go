@@ -0,0 +1,183 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// NestedRunDecision is the outcome of the nested-run authorization.
+type NestedRunDecision struct {
+	Allowed bool
+
+	// Parent is the application whose container sent the request, it is
+	// empty for requests coming from the host.
+	Parent types.Application
+
+	// Target is the application requested to run.
+	Target types.Application
+
+	// ContainerId is the container which sent the request, if any.
+	ContainerId string
+
+	// Reason explains the decision, it is logged and sent back to the
+	// container when the request is denied.
+	Reason string
+}
+
+// authorizeNestedRun decides whether the process with the given pid can
+// run the application described by the given request. The parent
+// application is identified from the caller mount namespace, since the
+// ParentAppId sent by the container comes from a file the container itself
+// can write, it is only checked for consistency.
+//
+// A parent application can run itself, its dependencies, its addons and
+// the applications listed in the nestedRun field of its override. Anything
+// else is denied, unless the user approves it from a desktop prompt when
+// enabled in the options.
+func (c *Cpak) authorizeNestedRun(callerPid int, params types.RequestParams) (decision NestedRunDecision) {
+	defer func() {
+		outcome := "denied"
+		if decision.Allowed {
+			outcome = "allowed"
+		}
+		parent := decision.Parent.Origin
+		if parent == "" {
+			parent = "host"
+		}
+		logger.Printf("Nested run %s: %s -> %s (%s)", outcome, parent, params.Origin, decision.Reason)
+
+		auditDecision := types.AuditDecisionDenied
+		if decision.Allowed {
+			auditDecision = types.AuditDecisionAllowed
+		}
+		RecordAuditEvent(types.AuditEvent{
+			AppId:       decision.Parent.CpakId,
+			ContainerId: decision.ContainerId,
+			Type:        types.AuditEventNestedRun,
+			Decision:    auditDecision,
+			Detail:      fmt.Sprintf("%s %s %q: %s", params.Origin, params.Binary, params.ExtraArgs, decision.Reason),
+		})
+	}()
+
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		decision.Reason = fmt.Sprintf("failed to open store: %v", err)
+		return
+	}
+	defer store.Close()
+
+	decision.Target, err = store.GetApplicationByOrigin(params.Origin, params.Version, params.Branch, params.Commit, params.Release)
+	if err != nil || decision.Target.CpakId == "" {
+		decision.Reason = "application not installed"
+		return
+	}
+
+	parent, containerId, fromHost, err := c.findCallerApplication(store, callerPid)
+	if err != nil {
+		decision.Reason = err.Error()
+		return
+	}
+	if fromHost {
+		// the host user can run any application directly anyway
+		decision.Allowed = true
+		decision.Reason = "request from the host"
+		return
+	}
+	decision.Parent = parent
+	decision.ContainerId = containerId
+
+	if params.ParentAppId != "" && params.ParentAppId != parent.CpakId {
+		decision.Reason = "parent application id does not match the calling container"
+		return
+	}
+
+	allowed, reason := isNestedRunAllowed(parent, decision.Target)
+	if allowed {
+		decision.Allowed = true
+		decision.Reason = reason
+		return
+	}
+
+	decision.Reason = "not a dependency, addon or nestedRun entry of the parent application"
+	if c.Options.NestedRunPrompt {
+		approved := tools.ConfirmDesktop(
+			"cpak",
+			fmt.Sprintf("%s wants to run %s. Allow it?", parent.Origin, decision.Target.Origin),
+		)
+		if approved {
+			decision.Allowed = true
+			decision.Reason = "approved by the user"
+		}
+	}
+	return
+}
+
+// isNestedRunAllowed checks the parent application manifest and override
+// for the given target application.
+func isNestedRunAllowed(parent types.Application, target types.Application) (allowed bool, reason string) {
+	if parent.CpakId == target.CpakId {
+		return true, "same application"
+	}
+
+	for _, dep := range parent.ParsedDependencies {
+		if dep.Id == target.CpakId || dep.Origin == target.Origin {
+			return true, "dependency of the parent application"
+		}
+	}
+
+	if slices.Contains(parent.ParsedAddons, target.Origin) {
+		return true, "addon of the parent application"
+	}
+
+	if slices.Contains(GetApplicationOverride(parent).NestedRun, target.Origin) {
+		return true, "listed in the parent application nestedRun override"
+	}
+	return false, ""
+}
+
+// findCallerApplication returns the application whose container the
+// process with the given pid belongs to, by matching its mount namespace
+// against the one of the running containers. Processes sharing the service
+// mount namespace are reported as coming from the host.
+func (c *Cpak) findCallerApplication(store *Store, pid int) (app types.Application, containerId string, fromHost bool, err error) {
+	callerMnt, err := tools.GetProcNamespace(pid, "mnt")
+	if err != nil {
+		return app, "", false, fmt.Errorf("unable to identify the caller: %w", err)
+	}
+
+	selfMnt, err := tools.GetProcNamespace(os.Getpid(), "mnt")
+	if err == nil && selfMnt == callerMnt {
+		return app, "", true, nil
+	}
+
+	apps, err := store.GetApplications()
+	if err != nil {
+		return
+	}
+	for _, candidate := range apps {
+		containers, _ := store.GetApplicationContainers(candidate)
+		for _, container := range containers {
+			if container.Pid == 0 {
+				continue
+			}
+			pidFile, readErr := readContainerPidFile(container)
+			if readErr != nil {
+				continue
+			}
+			if pidFile.Namespaces["mnt"] == callerMnt {
+				return candidate, container.CpakId, false, nil
+			}
+		}
+	}
+	return app, "", false, fmt.Errorf("caller is not running in a cpak container")
+}
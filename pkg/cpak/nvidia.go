@@ -6,140 +6,218 @@
 package cpak
 
 import (
-	"io/fs"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
 )
 
-// GetNvidiaLibs returns all files relevant for NVIDIA integration.
-// This includes configuration files, binaries, and libraries.
-// Highly inspired to the Distrobox NVIDIA implementation.
-func GetNvidiaLibs() ([]string, error) {
-	var files []string
-	specs := []struct {
-		base      string
-		predicate func(string, fs.DirEntry) bool
-	}{
-		{"/etc", matchGenericConfig},
-		{"/usr", matchNonLibConfig},
-		{"/bin", matchBinary},
-		{"/sbin", matchBinary},
-		{"/usr/bin", matchBinary},
-		{"/usr/sbin", matchBinary},
-		{"/usr/lib", matchLibrary},
-		{"/usr/lib64", matchLibrary},
-		{"/usr/lib32", matchLibrary},
-	}
+// GpuVendorNvidia is the vendor name of the NVIDIA driver discovery.
+const GpuVendorNvidia = "nvidia"
 
-	for _, spec := range specs {
-		if info, err := os.Stat(spec.base); err != nil || !info.IsDir() {
-			continue
-		}
-		res, err := walkAndFilter(spec.base, spec.predicate)
-		if err != nil {
-			continue
-		}
-		files = append(files, res...)
-	}
+// nvidiaVersionPath is where the loaded NVIDIA kernel module reports its
+// version, it does not exist if the driver is not loaded.
+const nvidiaVersionPath = "/proc/driver/nvidia/version"
 
-	// Remove duplicates and files with hidden components.
-	cleaned := []string{}
-	seen := make(map[string]struct{})
-	for _, f := range files {
-		if isHiddenPath(f) {
-			continue
-		}
-		if _, ok := seen[f]; ok {
-			continue
-		}
-		seen[f] = struct{}{}
-		cleaned = append(cleaned, f)
-	}
+// nvidiaVersionRegexp matches the driver version in the first line of
+// nvidiaVersionPath, e.g. "NVRM version: NVIDIA UNIX x86_64 Kernel Module
+// 550.54.14 Thu Feb 22 01:44:30 UTC 2024".
+var nvidiaVersionRegexp = regexp.MustCompile(`\s(\d+\.\d+(?:\.\d+)?)\s`)
 
-	return cleaned, nil
+// nvidiaDriverLibraries are the libraries shipped with the driver, by
+// SONAME without the version. Their files are suffixed with the driver
+// version, which is used to skip leftovers of other driver versions.
+// Highly inspired to the libnvidia-container list.
+var nvidiaDriverLibraries = []string{
+	"libcuda",
+	"libcudadebugger",
+	"libnvcuvid",
+	"libnvoptix",
+	"libvdpau_nvidia",
+	"libEGL_nvidia",
+	"libGLESv1_CM_nvidia",
+	"libGLESv2_nvidia",
+	"libGLX_nvidia",
+	"libnvidia-allocator",
+	"libnvidia-api",
+	"libnvidia-cfg",
+	"libnvidia-eglcore",
+	"libnvidia-encode",
+	"libnvidia-fbc",
+	"libnvidia-glcore",
+	"libnvidia-glsi",
+	"libnvidia-glvkspirv",
+	"libnvidia-gpucomp",
+	"libnvidia-ml",
+	"libnvidia-ngx",
+	"libnvidia-nvvm",
+	"libnvidia-opencl",
+	"libnvidia-opticalflow",
+	"libnvidia-pkcs11",
+	"libnvidia-pkcs11-openssl3",
+	"libnvidia-ptxjitcompiler",
+	"libnvidia-rtcore",
+	"libnvidia-tls",
 }
 
-// walkAndFilter walks through the directory tree rooted at 'root'
-// and returns files that satisfy the predicate.
-func walkAndFilter(root string, predicate func(string, fs.DirEntry) bool) ([]string, error) {
-	var results []string
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if d.IsDir() {
-			return nil
-		}
-		if predicate(path, d) {
-			results = append(results, path)
-		}
-		return nil
-	})
-	return results, err
-}
-
-// matchGenericConfig returns true if the file path contains "nvidia".
-// Used to match generic configuration files in /etc.
-func matchGenericConfig(path string, d fs.DirEntry) bool {
-	return strings.Contains(path, "nvidia")
+// nvidiaExternalLibraries are the libraries used by the driver which are
+// versioned on their own, e.g. the EGL platform libraries.
+var nvidiaExternalLibraries = []string{
+	"libnvidia-egl-gbm",
+	"libnvidia-egl-wayland",
+	"libnvidia-egl-xcb",
+	"libnvidia-egl-xlib",
+	"libnvidia-vulkan-producer",
 }
 
-// group2Patterns holds patterns for non-library configuration files in /usr.
-var group2Patterns = []string{
+// nvidiaConfigFiles are the configuration files of the driver, relative to
+// the nvidiaConfigDirs.
+var nvidiaConfigFiles = []string{
 	"glvnd/egl_vendor.d/10_nvidia.json",
-	"X11/xorg.conf.d/10-nvidia.conf",
-	"X11/xorg.conf.d/nvidia-drm-outputclass.conf",
 	"egl/egl_external_platform.d/10_nvidia_wayland.json",
 	"egl/egl_external_platform.d/15_nvidia_gbm.json",
-	"nvidia/nvoptix.bin",
+	"egl/egl_external_platform.d/20_nvidia_xcb.json",
+	"egl/egl_external_platform.d/20_nvidia_xlib.json",
 	"vulkan/icd.d/nvidia_icd.json",
 	"vulkan/icd.d/nvidia_layers.json",
 	"vulkan/implicit_layer.d/nvidia_layers.json",
-	"nvidia.icd",
-	"nvidia.yaml",
-	"nvidia.json",
+	"OpenCL/vendors/nvidia.icd",
+	"nvidia/nvoptix.bin",
+	"nvidia/nvidia-application-profiles-rc",
+	"X11/xorg.conf.d/10-nvidia.conf",
+	"X11/xorg.conf.d/nvidia-drm-outputclass.conf",
+}
+
+// nvidiaConfigDirs are the directories the nvidiaConfigFiles are looked up
+// in.
+var nvidiaConfigDirs = []string{"/etc", "/usr/share"}
+
+// nvidiaBinaries are the command line utilities of the driver.
+var nvidiaBinaries = []string{
+	"nvidia-smi",
+	"nvidia-debugdump",
+	"nvidia-persistenced",
+	"nvidia-cuda-mps-control",
+	"nvidia-cuda-mps-server",
+}
+
+// nvidiaBinaryDirs are the directories the nvidiaBinaries are looked up
+// in, the first match wins.
+var nvidiaBinaryDirs = []string{"/usr/bin", "/usr/sbin", "/bin", "/sbin"}
+
+// nvidiaProvider is the GpuProvider of the NVIDIA proprietary driver, which
+// is always taken from the host since images cannot ship a driver matching
+// the host kernel module.
+type nvidiaProvider struct{}
+
+func (nvidiaProvider) Vendor() string {
+	return GpuVendorNvidia
+}
+
+func (nvidiaProvider) Detect() (version string, found bool, err error) {
+	version, err = getNvidiaDriverVersion()
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	return version, err == nil, err
 }
 
-// matchNonLibConfig returns true if the file path contains any of the patterns
-// specified in group2Patterns.
-func matchNonLibConfig(path string, d fs.DirEntry) bool {
-	for _, pat := range group2Patterns {
-		if strings.Contains(path, pat) {
-			return true
+func (nvidiaProvider) ScannedPaths() []string {
+	paths := []string{tools.LdCachePath}
+	for _, dir := range nvidiaConfigDirs {
+		for _, file := range nvidiaConfigFiles {
+			paths = append(paths, filepath.Dir(filepath.Join(dir, file)))
 		}
 	}
-	return false
+	return append(paths, nvidiaBinaryDirs...)
+}
+
+func (nvidiaProvider) Discover(version string) ([]types.GpuFile, error) {
+	return discoverNvidiaFiles(version)
 }
 
-// matchBinary returns true if the file name (lowercased) contains "nvidia".
-// Used to match Nvidia CLI utilities.
-func matchBinary(path string, d fs.DirEntry) bool {
-	return strings.Contains(strings.ToLower(d.Name()), "nvidia")
+func (nvidiaProvider) Devices() []string {
+	devices, _ := filepath.Glob("/dev/nvidia*")
+	caps, _ := filepath.Glob("/dev/nvidia-caps/*")
+	return append(devices, caps...)
 }
 
-// matchLibrary returns true if the file is a library matching Nvidia or CUDA patterns.
-// It checks for specific prefixes and substring conditions in the file name.
-func matchLibrary(path string, d fs.DirEntry) bool {
-	name := d.Name()
-	if strings.HasPrefix(name, "libnvcuvid") || strings.HasPrefix(name, "libnvoptix") {
-		return true
+func (nvidiaProvider) HostByDefault() bool {
+	return true
+}
+
+// getNvidiaDriverVersion returns the version of the loaded NVIDIA kernel
+// module.
+func getNvidiaDriverVersion() (string, error) {
+	data, err := os.ReadFile(nvidiaVersionPath)
+	if err != nil {
+		return "", err
 	}
-	if strings.Contains(name, ".so") {
-		if strings.Contains(name, "nvidia") || strings.Contains(name, "cuda") {
-			return true
-		}
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	m := nvidiaVersionRegexp.FindStringSubmatch(firstLine)
+	if m == nil {
+		return "", fmt.Errorf("unrecognized NVIDIA driver version: %s", firstLine)
 	}
-	return false
+	return m[1], nil
 }
 
-// isHiddenPath returns true if any component of the path starts with a dot.
-func isHiddenPath(path string) bool {
-	parts := strings.Split(path, string(os.PathSeparator))
-	for _, part := range parts {
-		if strings.HasPrefix(part, ".") {
-			return true
+// discoverNvidiaFiles returns the files of the given NVIDIA driver version:
+// the libraries listed in the ld.so cache whose SONAME matches, the
+// configuration files and the binaries.
+func discoverNvidiaFiles(version string) (files []types.GpuFile, err error) {
+	entries, err := tools.ReadLdCache(tools.LdCachePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the ld.so cache: %w", err)
+	}
+
+	set := gpuFileSet{}
+	for _, entry := range entries {
+		name, _, _ := strings.Cut(entry.Name, ".so")
+		driverLib := slices.Contains(nvidiaDriverLibraries, name)
+		if !driverLib && !slices.Contains(nvidiaExternalLibraries, name) {
+			continue
+		}
+
+		realPath, err := filepath.EvalSymlinks(entry.Path)
+		if err != nil {
+			continue
+		}
+		if driverLib && !strings.HasSuffix(realPath, ".so."+version) {
+			// a leftover of another driver version, which would not work
+			// with the loaded kernel module
+			continue
+		}
+		soname, err := tools.ReadElfSoname(realPath)
+		if err != nil || soname != entry.Name {
+			continue
+		}
+
+		_, _ = set.addLibrary(entry.Path, types.GpuFileLibrary, soname)
+	}
+
+	configFiles := append(slices.Clone(nvidiaConfigFiles), fmt.Sprintf("nvidia/nvidia-application-profiles-%s-rc", version))
+	for _, dir := range nvidiaConfigDirs {
+		for _, file := range configFiles {
+			path := filepath.Join(dir, file)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				set.add(types.GpuFile{Path: path, Kind: types.GpuFileConfig})
+			}
+		}
+	}
+
+	for _, binary := range nvidiaBinaries {
+		for _, dir := range nvidiaBinaryDirs {
+			path := filepath.Join(dir, binary)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				set.add(types.GpuFile{Path: path, Kind: types.GpuFileBinary})
+				break
+			}
 		}
 	}
-	return false
+	return set.files, nil
 }
@@ -0,0 +1,387 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// The override layers, from the lowest to the highest priority. Each layer
+// only sets the keys it defines, the others keep the value of the layers
+// below it. The policy layer is applied last and can only deny permissions.
+const (
+	OverrideSourceDefault  = "default"
+	OverrideSourceManifest = "manifest"
+	OverrideSourceUser     = "user"
+	OverrideSourceApp      = "app"
+	OverrideSourceRun      = "run"
+	OverrideSourcePolicy   = "policy"
+)
+
+// OverrideSourceProfile is the source of the keys coming from a profile
+// extended by a layer, the profile name is appended to it.
+const OverrideSourceProfile = "profile"
+
+// OverridePolicyPath is the path to the system override policy.
+const OverridePolicyPath = "/etc/cpak/policy.json"
+
+// overrideUserDefaultsName is the name of the user defaults override,
+// inside the user overrides directory.
+const overrideUserDefaultsName = "defaults.json"
+
+// EffectiveOverride is the result of merging the override layers of an
+// application.
+type EffectiveOverride struct {
+	Override types.Override
+
+	// Sources maps every override key to the layer its value comes from.
+	Sources map[string]string
+}
+
+// OverrideLayer is a partial override, holding the JSON encoded value of
+// the keys it sets.
+type OverrideLayer map[string]json.RawMessage
+
+// ResolveOverride merges the override layers of the given application: the
+// defaults, the manifest, the user defaults, the user override of the
+// application, the given per-run override and finally the system policy.
+func ResolveOverride(app types.Application, runOverride OverrideLayer) (effective EffectiveOverride, err error) {
+	effective = EffectiveOverride{
+		Override: types.NewOverride(),
+		Sources:  map[string]string{},
+	}
+
+	typ := reflect.TypeOf(effective.Override)
+	for i := 0; i < typ.NumField(); i++ {
+		effective.Sources[overrideKey(typ.Field(i))] = OverrideSourceDefault
+	}
+
+	// the stored override is decoded as a layer, so that only the keys it
+	// sets are taken from the manifest, default values included
+	manifestLayer := OverrideLayer{}
+	if app.OverrideRaw != "" {
+		err = json.Unmarshal([]byte(app.OverrideRaw), &manifestLayer)
+		if err != nil {
+			return effective, fmt.Errorf("invalid manifest override: %w", err)
+		}
+	}
+	err = effective.apply(manifestLayer, OverrideSourceManifest)
+	if err != nil {
+		return effective, fmt.Errorf("invalid manifest override: %w", err)
+	}
+	effective.Override.Extends = ""
+
+	overridesDir, err := getOverridesDir()
+	if err != nil {
+		return
+	}
+	userLayer, err := LoadOverrideLayer(filepath.Join(overridesDir, overrideUserDefaultsName))
+	if err != nil {
+		return
+	}
+	err = effective.apply(userLayer, OverrideSourceUser)
+	if err != nil {
+		return effective, fmt.Errorf("invalid user defaults override: %w", err)
+	}
+
+	appLayer, err := LoadOverride(app)
+	if err != nil {
+		return
+	}
+	err = effective.apply(appLayer, OverrideSourceApp)
+	if err != nil {
+		return effective, fmt.Errorf("invalid override for %s: %w", app.Origin, err)
+	}
+
+	err = effective.apply(runOverride, OverrideSourceRun)
+	if err != nil {
+		return effective, fmt.Errorf("invalid run override: %w", err)
+	}
+
+	policy, err := LoadOverridePolicy()
+	if err != nil {
+		return
+	}
+	effective.applyPolicy(policy)
+	return effective, nil
+}
+
+// apply sets the keys of the given layer, recording the given source. If
+// the layer extends a profile, the profile keys are set first.
+func (e *EffectiveOverride) apply(layer OverrideLayer, source string) error {
+	if raw, ok := layer[profileExtendsKey]; ok {
+		var profileName string
+		err := json.Unmarshal(raw, &profileName)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", profileExtendsKey, err)
+		}
+		if profileName != "" {
+			profileLayer, err := ResolveProfile(profileName)
+			if err != nil {
+				return err
+			}
+			err = e.apply(profileLayer, OverrideSourceProfile+":"+profileName)
+			if err != nil {
+				return fmt.Errorf("invalid profile %s: %w", profileName, err)
+			}
+		}
+	}
+
+	effVal := reflect.ValueOf(&e.Override).Elem()
+	for key, raw := range layer {
+		index, ok := overrideFieldIndex(key)
+		if !ok {
+			return fmt.Errorf("unknown override key %q", key)
+		}
+
+		field := effVal.Field(index)
+		value := reflect.New(field.Type())
+		err := json.Unmarshal(raw, value.Interface())
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		field.Set(value.Elem())
+		e.Sources[key] = source
+	}
+	return nil
+}
+
+// applyPolicy takes away the permissions denied by the given policy.
+func (e *EffectiveOverride) applyPolicy(policy types.OverridePolicy) {
+	effVal := reflect.ValueOf(&e.Override).Elem()
+
+	for _, key := range policy.Deny {
+		index, ok := overrideFieldIndex(key)
+		if !ok {
+			continue
+		}
+		field := effVal.Field(index)
+		if !field.IsZero() {
+			field.Set(reflect.Zero(field.Type()))
+			e.Sources[key] = OverrideSourcePolicy
+		}
+	}
+
+	for key, patterns := range policy.DenyValues {
+		index, ok := overrideFieldIndex(key)
+		if !ok {
+			continue
+		}
+
+		field := effVal.Field(index)
+		switch field.Kind() {
+		case reflect.Slice:
+			kept := reflect.MakeSlice(field.Type(), 0, field.Len())
+			for j := 0; j < field.Len(); j++ {
+				if !matchesAnyPattern(field.Index(j).String(), patterns) {
+					kept = reflect.Append(kept, field.Index(j))
+				}
+			}
+			if kept.Len() != field.Len() {
+				field.Set(kept)
+				e.Sources[key] = OverrideSourcePolicy
+			}
+		case reflect.Map:
+			kept := reflect.MakeMap(field.Type())
+			for _, mapKey := range field.MapKeys() {
+				if !matchesAnyPattern(mapKey.String(), patterns) {
+					kept.SetMapIndex(mapKey, field.MapIndex(mapKey))
+				}
+			}
+			if kept.Len() != field.Len() {
+				field.Set(kept)
+				e.Sources[key] = OverrideSourcePolicy
+			}
+		}
+	}
+}
+
+// LoadOverrideLayer reads the partial override at the given path, a missing
+// file is an empty layer.
+func LoadOverrideLayer(path string) (layer OverrideLayer, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return OverrideLayer{}, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &layer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return layer, nil
+}
+
+// SaveOverrideLayer writes the given partial override to the given path.
+func SaveOverrideLayer(layer OverrideLayer, path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(layer, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// SetOverrideKey sets the given key in the user override of the given
+// application, leaving the other keys untouched.
+func SetOverrideKey(app types.Application, key string, value json.RawMessage) error {
+	layer, err := LoadOverride(app)
+	if err != nil {
+		return err
+	}
+	layer[key] = value
+	return SaveOverride(app, layer)
+}
+
+// UnsetOverrideKey removes the given key from the user override of the
+// given application, so that its value comes from the lower layers again.
+func UnsetOverrideKey(app types.Application, key string) error {
+	if _, ok := overrideFieldIndex(key); !ok {
+		return fmt.Errorf("unknown override key %q", key)
+	}
+
+	layer, err := LoadOverride(app)
+	if err != nil {
+		return err
+	}
+	delete(layer, key)
+	return SaveOverride(app, layer)
+}
+
+// LoadOverridePolicy reads the system override policy. A missing policy
+// denies nothing, while an invalid one is an error, so that a broken policy
+// does not silently grant everything.
+func LoadOverridePolicy() (policy types.OverridePolicy, err error) {
+	data, err := os.ReadFile(OverridePolicyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+		return policy, err
+	}
+
+	err = json.Unmarshal(data, &policy)
+	if err != nil {
+		return policy, fmt.Errorf("failed to parse %s: %w", OverridePolicyPath, err)
+	}
+	return policy, nil
+}
+
+// ParseOverrideValue converts the given command-line value for the given
+// override key to a layer entry. Booleans accept the strconv.ParseBool
+// values, list items are separated by ':' or given as a JSON list and maps
+// are given as JSON.
+func ParseOverrideValue(key string, value string) (json.RawMessage, error) {
+	index, ok := overrideFieldIndex(key)
+	if !ok {
+		return nil, fmt.Errorf("unknown override key %q", key)
+	}
+
+	var parsed any
+	switch reflect.TypeOf(types.Override{}).Field(index).Type.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean for %s: %q", key, value)
+		}
+		parsed = b
+	case reflect.String:
+		parsed = value
+	case reflect.Slice:
+		if strings.HasPrefix(value, "[") {
+			if !json.Valid([]byte(value)) {
+				return nil, fmt.Errorf("invalid JSON for %s: %q", key, value)
+			}
+			return json.RawMessage(value), nil
+		}
+		items := []string{}
+		if value != "" {
+			items = strings.Split(value, ":")
+		}
+		parsed = items
+	default:
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid JSON for %s: %q", key, value)
+		}
+		return json.RawMessage(value), nil
+	}
+	return json.Marshal(parsed)
+}
+
+// ParseOverrideAssignments parses the given key=value assignments into a
+// layer.
+func ParseOverrideAssignments(assignments []string) (layer OverrideLayer, err error) {
+	layer = OverrideLayer{}
+	for _, assignment := range assignments {
+		key, value, found := strings.Cut(assignment, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid override %q, expected key=value", assignment)
+		}
+		layer[key], err = ParseOverrideValue(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return layer, nil
+}
+
+// getOverridesDir returns the directory holding the user overrides.
+func getOverridesDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "cpak", "overrides"), nil
+}
+
+// getLegacyOverridePath returns the path the user override of the given
+// application was saved to by older cpak versions.
+func getLegacyOverridePath(origin string, version string) (string, error) {
+	overridesDir, err := getOverridesDir()
+	if err != nil {
+		return "", err
+	}
+	cpakLocalDir, err := getCpakLocalName(origin)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(overridesDir, cpakLocalDir, version, "cpak.json"), nil
+}
+
+// overrideFieldIndex returns the index of the override field with the
+// given key.
+func overrideFieldIndex(key string) (int, bool) {
+	typ := reflect.TypeOf(types.Override{})
+	for i := 0; i < typ.NumField(); i++ {
+		if overrideKey(typ.Field(i)) == key {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func matchesAnyPattern(value string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := filepath.Match(pattern, value); value == pattern || (err == nil && matched) {
+			return true
+		}
+	}
+	return false
+}
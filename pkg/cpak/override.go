@@ -174,116 +174,132 @@ func GetOverrideMounts(o types.Override) (mounts, shims []string) {
 	return mounts, shims
 }
 
-// NewOverride returns a new override with default values.
-func NewOverride() types.Override {
-	return types.Override{
-		SocketX11:        true,
-		SocketWayland:    true,
-		SocketPulseAudio: true,
-		SocketSessionBus: true,
-		SocketSystemBus:  true,
-		SocketSshAgent:   false,
-		SocketCups:       true,
-		SocketGpgAgent:   false,
-
-		DeviceDri: true,
-		DeviceKvm: true,
-		DeviceShm: true,
-		DeviceAll: false,
-
-		FsHost:     false,
-		FsHostEtc:  false,
-		FsHostHome: true,
-		FsExtra:    []string{},
-
-		Env:     []string{},
-		Network: true,
-		Process: false,
+// GetOverridePath returns the path to the user override of the given
+// application. Overrides are keyed by CpakId, which is standard base64, so
+// its slashes are replaced to fit in a single path element.
+func GetOverridePath(app types.Application) (string, error) {
+	overridesDir, err := getOverridesDir()
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(overridesDir, strings.ReplaceAll(app.CpakId, "/", "_")+".json"), nil
 }
 
-// LoadOverride loads an override from its name.
-func LoadOverride(origin, version string) (override types.Override, err error) {
-	homeDir, err := os.UserHomeDir()
+// LoadOverride loads the user override of the given application, only
+// holding the keys set by the user. Overrides saved in the legacy
+// origin/version layout are moved to the current path.
+func LoadOverride(app types.Application) (layer OverrideLayer, err error) {
+	path, err := GetOverridePath(app)
 	if err != nil {
 		return
 	}
 
-	cpakLocalDir, err := getCpakLocalName(origin)
-	if err != nil {
-		return
+	if _, statErr := os.Stat(path); os.IsNotExist(statErr) {
+		legacyPath, legacyErr := getLegacyOverridePath(app.Origin, app.Version)
+		if legacyErr == nil {
+			if _, statErr := os.Stat(legacyPath); statErr == nil {
+				logger.Printf("Moving override of %s to %s", app.Origin, path)
+				err = os.MkdirAll(filepath.Dir(path), 0755)
+				if err != nil {
+					return
+				}
+				err = os.Rename(legacyPath, path)
+				if err != nil {
+					return
+				}
+				// the version directory is left empty, it is removed if so
+				_ = os.Remove(filepath.Dir(legacyPath))
+			}
+		}
 	}
 
-	overridePath := filepath.Join(homeDir, ".config/cpak/overrides", cpakLocalDir, version)
-	err = os.MkdirAll(overridePath, 0755)
-	if err != nil {
-		return
-	}
+	return LoadOverrideLayer(path)
+}
 
-	file, err := os.Open(filepath.Join(overridePath, "cpak.json"))
+// GetApplicationOverride returns the override in effect for the given
+// application, merging its override layers. If the layers cannot be read,
+// the defaults are returned, so that a broken user override or policy
+// never grants more than expected.
+func GetApplicationOverride(app types.Application) types.Override {
+	effective, err := ResolveOverride(app, nil)
 	if err != nil {
-		logger.Println(err)
-		return
+		logger.Printf("Warning: %v, using the default override for %s", err, app.Origin)
+		return types.NewOverride()
 	}
+	return effective.Override
+}
 
-	err = json.NewDecoder(file).Decode(&override)
+// SaveOverride validates the given layer and saves it as the user override
+// of the given application.
+func SaveOverride(app types.Application, layer OverrideLayer) error {
+	err := ValidateOverrideLayer(layer)
 	if err != nil {
-		logger.Println(err)
-		return
+		return err
 	}
-
-	return
-}
-
-// Save saves the override in the user's home directory.
-func SaveOverride(override types.Override, name, version string) (err error) {
-	homeDir, err := os.UserHomeDir()
+	// the layer is also applied, so that a missing profile is reported now
+	// rather than when running the application
+	err = (&EffectiveOverride{Override: types.NewOverride(), Sources: map[string]string{}}).apply(layer, OverrideSourceApp)
 	if err != nil {
-		return
+		return err
 	}
 
-	overridesPath := homeDir + "/.config/cpak/overrides"
-	err = os.MkdirAll(overridesPath, 0755)
+	path, err := GetOverridePath(app)
 	if err != nil {
-		return
+		return err
 	}
+	return SaveOverrideLayer(layer, path)
+}
 
-	file, err := os.Create(overridesPath + "/" + name + "/" + version + "/cpak.json")
+// DeleteOverride deletes the user override of the given application, the
+// application goes back to the manifest and user defaults.
+func DeleteOverride(app types.Application) error {
+	path, err := GetOverridePath(app)
 	if err != nil {
-		return
+		return err
 	}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(override)
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
-// Delete deletes the override from the user's home directory.
-func DeleteOverride(o types.Override, name string) (err error) {
-	homeDir, err := os.UserHomeDir()
+// inheritOverride copies the user override of the most recent other
+// installed version of the application, if any, so that overrides survive
+// updates even if they are keyed by CpakId.
+func inheritOverride(store *Store, app types.Application) error {
+	path, err := GetOverridePath(app)
 	if err != nil {
-		return
+		return err
 	}
-
-	overridesPath := homeDir + "/.config/cpak/overrides"
-	err = os.MkdirAll(overridesPath, 0755)
-	if err != nil {
-		return
+	if _, statErr := os.Stat(path); statErr == nil {
+		return nil
 	}
 
-	err = os.Remove(overridesPath + "/" + name + ".json")
+	apps, err := store.GetApplicationsByOrigin(app.Origin, "", "", "", "")
 	if err != nil {
-		return
+		return err
 	}
-
-	return
+	for _, other := range apps {
+		if other.CpakId == app.CpakId {
+			continue
+		}
+		layer, loadErr := LoadOverride(other)
+		if loadErr != nil || len(layer) == 0 {
+			continue
+		}
+		logger.Printf("Keeping the override of %s from version %s", app.Origin, other.Version)
+		return SaveOverrideLayer(layer, path)
+	}
+	return nil
 }
 
 // ParseOverride parses the given string and returns an override.
 func ParseOverride(override string) (o types.Override) {
 	err := json.Unmarshal([]byte(override), &o)
 	if err != nil {
-		return NewOverride()
+		return types.NewOverride()
 	}
 	return
 }
@@ -0,0 +1,320 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// ErrPermissionsDenied is returned when the user refuses the permissions
+// requested by an application.
+var ErrPermissionsDenied = errors.New("permissions denied by the user")
+
+// PermissionConsentFunc is asked to approve the permissions requested by an
+// application being installed, given the ones already granted to its
+// installed versions, if any, or the default ones. It returns the override
+// to install, which can be restricted compared to the requested one, and
+// false to abort the installation.
+type PermissionConsentFunc func(origin string, manifest *types.CpakManifest, granted types.Override, installed bool) (types.Override, bool)
+
+// permissionRisks rates the override keys, keys not listed here are
+// considered common.
+var permissionRisks = map[string]types.PermissionRisk{
+	"deviceAll":           types.PermissionRiskHigh,
+	"fsHost":              types.PermissionRiskHigh,
+	"fsHostEtc":           types.PermissionRiskHigh,
+	"socketSshAgent":      types.PermissionRiskHigh,
+	"socketGpgAgent":      types.PermissionRiskHigh,
+	"process":             types.PermissionRiskHigh,
+	"allowedHostCommands": types.PermissionRiskHigh,
+	"hostCommandPolicies": types.PermissionRiskHigh,
+	"cdiDevices":          types.PermissionRiskHigh,
+
+	"socketSessionBus": types.PermissionRiskMedium,
+	"socketSystemBus":  types.PermissionRiskMedium,
+	"socketBluetooth":  types.PermissionRiskMedium,
+	"deviceKvm":        types.PermissionRiskMedium,
+	"deviceAlsa":       types.PermissionRiskMedium,
+	"deviceVideo":      types.PermissionRiskMedium,
+	"deviceFuse":       types.PermissionRiskMedium,
+	"deviceTun":        types.PermissionRiskMedium,
+	"deviceUsb":        types.PermissionRiskMedium,
+	"deviceRules":      types.PermissionRiskMedium,
+	"fsHostHome":       types.PermissionRiskMedium,
+	"fsExtra":          types.PermissionRiskMedium,
+	"network":          types.PermissionRiskMedium,
+	"asRoot":           types.PermissionRiskMedium,
+	"nestedRun":        types.PermissionRiskMedium,
+}
+
+// DiffPermissions returns the permissions granted by the requested override
+// which are not granted by the base one: enabled booleans, list items and
+// map entries. Permissions removed by the requested override are not
+// reported. The changes are sorted from the riskiest.
+func DiffPermissions(base types.Override, requested types.Override) (changes []types.PermissionChange) {
+	baseVal := reflect.ValueOf(base)
+	reqVal := reflect.ValueOf(requested)
+	typ := reqVal.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := overrideKey(field)
+		change := types.PermissionChange{
+			Key:         key,
+			Description: overrideDescription(field),
+			Risk:        permissionRisks[key],
+		}
+
+		reqField := reqVal.Field(i)
+		baseField := baseVal.Field(i)
+		switch reqField.Kind() {
+		case reflect.Bool:
+			if reqField.Bool() && !baseField.Bool() {
+				changes = append(changes, change)
+			}
+		case reflect.Slice:
+			for j := 0; j < reqField.Len(); j++ {
+				item := reqField.Index(j).String()
+				if !sliceContains(baseField, item) {
+					change.Value = item
+					changes = append(changes, change)
+				}
+			}
+		case reflect.Map:
+			for _, mapKey := range reqField.MapKeys() {
+				baseEntry := baseField.MapIndex(mapKey)
+				if !baseEntry.IsValid() || !reflect.DeepEqual(baseEntry.Interface(), reqField.MapIndex(mapKey).Interface()) {
+					change.Value = mapKey.String()
+					changes = append(changes, change)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Risk > changes[j].Risk
+	})
+	return changes
+}
+
+// RestrictPermissions returns the requested override without the given
+// changes, which fall back to the base override values.
+func RestrictPermissions(base types.Override, requested types.Override, denied []types.PermissionChange) types.Override {
+	restricted := requested
+	resVal := reflect.ValueOf(&restricted).Elem()
+	baseVal := reflect.ValueOf(base)
+	typ := resVal.Type()
+
+	for _, change := range denied {
+		for i := 0; i < typ.NumField(); i++ {
+			if overrideKey(typ.Field(i)) != change.Key {
+				continue
+			}
+
+			field := resVal.Field(i)
+			switch field.Kind() {
+			case reflect.Bool:
+				field.SetBool(baseVal.Field(i).Bool())
+			case reflect.Slice:
+				kept := reflect.MakeSlice(field.Type(), 0, field.Len())
+				for j := 0; j < field.Len(); j++ {
+					if field.Index(j).String() != change.Value {
+						kept = reflect.Append(kept, field.Index(j))
+					}
+				}
+				field.Set(kept)
+			case reflect.Map:
+				// the map is shared with the requested override, so it is
+				// copied before being changed
+				copied := reflect.MakeMap(field.Type())
+				for _, mapKey := range field.MapKeys() {
+					if mapKey.String() != change.Value {
+						copied.SetMapIndex(mapKey, field.MapIndex(mapKey))
+					}
+				}
+				if baseEntry := baseVal.Field(i).MapIndex(reflect.ValueOf(change.Value)); baseEntry.IsValid() {
+					copied.SetMapIndex(reflect.ValueOf(change.Value), baseEntry)
+				}
+				field.Set(copied)
+			}
+		}
+	}
+	return restricted
+}
+
+// GetGrantedPermissions returns the override granted to the most recent
+// installed version of the given origin, used as the base to detect the
+// permission escalations of a new version. The default override is
+// returned when the origin is not installed.
+func (c *Cpak) GetGrantedPermissions(origin string) (override types.Override, installed bool, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+	return getGrantedPermissions(store, origin)
+}
+
+func getGrantedPermissions(store *Store, origin string) (override types.Override, installed bool, err error) {
+	apps, err := store.GetApplicationsByOrigin(origin, "", "", "", "")
+	if err != nil {
+		return
+	}
+	if len(apps) == 0 {
+		return types.NewOverride(), false, nil
+	}
+	return GetApplicationOverride(apps[0]), true, nil
+}
+
+// GetGrantedServices returns the services and D-Bus services of the most
+// recent installed version of the given origin, used as the base to detect
+// the ones added by a new version.
+func (c *Cpak) GetGrantedServices(origin string) (services []types.Service, dbusServices []types.DBusService, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	apps, err := store.GetApplicationsByOrigin(origin, "", "", "", "")
+	if err != nil || len(apps) == 0 {
+		return
+	}
+	return apps[0].ParsedServices, apps[0].ParsedDBusServices, nil
+}
+
+// DiffServicePermissions returns the services of the given manifest which
+// are not granted yet: the D-Bus names it owns and the services started
+// with the session. D-Bus names outside the ones of its desktop entries are
+// dangerous, since the exported activation files take precedence over the
+// host ones, so that the application would be called in their place, as
+// are the services started with the session, without being run by the
+// user.
+func DiffServicePermissions(grantedServices []types.Service, grantedDBusServices []types.DBusService, manifest *types.CpakManifest) (changes []types.PermissionChange) {
+	for _, service := range manifest.Services {
+		granted := slices.ContainsFunc(grantedServices, func(s types.Service) bool {
+			return s.Name == service.Name && (s.Autostart || !service.Autostart)
+		})
+		if granted {
+			continue
+		}
+		change := types.PermissionChange{
+			Key:         "services",
+			Value:       service.Name,
+			Description: "Run a background service",
+			Risk:        types.PermissionRiskMedium,
+		}
+		if service.Autostart {
+			change.Description = "Start a background service with the session"
+			change.Risk = types.PermissionRiskHigh
+		}
+		changes = append(changes, change)
+	}
+
+	for _, dbusService := range manifest.DBusServices {
+		granted := slices.ContainsFunc(grantedDBusServices, func(s types.DBusService) bool {
+			return s.Name == dbusService.Name
+		})
+		if granted {
+			continue
+		}
+		change := types.PermissionChange{
+			Key:         "dbusServices",
+			Value:       dbusService.Name,
+			Description: "Own a session D-Bus name",
+			Risk:        types.PermissionRiskMedium,
+		}
+		if !isOwnBusName(manifest, dbusService.Name) {
+			change.Description = "Own a session D-Bus name of another application"
+			change.Risk = types.PermissionRiskHigh
+		}
+		changes = append(changes, change)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Risk > changes[j].Risk
+	})
+	return changes
+}
+
+// reservedBusNamespaces are the D-Bus namespaces of the desktop services,
+// e.g. the secrets, notifications and portals ones, which are never
+// considered owned by an application.
+var reservedBusNamespaces = []string{"org.freedesktop.", "org.gnome.", "org.kde.", "org.gtk.", "org.a11y."}
+
+// isOwnBusName checks if the given D-Bus name belongs to the application of
+// the given manifest: it is the id of one of its desktop entries, or a name
+// below it, outside the reserved namespaces.
+func isOwnBusName(manifest *types.CpakManifest, name string) bool {
+	for _, namespace := range reservedBusNamespaces {
+		if strings.HasPrefix(name, namespace) {
+			return false
+		}
+	}
+	for _, desktopEntry := range manifest.DesktopEntries {
+		id := strings.TrimSuffix(filepath.Base(desktopEntry), ".desktop")
+		if strings.Count(id, ".") >= 2 && (name == id || strings.HasPrefix(name, id+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictServices removes the given changes from the manifest: services
+// denied to start with the session are only started on demand, denied
+// D-Bus names are not exported.
+func RestrictServices(manifest *types.CpakManifest, denied []types.PermissionChange) {
+	for _, change := range denied {
+		switch change.Key {
+		case "services":
+			for i := range manifest.Services {
+				if manifest.Services[i].Name == change.Value {
+					manifest.Services[i].Autostart = false
+				}
+			}
+		case "dbusServices":
+			manifest.DBusServices = slices.DeleteFunc(manifest.DBusServices, func(s types.DBusService) bool {
+				return s.Name == change.Value
+			})
+		}
+	}
+}
+
+// overrideKey returns the manifest key of the given override field.
+func overrideKey(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if key == "" {
+		return field.Name
+	}
+	return key
+}
+
+// overrideDescription returns the description of the given override field,
+// taken from its JSON schema.
+func overrideDescription(field reflect.StructField) string {
+	for _, part := range strings.Split(field.Tag.Get("jsonschema"), ",") {
+		if description, ok := strings.CutPrefix(part, "description="); ok {
+			return description
+		}
+	}
+	return field.Name
+}
+
+func sliceContains(slice reflect.Value, item string) bool {
+	for i := 0; i < slice.Len(); i++ {
+		if slice.Index(i).String() == item {
+			return true
+		}
+	}
+	return false
+}
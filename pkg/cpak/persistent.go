@@ -0,0 +1,234 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// Persistent containers keep their upper layer in the store, outside of the
+// container state directory which is removed on every cleanup. The upper
+// layer belongs to the container, which keeps its id when started again,
+// so each persistent instance of an application has its own changes.
+
+// GetPersistentDir returns the path to the directory holding the upper and
+// work directories of the given persistent container.
+//
+// Note: this does not check if the directory exists, it just returns it.
+func (c *Cpak) GetPersistentDir(container types.Container) string {
+	return c.GetInStoreDir("persistent", container.CpakId)
+}
+
+// getPersistentDirMkdir returns the persistent directory of the given
+// container, creating its upper and work directories if needed.
+func (c *Cpak) getPersistentDirMkdir(container types.Container) (path string, err error) {
+	path = c.GetPersistentDir(container)
+	for _, sub := range []string{"up", "work"} {
+		err = os.MkdirAll(filepath.Join(path, sub), 0755)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetPersistentContainer returns the persistent container with the given
+// id, or the most recent persistent container of the application with the
+// given origin and version, along with its application.
+func (c *Cpak) GetPersistentContainer(ref string, version string, branch string, commit string, release string) (app types.Application, container types.Container, err error) {
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	container, err = store.GetContainerByCpakId(ref)
+	if err == nil {
+		if !container.Persistent {
+			return app, container, fmt.Errorf("container %s is not persistent", ref)
+		}
+		app, err = store.GetApplicationByCpakId(container.ApplicationCpakId)
+		return
+	}
+
+	app, err = store.GetApplicationByOrigin(ref, version, branch, commit, release)
+	if err != nil || app.CpakId == "" {
+		return app, container, fmt.Errorf("no application or container found for %s", ref)
+	}
+	containers, err := store.GetApplicationContainers(app)
+	if err != nil {
+		return
+	}
+	// the containers are listed from the most recently created one
+	for _, candidate := range containers {
+		if candidate.Persistent {
+			return app, candidate, nil
+		}
+	}
+	return app, container, fmt.Errorf("application %s has no persistent containers", app.Origin)
+}
+
+// Diff returns the changes made by the given persistent container of an
+// application, compared to the application layers. Overlay whiteouts, both
+// as character devices and as .wh. files, are reported as deletions.
+func (c *Cpak) Diff(app types.Application, container types.Container) (changes []types.FileChange, err error) {
+	upperDir := filepath.Join(c.GetPersistentDir(container), "up")
+	if _, err = os.Stat(upperDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("container %s has no persistent changes", container.CpakId)
+		}
+		return
+	}
+
+	err = filepath.WalkDir(upperDir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(upperDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		name := d.Name()
+		inContainer := "/" + rel
+		switch {
+		case name == ".wh..wh..opq":
+			return nil
+		case tools.WhiteoutTarget(name) != "":
+			changes = append(changes, types.FileChange{
+				Kind: types.FileDeleted,
+				Path: filepath.Join(filepath.Dir(inContainer), tools.WhiteoutTarget(name)),
+			})
+			return nil
+		case tools.IsWhiteout(d):
+			changes = append(changes, types.FileChange{Kind: types.FileDeleted, Path: inContainer})
+			return nil
+		}
+
+		if !c.existsInLayers(app, rel) {
+			changes = append(changes, types.FileChange{Kind: types.FileAdded, Path: inContainer})
+		} else if !d.IsDir() {
+			changes = append(changes, types.FileChange{Kind: types.FileChanged, Path: inContainer})
+		}
+		return nil
+	})
+	return
+}
+
+// Commit turns the changes of the given persistent container of an
+// application into a new content-addressed layer, and registers a derived
+// local application made of that layer on top of the original ones. The
+// whiteouts are kept in the layer, so the deleted files stay deleted. The
+// persistent changes are then reset, since they are now part of the derived
+// application.
+func (c *Cpak) Commit(app types.Application, container types.Container) (derived types.Application, err error) {
+	originItems := strings.Split(app.Origin, "/")
+	if len(originItems) < 3 {
+		return derived, fmt.Errorf("invalid origin: %s", app.Origin)
+	}
+
+	persistentDir := c.GetPersistentDir(container)
+	upperDir := filepath.Join(persistentDir, "up")
+	entries, err := os.ReadDir(upperDir)
+	if err != nil || len(entries) == 0 {
+		return derived, fmt.Errorf("container %s has no persistent changes to commit", container.CpakId)
+	}
+
+	// the upper directory must not change while being committed
+	err = c.StopContainer(app)
+	if err != nil {
+		return derived, fmt.Errorf("failed to stop containers for %s: %w", app.Origin, err)
+	}
+
+	digest, err := tools.HashTree(upperDir)
+	if err != nil {
+		return derived, fmt.Errorf("failed to hash persistent changes: %w", err)
+	}
+
+	layerDir := c.GetInStoreLayersDir(digest)
+	if _, statErr := os.Stat(layerDir); os.IsNotExist(statErr) {
+		err = os.Rename(upperDir, layerDir)
+		if err != nil {
+			return derived, fmt.Errorf("failed to move persistent changes to the store: %w", err)
+		}
+	} else {
+		logger.Printf("Layer %s already present in the store, skipping..", digest)
+		err = os.RemoveAll(upperDir)
+		if err != nil {
+			return derived, err
+		}
+	}
+	err = os.MkdirAll(upperDir, 0755)
+	if err != nil {
+		return
+	}
+
+	derivedOrigin := fmt.Sprintf("local/%s/%s-%s", originItems[1], originItems[2], digest[:12])
+	imageIdBase := app.Name + ":branch:main:" + derivedOrigin
+
+	derivedOverride := app.ParsedOverride
+	derivedOverride.Persistent = false
+
+	derived = types.Application{
+		CpakId:           base64.StdEncoding.EncodeToString([]byte(imageIdBase)),
+		Name:             app.Name,
+		Version:          "main",
+		Origin:           derivedOrigin,
+		Branch:           "main",
+		InstallTimestamp: time.Now(),
+		Config:           app.Config,
+		DerivedFrom:      app.CpakId,
+		ParsedBinaries:   app.ParsedBinaries,
+		ParsedLayers:     append([]string{digest}, app.ParsedLayers...),
+		ParsedOverride:   derivedOverride,
+		ParsedInfo:       app.ParsedInfo,
+	}
+
+	store, err := NewStore(c.Options.StorePath)
+	if err != nil {
+		return
+	}
+	defer store.Close()
+
+	err = store.NewApplication(derived)
+	if err != nil {
+		return derived, fmt.Errorf("failed to register derived application: %w", err)
+	}
+
+	// desktop entries are not exported, they would duplicate the original
+	// application ones in the desktop menus
+	for _, binary := range derived.ParsedBinaries {
+		err = c.exportBinary(store, derived, binary)
+		if err != nil {
+			return
+		}
+	}
+
+	logger.Printf("Committed layer %s as %s", digest, derivedOrigin)
+	return derived, nil
+}
+
+// existsInLayers checks if the given path, relative to the root, exists in
+// any of the given application layers.
+func (c *Cpak) existsInLayers(app types.Application, rel string) bool {
+	for _, layer := range app.ParsedLayers {
+		_, err := os.Lstat(c.GetInStoreLayersDir(layer, rel))
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
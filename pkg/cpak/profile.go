@@ -0,0 +1,316 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// The places profiles come from, from the lowest to the highest priority:
+// a profile defined in more places is taken from the highest one.
+const (
+	ProfileSourceBuiltin = "builtin"
+	ProfileSourceSystem  = "system"
+	ProfileSourceUser    = "user"
+)
+
+// SystemProfilesPath is the directory holding the profiles shared by all
+// the users, e.g. defined by the administrator for internal packages.
+const SystemProfilesPath = "/etc/cpak/profiles"
+
+// profileExtendsKey is the key profiles and overrides use to reference the
+// profile they are based on.
+const profileExtendsKey = "extends"
+
+// profileNameRegexp matches the valid profile names.
+var profileNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]*$`)
+
+// builtinProfiles are the profiles shipped with cpak, they are partial
+// overrides applied on top of the defaults.
+var builtinProfiles = map[string]string{
+	"cli-minimal": `{
+		"socketX11": false,
+		"socketWayland": false,
+		"socketPulseAudio": false,
+		"socketSessionBus": false,
+		"socketSystemBus": false,
+		"socketSshAgent": false,
+		"socketCups": false,
+		"socketGpgAgent": false,
+		"socketAtSpiBus": false,
+		"socketBluetooth": false,
+		"deviceDri": false,
+		"deviceKvm": false,
+		"deviceShm": false,
+		"deviceAlsa": false,
+		"deviceVideo": false,
+		"deviceFuse": false,
+		"deviceTun": false,
+		"deviceUsb": false,
+		"deviceAll": false,
+		"notification": false,
+		"network": false
+	}`,
+	"gui-default": `{
+		"socketX11": true,
+		"socketWayland": true,
+		"socketPulseAudio": true,
+		"socketSessionBus": true,
+		"socketAtSpiBus": true,
+		"deviceDri": true,
+		"deviceShm": true,
+		"notification": true
+	}`,
+	"gamer": `{
+		"extends": "gui-default",
+		"socketBluetooth": true,
+		"deviceAlsa": true,
+		"deviceUsb": true,
+		"network": true
+	}`,
+	"dev": `{
+		"extends": "gui-default",
+		"socketSshAgent": true,
+		"socketGpgAgent": true,
+		"network": true
+	}`,
+}
+
+// Profile is a named partial override, which can be referenced by
+// manifests, overrides and other profiles through the "extends" key.
+type Profile struct {
+	Name   string        `json:"name"`
+	Source string        `json:"source"`
+	Layer  OverrideLayer `json:"layer"`
+}
+
+// Extends returns the name of the profile the profile is based on, if any.
+func (p Profile) Extends() string {
+	var extends string
+	if raw, ok := p.Layer[profileExtendsKey]; ok {
+		_ = json.Unmarshal(raw, &extends)
+	}
+	return extends
+}
+
+// GetUserProfilesPath returns the directory holding the profiles of the
+// current user.
+func GetUserProfilesPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".config", "cpak", "profiles"), nil
+}
+
+// GetProfiles returns all the available profiles, sorted by name.
+func GetProfiles() (profiles []Profile, err error) {
+	byName := map[string]Profile{}
+	for name := range builtinProfiles {
+		profile, loadErr := loadBuiltinProfile(name)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		byName[name] = profile
+	}
+
+	userPath, err := GetUserProfilesPath()
+	if err != nil {
+		return
+	}
+	for _, dir := range []struct{ path, source string }{
+		{SystemProfilesPath, ProfileSourceSystem},
+		{userPath, ProfileSourceUser},
+	} {
+		entries, readErr := os.ReadDir(dir.path)
+		if readErr != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, isJson := strings.CutSuffix(entry.Name(), ".json")
+			if !isJson || !profileNameRegexp.MatchString(name) {
+				continue
+			}
+			layer, loadErr := LoadOverrideLayer(filepath.Join(dir.path, entry.Name()))
+			if loadErr != nil {
+				return nil, loadErr
+			}
+			byName[name] = Profile{Name: name, Source: dir.source, Layer: layer}
+		}
+	}
+
+	for _, profile := range byName {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles, nil
+}
+
+// GetProfile returns the profile with the given name, user profiles take
+// precedence over the system ones, which take precedence over the builtin
+// ones.
+func GetProfile(name string) (profile Profile, err error) {
+	if !profileNameRegexp.MatchString(name) {
+		return profile, fmt.Errorf("invalid profile name %q", name)
+	}
+
+	userPath, err := GetUserProfilesPath()
+	if err != nil {
+		return
+	}
+	for _, dir := range []struct{ path, source string }{
+		{userPath, ProfileSourceUser},
+		{SystemProfilesPath, ProfileSourceSystem},
+	} {
+		path := filepath.Join(dir.path, name+".json")
+		if _, statErr := os.Stat(path); statErr != nil {
+			continue
+		}
+		layer, loadErr := LoadOverrideLayer(path)
+		if loadErr != nil {
+			return profile, loadErr
+		}
+		return Profile{Name: name, Source: dir.source, Layer: layer}, nil
+	}
+
+	if _, ok := builtinProfiles[name]; ok {
+		return loadBuiltinProfile(name)
+	}
+	return profile, fmt.Errorf("profile %q not found", name)
+}
+
+// ResolveProfile returns the given profile as a single layer, with the keys
+// of the profiles it extends merged in and without the "extends" key.
+func ResolveProfile(name string) (OverrideLayer, error) {
+	return resolveProfile(name, []string{})
+}
+
+func resolveProfile(name string, visited []string) (resolved OverrideLayer, err error) {
+	for _, v := range visited {
+		if v == name {
+			return nil, fmt.Errorf("profile %q extends itself through %s", name, strings.Join(visited, " -> "))
+		}
+	}
+
+	profile, err := GetProfile(name)
+	if err != nil {
+		return
+	}
+
+	resolved = OverrideLayer{}
+	if parent := profile.Extends(); parent != "" {
+		resolved, err = resolveProfile(parent, append(visited, name))
+		if err != nil {
+			return
+		}
+	}
+	for key, value := range profile.Layer {
+		if key != profileExtendsKey {
+			resolved[key] = value
+		}
+	}
+	return resolved, nil
+}
+
+// SaveProfile validates the given profile and saves it in the directory of
+// the given source, either ProfileSourceUser or ProfileSourceSystem.
+func SaveProfile(profile Profile) error {
+	if !profileNameRegexp.MatchString(profile.Name) {
+		return fmt.Errorf("invalid profile name %q", profile.Name)
+	}
+	err := ValidateOverrideLayer(profile.Layer)
+	if err != nil {
+		return err
+	}
+	if parent := profile.Extends(); parent != "" {
+		_, err = resolveProfile(parent, []string{profile.Name})
+		if err != nil {
+			return err
+		}
+	}
+
+	var dir string
+	switch profile.Source {
+	case ProfileSourceSystem:
+		dir = SystemProfilesPath
+	case ProfileSourceUser, "":
+		dir, err = GetUserProfilesPath()
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("profiles cannot be saved as %s", profile.Source)
+	}
+	return SaveOverrideLayer(profile.Layer, filepath.Join(dir, profile.Name+".json"))
+}
+
+// ExpandOverride returns the override made of the keys set by the given
+// layer on top of the defaults, with the profile it extends applied first.
+// The returned override does not extend any profile anymore, so that it can
+// be compared and restricted as is.
+func ExpandOverride(layer OverrideLayer) (types.Override, error) {
+	effective := EffectiveOverride{
+		Override: types.NewOverride(),
+		Sources:  map[string]string{},
+	}
+	err := effective.apply(layer, OverrideSourceManifest)
+	if err != nil {
+		return effective.Override, err
+	}
+	effective.Override.Extends = ""
+	return effective.Override, nil
+}
+
+// ExpandManifestOverride expands the override of the given manifest, only
+// taking the keys it sets from the manifest. The expanded override is then
+// used as a whole, so expanding it again has no effect.
+func ExpandManifestOverride(manifest *types.CpakManifest) error {
+	layer := OverrideLayer(manifest.RawOverride)
+	if layer == nil {
+		var err error
+		layer, err = overrideToLayer(manifest.Override)
+		if err != nil {
+			return err
+		}
+	}
+
+	override, err := ExpandOverride(layer)
+	if err != nil {
+		return err
+	}
+	manifest.Override = override
+	manifest.RawOverride = nil
+	return nil
+}
+
+// overrideToLayer returns a layer setting every key of the given override.
+func overrideToLayer(o types.Override) (layer OverrideLayer, err error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &layer)
+	return
+}
+
+func loadBuiltinProfile(name string) (profile Profile, err error) {
+	layer := OverrideLayer{}
+	err = json.Unmarshal([]byte(builtinProfiles[name]), &layer)
+	if err != nil {
+		return profile, fmt.Errorf("invalid builtin profile %s: %w", name, err)
+	}
+	return Profile{Name: name, Source: ProfileSourceBuiltin, Layer: layer}, nil
+}
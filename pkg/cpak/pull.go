@@ -10,10 +10,8 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"syscall"
 
 	"github.com/google/go-containerregistry/pkg/crane"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -216,33 +214,12 @@ func (c *Cpak) downloadLayer(image v1.Image, layer v1.Layer, digest string) (err
 		return
 	}
 
-	cmds := []string{}
-	if isVerbose {
-		cmds = append(cmds, "--debug")
-	}
-	cmds = append(cmds, []string{
-		"--cgroupns=true",
-		"--utsns=true",
-		"--ipcns=true",
-		"--copy-up=/etc",
-		"--propagation=rslave",
-		cpakBinary,
-		"dedup",
-	}...)
+	cmds := []string{"dedup"}
 	if isVerbose {
 		cmds = append(cmds, "--verbose")
 	}
 	cmds = append(cmds, "--path", layerInStoreDir)
-	cmd := exec.Command(c.Options.RotlesskitBinPath, cmds...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Foreground: false,
-		Setsid:     true,
-	}
-
-	err = cmd.Run()
+	err = c.Runtime.Spawn(cpakBinary, cmds, nil, true)
 	if err != nil {
 		return
 	}
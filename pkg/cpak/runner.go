@@ -6,22 +6,29 @@
 package cpak
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/nested"
+	"github.com/mirkobrombin/cpak/pkg/tools"
 	"github.com/mirkobrombin/cpak/pkg/types"
+	"golang.org/x/term"
 )
 
 var isVerbose bool
@@ -35,7 +42,12 @@ var isVerbose bool
 // available in required applications, so it is recommended to use them only
 // for debugging purposes and handle the error case when the binary is not
 // available, e.g. in shell scripts.
-func (c *Cpak) Run(origin string, version string, branch string, commit string, release string, binary string, verbose bool, extraArgs ...string) (err error) {
+//
+// The arguments starting from hostPathsFrom, if not negative, which are host
+// paths or file URIs are translated to the paths the container sees them
+// at, see TranslateHostPaths. This is used by the exported desktop entries,
+// whose field codes expand to host paths.
+func (c *Cpak) Run(origin string, version string, branch string, commit string, release string, runOverride OverrideLayer, binary string, verbose bool, hostPathsFrom int, extraArgs ...string) (err error) {
 	isVerbose = verbose
 	var startTime time.Time
 	if verbose {
@@ -64,21 +76,33 @@ func (c *Cpak) Run(origin string, version string, branch string, commit string,
 		return fmt.Errorf("no application found for origin %s and version/criteria %s: %w", origin, version, err)
 	}
 
-	// Get the override for the given application, we try to load the user
-	// override first, if it does not exist, we use the application's one
-	var appOverride types.Override
-	userOverride, errLoad := LoadOverride(app.Origin, app.Version)
-	if errLoad == nil && !reflect.DeepEqual(userOverride, types.NewOverride()) { // Consider user override if loaded and not default
-		appOverride = userOverride
-	} else {
-		appOverride = app.ParsedOverride
+	effective, err := ResolveOverride(app, runOverride)
+	if err != nil {
+		return
+	}
+	// processes executed in the container also follow the effective
+	// override, e.g. to run as root
+	app.ParsedOverride = effective.Override
+
+	hostPaths := []string{}
+	if hostPathsFrom >= 0 && hostPathsFrom < len(extraArgs) {
+		var translated []string
+		translated, hostPaths = TranslateHostPaths(effective.Override, extraArgs[hostPathsFrom:])
+		extraArgs = append(extraArgs[:hostPathsFrom:hostPathsFrom], translated...)
 	}
 
-	container, err := c.PrepareContainer(app, appOverride)
+	container, err := c.PrepareContainer(app, effective.Override)
 	if err != nil {
 		return
 	}
 
+	if len(hostPaths) > 0 {
+		err = c.attachHostPaths(container, hostPaths)
+		if err != nil {
+			return fmt.Errorf("failed to share the host files with the container: %w", err)
+		}
+	}
+
 	if verbose {
 		elapsed := time.Since(startTime)
 		logger.Printf("Container creation took %s", elapsed)
@@ -123,36 +147,155 @@ func (c *Cpak) Run(origin string, version string, branch string, commit string,
 	return
 }
 
-// prepareSocketListener prepares the socket listener to be used by containers to spawn nested containers.
-func (c *Cpak) prepareSocketListener() (err error) {
-	// Run cpak start-service without attaching to the current process
+// TranslateHostPaths returns the given arguments with the host paths and
+// the file URIs translated to the paths the container sees them at: files
+// bound in the container, e.g. in the home, keep their path, while the
+// others are bound on their own under HostRootInContainer, they are
+// returned as hostPaths. Arguments which are not existing host paths are
+// left untouched.
+func TranslateHostPaths(o types.Override, args []string) (translated []string, hostPaths []string) {
+	mounts, _ := GetOverrideMounts(o)
+	translated = make([]string, len(args))
+	for i, arg := range args {
+		translated[i] = arg
+		var path string
+		var uri *url.URL
+		if strings.HasPrefix(arg, "file://") {
+			var err error
+			uri, err = url.Parse(arg)
+			if err != nil || (uri.Host != "" && uri.Host != "localhost") {
+				continue
+			}
+			path = uri.Path
+		} else if filepath.IsAbs(arg) {
+			path = arg
+		} else {
+			continue
+		}
+
+		path = filepath.Clean(path)
+		if !isHostPathHidden(mounts, path) {
+			continue
+		}
+		if !slices.Contains(hostPaths, path) {
+			hostPaths = append(hostPaths, path)
+		}
+		if uri != nil {
+			uri.Path = filepath.Join(HostRootInContainer, path)
+			translated[i] = uri.String()
+		} else {
+			translated[i] = filepath.Join(HostRootInContainer, path)
+		}
+	}
+	return
+}
+
+// isHostPathHidden reports whether the given path exists on the host and
+// is not bound in the container by the given mounts.
+func isHostPathHidden(mounts []string, path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	for _, mount := range mounts {
+		mount = strings.TrimSuffix(mount, "/")
+		if path == mount || strings.HasPrefix(path, mount+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// attachHostPaths binds the given host files in the given running container
+// under HostRootInContainer. They stay bound until the container stops,
+// since the application may hand them to an already running instance.
+func (c *Cpak) attachHostPaths(container types.Container, paths []string) error {
+	pidFile, err := readContainerPidFile(container)
+	if err != nil {
+		return err
+	}
 	cpakBinary, err := getCpakBinary()
 	if err != nil {
-		return
+		return err
+	}
+
+	command := append([]string{cpakBinary, "host-path-attach", "--init-pid", strconv.Itoa(pidFile.Pid), "--"}, paths...)
+	out, err := nsenterUserCommand(c.Options.NsenterBinPath, pidFile.Pid, command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
 	}
+	return nil
+}
 
-	cmd := exec.Command(cpakBinary, "start-service")
-	err = cmd.Start()
+// prepareSocketListener ensures the service used by containers to spawn
+// nested containers is running, starting it if needed, and waits for it to
+// accept connections.
+func (c *Cpak) prepareSocketListener() (err error) {
+	serviceDir, err := GetServiceDir()
 	if err != nil {
 		return
 	}
-	err = cmd.Process.Release()
+	socketPath, err := GetServiceSocketPath()
 	if err != nil {
 		return
 	}
-	return
+
+	if !isServiceRunning(serviceDir) {
+		// Run cpak start-service without attaching to the current process
+		var cpakBinary string
+		cpakBinary, err = getCpakBinary()
+		if err != nil {
+			return
+		}
+
+		cmd := exec.Command(cpakBinary, "start-service")
+		err = cmd.Start()
+		if err != nil {
+			return
+		}
+		err = cmd.Process.Release()
+		if err != nil {
+			return
+		}
+	}
+
+	return waitForService(socketPath, serviceReadyTimeout)
 }
 
+// StartSocketListener runs the nested-run service of the current user. Only
+// one instance can run at a time, ErrServiceRunning is returned otherwise.
 func (c *Cpak) StartSocketListener() (err error) {
 	logger.Println("Preparing socket listener...")
-	_ = os.Remove("/tmp/cpak.sock")
+	serviceDir, err := GetServiceDir()
+	if err != nil {
+		return
+	}
+
+	lock, err := lockService(serviceDir)
+	if err != nil {
+		return
+	}
+	defer lock.Close()
+
+	// holding the lock, any existing socket is a leftover of a previous
+	// instance which did not exit cleanly
+	socketPath, err := GetServiceSocketPath()
+	if err != nil {
+		return
+	}
+	_ = os.Remove(socketPath)
 
-	// the socket listens on /tmp/cpak.sock
-	listener, err := net.Listen("unix", "/tmp/cpak.sock")
+	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		return err
 	}
 	defer listener.Close()
+
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		return err
+	}
+
+	go c.watchDevices()
 	logger.Printf("Waiting for connections on %s...", listener.Addr())
 
 	for {
@@ -163,17 +306,25 @@ func (c *Cpak) StartSocketListener() (err error) {
 			continue
 		}
 
-		go c.handleSocketConnection(conn)
+		cred, credErr := checkPeerCredentials(conn)
+		if credErr != nil {
+			logger.Printf("Rejecting connection: %v", credErr)
+			conn.Close()
+			continue
+		}
+
+		go c.handleSocketConnection(conn, int(cred.Pid))
 	}
 }
 
-func (c *Cpak) handleSocketConnection(conn net.Conn) {
-	defer conn.Close()
+func (c *Cpak) handleSocketConnection(conn net.Conn, callerPid int) {
+	nc := nested.NewConn(conn)
+	defer nc.Close()
 
-	buffer := make([]byte, 2048)
-	var n int
-	var err error
-	n, err = conn.Read(buffer)
+	// every connection starts with the handshake, followed by the request
+	// which is used by the server to check if the cpak which is running,
+	// has the ability to run the specified nested cpak
+	req, err := nc.ServerHandshake()
 	if err != nil {
 		if err != io.EOF {
 			logger.Printf("Error reading request: %v", err)
@@ -181,180 +332,310 @@ func (c *Cpak) handleSocketConnection(conn net.Conn) {
 		return
 	}
 
-	// the cpak container sends different requests to the socket, those
-	// can be both JSON encoded or plain text but the first one must always
-	// be a JSON encoded RequestParams struct which is used by the server
-	// to check if the cpak which is running, has the ability to run the
-	// specified nested cpak
-	var params types.RequestParams
-	err = json.Unmarshal(buffer[:n], &params)
-	if err != nil {
-		logger.Printf("Error parsing JSON request: %v", err)
-		sendErrorResponse(conn, fmt.Errorf("invalid JSON request"))
-		return
+	logger.Printf("Received request from the container: %+v", req.Params)
+
+	switch req.Params.Action {
+	case "run":
+		decision := c.authorizeNestedRun(callerPid, req.Params)
+		if !decision.Allowed {
+			nc.Reject(fmt.Errorf("nested run of %s denied: %s", req.Params.Origin, decision.Reason))
+			return
+		}
+		c.serveNestedRun(nc, req)
+	default:
+		logger.Printf("Unknown request: %s", req.Params.Action)
+		nc.Reject(fmt.Errorf("unknown request: %s", req.Params.Action))
 	}
+}
 
-	logger.Printf("Received request from the container: %+v", params)
+// serveNestedRun runs the requested cpak on the host, forwarding its
+// standard streams, terminal resizes and signals over the connection until
+// it exits.
+func (c *Cpak) serveNestedRun(nc *nested.Conn, req nested.Request) {
+	logger.Printf("Running another cpak container in nested mode...")
+	params := req.Params
 
-	switch params.Action {
-	case "run":
-		logger.Printf("Running another cpak container in nested mode...")
+	args := []string{
+		"run",
+		params.Origin,
+	}
+	if params.Version != "" {
+		args = append(args, "--version", params.Version)
+	}
+	if params.Branch != "" {
+		args = append(args, "--branch", params.Branch)
+	}
+	if params.Commit != "" {
+		args = append(args, "--commit", params.Commit)
+	}
+	if params.Release != "" {
+		args = append(args, "--release", params.Release)
+	}
+	args = append(args, "--", params.Binary)
+	args = append(args, params.ExtraArgs...)
+
+	cpakBinary, err := getCpakBinary()
+	if err != nil {
+		nc.Reject(err)
+		return
+	}
+	serveNestedCommand(nc, exec.Command(cpakBinary, args...), req)
+}
 
-		// we need to create a PTY to run the nested cpak and allow the
-		// bidirectional communication between the host and the container
-		var ptyMaster, ptySlave *os.File
-		ptyMaster, ptySlave, err = pty.Open()
+// serveNestedCommand runs the given command for a nested run request,
+// forwarding its standard streams, terminal resizes and signals over the
+// connection until it exits.
+func serveNestedCommand(nc *nested.Conn, cmd *exec.Cmd, req nested.Request) {
+	var err error
+	var stdin io.WriteCloser
+	var ptyMaster *os.File
+	outputs := []io.Reader{}
+	outputTypes := []nested.FrameType{}
+	if req.Tty {
+		// a PTY is created when the client runs in a terminal, so that TUI
+		// applications behave as if they were running in it; pty.Start
+		// makes the command a session leader, and so its process group one
+		size := &pty.Winsize{Rows: 24, Cols: 80}
+		if req.Size != nil {
+			size = &pty.Winsize{Rows: req.Size.Rows, Cols: req.Size.Cols}
+		}
+		ptyMaster, err = pty.StartWithSize(cmd, size)
 		if err != nil {
-			logger.Println("Error creating PTY:", err)
-			sendErrorResponse(conn, fmt.Errorf("error creating PTY"))
+			logger.Println("Error starting nested cpak in a PTY:", err)
+			nc.Reject(fmt.Errorf("error creating PTY"))
 			return
 		}
-		defer ptyMaster.Close() // Ensure master is closed
-
-		done := make(chan struct{})
-		go func() {
-			defer close(done)
-			io.Copy(ptyMaster, conn)
-		}()
-		go func() {
-			io.Copy(conn, ptyMaster)
-		}()
-
-		args := []string{
-			"run",
-			params.Origin,
+		defer ptyMaster.Close()
+		stdin = ptyMaster
+		outputs = append(outputs, ptyMaster)
+		outputTypes = append(outputTypes, nested.FrameStdout)
+	} else {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setpgid: true,
 		}
-		if params.Version != "" {
-			args = append(args, "--version", params.Version)
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			nc.Reject(err)
+			return
 		}
-		if params.Branch != "" {
-			args = append(args, "--branch", params.Branch)
+		// the output pipes are not the ones of cmd.StdoutPipe, which are
+		// closed by cmd.Wait as soon as the command exits, possibly
+		// before its whole output has been read
+		stdout, stdoutWriter, err := os.Pipe()
+		if err != nil {
+			nc.Reject(err)
+			return
 		}
-		if params.Commit != "" {
-			args = append(args, "--commit", params.Commit)
+		defer stdout.Close()
+		stderr, stderrWriter, err := os.Pipe()
+		if err != nil {
+			stdoutWriter.Close()
+			nc.Reject(err)
+			return
 		}
-		if params.Release != "" {
-			args = append(args, "--release", params.Release)
+		defer stderr.Close()
+		cmd.Stdout = stdoutWriter
+		cmd.Stderr = stderrWriter
+		outputs = append(outputs, stdout, stderr)
+		outputTypes = append(outputTypes, nested.FrameStdout, nested.FrameStderr)
+
+		err = cmd.Start()
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		if err != nil {
+			logger.Println("Error starting nested cpak:", err)
+			nc.Reject(fmt.Errorf("error starting nested cpak"))
+			return
 		}
-		args = append(args, "--", params.Binary)
-		args = append(args, params.ExtraArgs...)
+	}
 
-		cpakBinary, _ := getCpakBinary()
-		cmd := exec.Command(cpakBinary, args...)
-		cmd.Stdin = ptySlave
-		cmd.Stdout = ptySlave
-		cmd.Stderr = ptySlave
+	err = nc.Accept()
+	if err != nil {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+		return
+	}
 
-		// set the process group so that the termination signal is
-		// forwarded to the shell process
-		cmd.SysProcAttr = &syscall.SysProcAttr{
-			Setpgid: true,
-		}
+	var outputWg sync.WaitGroup
+	for i, output := range outputs {
+		outputWg.Add(1)
+		go func(r io.Reader, t nested.FrameType) {
+			defer outputWg.Done()
+			io.Copy(nc.Writer(t), r)
+		}(output, outputTypes[i])
+	}
+	outputDone := make(chan struct{})
+	go func() {
+		outputWg.Wait()
+		close(outputDone)
+	}()
 
-		// start the shell process
-		if err = cmd.Start(); err != nil {
-			logger.Println("Error starting shell:", err)
-			sendErrorResponse(conn, fmt.Errorf("error starting shell"))
-			ptySlave.Close()
-			return
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		for {
+			t, payload, err := nc.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			switch t {
+			case nested.FrameStdin:
+				stdin.Write(payload)
+			case nested.FrameStdinClose:
+				// closing the PTY master would hang up the whole session,
+				// the terminal end of file character is sent instead
+				if ptyMaster != nil {
+					ptyMaster.Write([]byte{4})
+				} else {
+					stdin.Close()
+				}
+			case nested.FrameResize:
+				var size nested.WindowSize
+				if ptyMaster != nil && json.Unmarshal(payload, &size) == nil {
+					pty.Setsize(ptyMaster, &pty.Winsize{Rows: size.Rows, Cols: size.Cols})
+				}
+			case nested.FrameSignal:
+				if len(payload) == 4 {
+					sig := syscall.Signal(binary.BigEndian.Uint32(payload))
+					syscall.Kill(-cmd.Process.Pid, sig)
+				}
+			}
 		}
+	}()
 
-		cmdExited := make(chan error, 1)
-		go func() {
-			cmdExited <- cmd.Wait()
-			ptySlave.Close() // Close slave after command exits
-		}()
+	cmdExited := make(chan error, 1)
+	go func() {
+		cmdExited <- cmd.Wait()
+	}()
 
-		select {
-		case err := <-cmdExited:
-			if err != nil {
-				logger.Printf("Nested cpak command exited with error: %v", err)
-			} else {
-				logger.Println("Nested cpak command exited successfully.")
-			}
-			sendSuccessResponse(conn)
-		case <-done:
-			logger.Println("Client connection closed or errored. Terminating nested cpak process.")
-			if cmd.Process != nil {
-				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	select {
+	case err := <-cmdExited:
+		exitCode := 0
+		if err != nil {
+			logger.Printf("Nested cpak command exited with error: %v", err)
+			exitCode = 1
+			if code, ok := tools.ExitCode(err); ok {
+				exitCode = code
 			}
+		} else {
+			logger.Println("Nested cpak command exited successfully.")
 		}
-	default:
-		logger.Printf("Unknown request: %s", params.Action)
-		sendErrorResponse(conn, fmt.Errorf("unknown request: %s", params.Action))
-	}
-}
 
-func sendSuccessResponse(conn net.Conn) {
-	response := "OK"
-	_, err := conn.Write([]byte(response))
-	if err != nil {
+		// the exit status must follow the whole command output, so we
+		// wait for it to be drained before sending it
+		select {
+		case <-outputDone:
+		case <-time.After(nestedOutputDrainTimeout):
+			logger.Println("Timeout draining nested cpak output.")
+		}
+		err = nc.WriteUint32(nested.FrameExit, uint32(int32(exitCode)))
+		if err != nil {
+			logger.Printf("Error sending exit status: %v", err)
+		}
+	case <-clientGone:
+		logger.Println("Client connection closed or errored. Terminating nested cpak process.")
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-cmdExited
 	}
 }
 
-func sendErrorResponse(conn net.Conn, errToSend error) {
-	response := fmt.Sprintf("Error: %v", errToSend)
-	_, err := conn.Write([]byte(response))
-	if err != nil {
-	}
-}
+// nestedOutputDrainTimeout is the maximum time the service waits for the
+// nested command output to be forwarded after the command has exited.
+const nestedOutputDrainTimeout = 5 * time.Second
+
+// nestedSignals are the signals relayed to nested commands, SIGWINCH is
+// handled separately as a terminal resize.
+var nestedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
 
 func (c *Cpak) RunNested(parentAppCpakId string, origin string, version string, branch string, commit string, release string, binary string, extraArgs ...string) (err error) {
 	logger.Println("Running another cpak container in nested mode...")
 
 	// the RequestParams struct is used by the server to check if the cpak
 	// which is running, has the ability to run the specified nested cpak
-	params := types.RequestParams{
-		Action:      "run",
-		ParentAppId: parentAppCpakId,
-		Origin:      origin,
-		Version:     version,
-		Branch:      branch,
-		Commit:      commit,
-		Release:     release,
-		Binary:      binary,
-		ExtraArgs:   extraArgs,
-	}
-	requestData, err := json.Marshal(params)
-	if err != nil {
-		logger.Printf("Error encoding request data as JSON: %v", err)
-		return
+	req := nested.Request{
+		Params: types.RequestParams{
+			Action:      "run",
+			ParentAppId: parentAppCpakId,
+			Origin:      origin,
+			Version:     version,
+			Branch:      branch,
+			Commit:      commit,
+			Release:     release,
+			Binary:      binary,
+			ExtraArgs:   extraArgs,
+		},
 	}
 
-	// start a connection to the socket
-	conn, err := net.Dial("unix", "/tmp/cpak.sock")
+	// start a connection to the socket, the path is provided by the host
+	// when entering the container
+	socketPath := os.Getenv("CPAK_SERVICE_SOCKET")
+	if socketPath == "" {
+		socketPath, err = GetServiceSocketPath()
+		if err != nil {
+			return
+		}
+	}
+	client, err := nested.Dial(socketPath)
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer client.Close()
+
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+	if term.IsTerminal(stdinFd) && term.IsTerminal(stdoutFd) {
+		req.Tty = true
+		if cols, rows, sizeErr := term.GetSize(stdoutFd); sizeErr == nil {
+			req.Size = &nested.WindowSize{Rows: uint16(rows), Cols: uint16(cols)}
+		}
+	}
 
-	// the requestData is sent to the socket to validate the action
-	// before setting up the channels to communicate with the host
-	logger.Printf("Sending request to the socket: %s", requestData)
-	_, err = conn.Write(requestData)
+	logger.Printf("Sending request to the socket: %+v", req.Params)
+	session, err := client.Run(req, nested.Stdio{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	})
 	if err != nil {
-		logger.Printf("Error sending request: %v", err)
-		return
+		return fmt.Errorf("nested run refused: %w", err)
 	}
 
-	// set up the channels to communicate with the host
-	doneCh := make(chan struct{})
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	// the local terminal is set to raw mode so that every key, including
+	// the control ones, reaches the nested command terminal
+	if req.Tty {
+		state, rawErr := term.MakeRaw(stdinFd)
+		if rawErr == nil {
+			defer term.Restore(stdinFd, state)
+		}
+	}
 
+	sigCh := make(chan os.Signal, 8)
+	signal.Notify(sigCh, append(nestedSignals, syscall.SIGWINCH)...)
+	defer signal.Stop(sigCh)
 	go func() {
-		io.Copy(os.Stdout, conn)
-		close(doneCh)
-	}()
-	go func() {
-		io.Copy(conn, os.Stdin)
-		conn.(*net.UnixConn).CloseWrite()
+		for {
+			select {
+			case sig := <-sigCh:
+				if sig == syscall.SIGWINCH {
+					if cols, rows, sizeErr := term.GetSize(stdoutFd); sizeErr == nil {
+						session.Resize(nested.WindowSize{Rows: uint16(rows), Cols: uint16(cols)})
+					}
+					continue
+				}
+				session.Signal(sig.(syscall.Signal))
+			case <-session.Done():
+				return
+			}
+		}
 	}()
 
-	select {
-	case <-doneCh:
-	case <-sigCh:
-		logger.Println("Interrupt received, closing nested connection.")
+	exitCode, err := session.Wait()
+	if err != nil {
+		return
+	}
+	if exitCode != 0 {
+		return &ExitStatusError{Code: exitCode}
 	}
 	return
 }
@@ -0,0 +1,69 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mirkobrombin/cpak/pkg/nested"
+)
+
+func TestServeNestedCommandOutput(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "cpak.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// more than a pipe buffer is written right before exiting
+	const size = 1 << 20
+	go func() {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		nc := nested.NewConn(netConn)
+		defer nc.Close()
+
+		req, err := nc.ServerHandshake()
+		if err != nil {
+			t.Errorf("ServerHandshake: %v", err)
+			return
+		}
+		serveNestedCommand(nc, exec.Command("sh", "-c", "head -c 1048576 /dev/zero; printf tail >&2; exit 3"), req)
+	}()
+
+	client, err := nested.Dial(socketPath)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	var stdout, stderr bytes.Buffer
+	session, err := client.Run(nested.Request{}, nested.Stdio{Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	exitCode, err := session.Wait()
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	if exitCode != 3 {
+		t.Errorf("exit code = %d, want 3", exitCode)
+	}
+	if stdout.Len() != size {
+		t.Errorf("stdout length = %d, want %d", stdout.Len(), size)
+	}
+	if stderr.String() != "tail" {
+		t.Errorf("stderr = %q, want %q", stderr.String(), "tail")
+	}
+}
@@ -0,0 +1,59 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// bwrapRuntime creates the namespaces using bubblewrap. The host root is
+// bound as is, since the container root filesystem is assembled later by
+// the spawn command, which needs the capabilities bwrap drops by default.
+// bwrap is only looked up when a container is spawned, so that the
+// commands which do not start one keep working without it.
+type bwrapRuntime struct {
+	pidFileRuntime
+	NsenterBinPath string
+}
+
+func (r *bwrapRuntime) Name() string {
+	return types.RuntimeBwrap
+}
+
+func (r *bwrapRuntime) Spawn(cpakBinary string, args []string, env []string, network bool) error {
+	bwrapBinPath, err := exec.LookPath("bwrap")
+	if err != nil {
+		return fmt.Errorf("bwrap runtime selected but bubblewrap is not installed: %w", err)
+	}
+
+	cmds := []string{
+		"--unshare-user",
+		"--uid", "0",
+		"--gid", "0",
+		"--unshare-ipc",
+		"--unshare-uts",
+		"--unshare-cgroup-try",
+	}
+	if !network {
+		cmds = append(cmds, "--unshare-net")
+	}
+	cmds = append(cmds,
+		"--cap-add", "ALL",
+		"--dev-bind", "/", "/",
+		"--",
+		cpakBinary,
+	)
+	cmds = append(cmds, args...)
+
+	return spawnCommand(bwrapBinPath, cmds, env).Run()
+}
+
+func (r *bwrapRuntime) Exec(pid int, command []string, asRoot bool) *exec.Cmd {
+	return nsenterCommand(r.NsenterBinPath, pid, command, asRoot)
+}
@@ -0,0 +1,50 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"os/exec"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// rootlesskitRuntime creates the namespaces using rootlesskit, which also
+// maps the user subordinate ids in the user namespace.
+type rootlesskitRuntime struct {
+	pidFileRuntime
+	BinPath        string
+	NsenterBinPath string
+}
+
+func (r *rootlesskitRuntime) Name() string {
+	return types.RuntimeRootlesskit
+}
+
+func (r *rootlesskitRuntime) Spawn(cpakBinary string, args []string, env []string, network bool) error {
+	cmds := []string{}
+	if isVerbose {
+		cmds = append(cmds, "--debug")
+	}
+	//"--net=slirp4netns",
+	if !network {
+		cmds = append(cmds, "--net=none")
+	}
+	cmds = append(cmds, []string{
+		"--cgroupns=true",
+		"--utsns=true",
+		"--ipcns=true",
+		"--copy-up=/etc",
+		"--propagation=rslave",
+		cpakBinary,
+	}...)
+	cmds = append(cmds, args...)
+
+	return spawnCommand(r.BinPath, cmds, env).Run()
+}
+
+func (r *rootlesskitRuntime) Exec(pid int, command []string, asRoot bool) *exec.Cmd {
+	return nsenterCommand(r.NsenterBinPath, pid, command, asRoot)
+}
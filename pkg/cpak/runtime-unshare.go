@@ -0,0 +1,53 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// unshareRuntime creates the namespaces directly with clone(2), without any
+// external binary. It requires the kernel to allow unprivileged user
+// namespaces and, unlike rootlesskit, only maps the current user, since
+// mapping the subordinate ids would require the setuid newuidmap helper.
+type unshareRuntime struct {
+	pidFileRuntime
+	NsenterBinPath string
+}
+
+func (r *unshareRuntime) Name() string {
+	return types.RuntimeUnshare
+}
+
+func (r *unshareRuntime) Spawn(cpakBinary string, args []string, env []string, network bool) error {
+	cmd := spawnCommand(cpakBinary, args, env)
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER |
+		syscall.CLONE_NEWNS |
+		syscall.CLONE_NEWUTS |
+		syscall.CLONE_NEWIPC |
+		syscall.CLONE_NEWCGROUP
+	if !network {
+		// the loopback interface is brought up by the spawn command
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+	}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{
+		{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+	}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+
+	return cmd.Run()
+}
+
+func (r *unshareRuntime) Exec(pid int, command []string, asRoot bool) *exec.Cmd {
+	return nsenterCommand(r.NsenterBinPath, pid, command, asRoot)
+}
@@ -0,0 +1,169 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// Runtime is the backend used to create the containers namespaces and to
+// run processes inside them. The container itself is always assembled by
+// the cpak spawn command, the runtime is only responsible for the isolation.
+type Runtime interface {
+	// Name returns the name of the runtime, as used in the cpak options.
+	Name() string
+
+	// Spawn runs the cpak binary with the given arguments in a new set of
+	// namespaces, where it is mapped as root. Without network, it also gets
+	// a private network namespace, holding the loopback interface only. It
+	// returns once the command exits, processes left running keep the
+	// namespaces alive.
+	Spawn(cpakBinary string, args []string, env []string, network bool) error
+
+	// Exec returns the command which runs the given command in the
+	// namespaces of the container process with the given pid. If asRoot is
+	// false, the command runs as the current user.
+	Exec(pid int, command []string, asRoot bool) *exec.Cmd
+
+	// Stop terminates the main process of the given container.
+	Stop(container types.Container) error
+
+	// Status reports whether the main process of the given container is
+	// running.
+	Status(container types.Container) (running bool, err error)
+}
+
+// NewRuntime returns the runtime selected in the given options.
+func NewRuntime(options types.CpakOptions) (Runtime, error) {
+	switch options.Runtime {
+	case types.RuntimeRootlesskit:
+		return &rootlesskitRuntime{
+			BinPath:        options.RotlesskitBinPath,
+			NsenterBinPath: options.NsenterBinPath,
+		}, nil
+	case types.RuntimeUnshare:
+		return &unshareRuntime{
+			NsenterBinPath: options.NsenterBinPath,
+		}, nil
+	case types.RuntimeBwrap:
+		return &bwrapRuntime{
+			NsenterBinPath: options.NsenterBinPath,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown runtime: %s", options.Runtime)
+}
+
+// spawnCommand returns the command used by all the runtimes to run the
+// given binary, detached from the current session.
+func spawnCommand(binary string, args []string, env []string) *exec.Cmd {
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Foreground: false,
+		Setsid:     true,
+	}
+	return cmd
+}
+
+// nsenterCommand returns the command which enters the namespaces of the
+// given pid, using the embedded nsenter. Namespaces are regular kernel
+// namespaces for all the runtimes, so this is shared by all of them.
+func nsenterCommand(nsenterBinPath string, pid int, command []string, asRoot bool) *exec.Cmd {
+	uid := fmt.Sprintf("%d", os.Getuid())
+	gid := fmt.Sprintf("%d", os.Getgid())
+
+	cmds := []string{
+		"-m",
+		"-u",
+		"-U",
+		"--preserve-credentials",
+		"-i",
+		// "-p",
+		// "-S", strconv.FormatInt(int64(os.Getuid()), 10),
+		// "-G", strconv.FormatInt(int64(os.Getgid()), 10),
+	}
+	// the host network namespace cannot be entered from the container
+	// user namespace, only a private one is
+	if hasPrivateNetwork(pid) {
+		cmds = append(cmds, "-n")
+	}
+	cmds = append(cmds, "-t", fmt.Sprintf("%d", pid), "--")
+
+	if !asRoot {
+		cmds = append(
+			cmds,
+			"unshare",
+			"-U",
+			"--map-user="+uid,
+			"--map-group="+gid,
+			"--",
+		)
+	}
+	cmds = append(cmds, command...)
+
+	return exec.Command(nsenterBinPath, cmds...)
+}
+
+// hasPrivateNetwork reports whether the process with the given pid runs in
+// a network namespace other than the current one.
+func hasPrivateNetwork(pid int) bool {
+	own, err := os.Readlink("/proc/self/ns/net")
+	if err != nil {
+		return false
+	}
+	other, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return false
+	}
+	return own != other
+}
+
+// nsenterUserCommand returns the command which only enters the user
+// namespace of the given pid, keeping the host mount namespace. The command
+// gets the capabilities of the container root, which owns the container
+// mount namespace.
+func nsenterUserCommand(nsenterBinPath string, pid int, command []string) *exec.Cmd {
+	cmds := []string{"-U", "--preserve-credentials", "-t", fmt.Sprintf("%d", pid), "--"}
+	return exec.Command(nsenterBinPath, append(cmds, command...)...)
+}
+
+// pidFileRuntime implements the Stop and Status methods for the runtimes
+// which leave the container process tracked by its pid file only.
+type pidFileRuntime struct{}
+
+func (pidFileRuntime) Stop(container types.Container) error {
+	pidFile, err := tools.ReadPidFile(containerPidFilePath(container))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	err = pidFile.Signal(syscall.SIGTERM)
+	if errors.Is(err, tools.ErrProcessGone) {
+		return nil
+	}
+	return err
+}
+
+func (pidFileRuntime) Status(container types.Container) (running bool, err error) {
+	_, err = readContainerPidFile(container)
+	if err != nil {
+		return false, nil
+	}
+	return true, nil
+}
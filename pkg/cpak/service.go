@@ -0,0 +1,181 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/tools"
+	"golang.org/x/sys/unix"
+)
+
+// ServiceSocketName is the name of the nested-run service socket, inside
+// the service socket directory.
+const ServiceSocketName = "cpak.sock"
+
+// serviceSocketDirName is the name of the directory holding the service
+// socket, inside the service directory. Containers get this directory
+// bound, so it must never hold anything else than the socket.
+const serviceSocketDirName = "socket"
+
+// serviceLockName is the name of the lock file held by the running service,
+// inside the service directory.
+const serviceLockName = "service.lock"
+
+// serviceReadyTimeout is the maximum time to wait for the service to accept
+// connections after it has been started.
+const serviceReadyTimeout = 5 * time.Second
+
+// ErrServiceRunning is returned when the service is started while another
+// instance is already running for the same user.
+var ErrServiceRunning = errors.New("cpak service is already running")
+
+// GetServiceDir returns the per-user directory holding the nested-run
+// service socket, creating it if needed. The directory lives in
+// $XDG_RUNTIME_DIR and is only accessible by the current user.
+func GetServiceDir() (dir string, err error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir != "" {
+		dir = filepath.Join(runtimeDir, "cpak")
+	} else {
+		// without a runtime directory we fall back to a per-user directory
+		// in the temporary one, its ownership is checked below since
+		// anyone could have created it before us
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("cpak-%d", os.Getuid()))
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to create service directory: %w", err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() {
+		return "", fmt.Errorf("service directory %s is not owned by the current user", dir)
+	}
+
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// GetServiceSocketDir returns the directory holding the nested-run service
+// socket of the current user, creating it if needed. The lock file and any
+// other service file live in its parent, out of reach of the containers.
+func GetServiceSocketDir() (string, error) {
+	dir, err := GetServiceDir()
+	if err != nil {
+		return "", err
+	}
+	socketDir := filepath.Join(dir, serviceSocketDirName)
+	err = os.Mkdir(socketDir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("failed to create service socket directory: %w", err)
+	}
+	return socketDir, nil
+}
+
+// GetServiceSocketPath returns the path to the nested-run service socket
+// of the current user.
+func GetServiceSocketPath() (string, error) {
+	dir, err := GetServiceSocketDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ServiceSocketName), nil
+}
+
+// lockService takes the service single-instance lock, which is released
+// when the returned file is closed, the process exit included. If another
+// service instance holds the lock, ErrServiceRunning is returned.
+func lockService(dir string) (lock *os.File, err error) {
+	lock, err = os.OpenFile(filepath.Join(dir, serviceLockName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err != nil {
+		lock.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, ErrServiceRunning
+		}
+		return nil, err
+	}
+	return lock, nil
+}
+
+// isServiceRunning checks if a service instance holds the lock in the
+// given service directory.
+func isServiceRunning(dir string) bool {
+	lock, err := lockService(dir)
+	if err != nil {
+		return errors.Is(err, ErrServiceRunning)
+	}
+	lock.Close()
+	return false
+}
+
+// waitForService waits for the service to accept connections on the given
+// socket, up to the given timeout.
+func waitForService(socketPath string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for the cpak service on %s: %w", socketPath, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// checkPeerCredentials ensures that the process on the other side of the
+// given connection belongs to the current user, the socket permissions
+// already prevent other users from connecting but containers bind the
+// socket in their own mount namespace. The peer credentials are returned.
+func checkPeerCredentials(conn net.Conn) (*unix.Ucred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a unix socket connection")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+
+	if !tools.IsUserOwnedID(cred.Uid) {
+		return nil, fmt.Errorf("connection from pid %d with uid %d refused", cred.Pid, cred.Uid)
+	}
+	return cred, nil
+}
@@ -0,0 +1,377 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package cpak
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/mirkobrombin/cpak/pkg/logger"
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// The shells supported by GetShellEnv.
+const (
+	ShellBash = "bash"
+	ShellZsh  = "zsh"
+	ShellFish = "fish"
+)
+
+// Shells is the list of the shells supported by GetShellEnv.
+var Shells = []string{ShellBash, ShellZsh, ShellFish}
+
+// completionPaths maps the directories the completions are looked up in,
+// relative to the root of the application, to the ones they are exported
+// to, relative to the exported share directory. The %s is replaced by the
+// command name.
+var completionPaths = []struct {
+	source string
+	dest   string
+}{
+	{"usr/share/bash-completion/completions/%s", "bash-completion/completions/%s"},
+	{"usr/share/bash-completion/completions/%s.bash", "bash-completion/completions/%s.bash"},
+	{"usr/share/bash-completion/completions/_%s", "bash-completion/completions/_%s"},
+	{"usr/share/zsh/site-functions/_%s", "zsh/site-functions/_%s"},
+	{"usr/share/zsh/vendor-completions/_%s", "zsh/site-functions/_%s"},
+	{"usr/share/fish/vendor_completions.d/%s.fish", "fish/vendor_completions.d/%s.fish"},
+	{"usr/share/fish/completions/%s.fish", "fish/vendor_completions.d/%s.fish"},
+}
+
+// manPagesDir is where the applications ship their man pages.
+const manPagesDir = "usr/share/man"
+
+// GetExportsBinDir returns the managed directory holding a command for each
+// binary exported by the installed applications, meant to be added to PATH.
+func (c *Cpak) GetExportsBinDir() string {
+	return filepath.Join(c.Options.ExportsPath, "bin")
+}
+
+// GetExportsShareDir returns the directory the completions and man pages of
+// the exported commands are exported to. It is a sibling of the bin
+// directory, so that man and bash-completion find them through PATH too.
+func (c *Cpak) GetExportsShareDir() string {
+	return filepath.Join(c.Options.ExportsPath, "share")
+}
+
+// exportCommand exports the given binary of the application as a command
+// of the managed bin directory, along with its completions and man pages.
+// Commands are named after the binary: an alias set by the user takes
+// priority, otherwise the first application exporting a name keeps it as
+// long as it is installed. The others can still be run through their own
+// exports directory, or chosen with an alias.
+func (c *Cpak) exportCommand(store *Store, app types.Application, binary string) error {
+	name := filepath.Base(binary)
+
+	alias, err := store.GetAlias(name)
+	if err != nil {
+		return err
+	}
+	if alias.Name != "" {
+		if alias.Origin != app.Origin {
+			logger.Printf("Warning: command %s is an alias of %s, use 'cpak alias set %s %s' to switch to %s", name, alias.Origin, name, getAppRemote(app), app.Name)
+		}
+		return c.updateCommand(store, name, "")
+	}
+
+	owner, err := c.getCommandOwner(store, name)
+	if err != nil {
+		return err
+	}
+	if owner.CpakId != "" && owner.CpakId != app.CpakId {
+		logger.Printf("Warning: command %s is already exported by %s, use 'cpak alias set %s %s' to switch to %s", name, getAppRemote(owner), name, getAppRemote(app), app.Name)
+		return nil
+	}
+	return c.writeCommand(store, name, app, binary, getSelectorFlags(app.Branch, app.Commit, app.Release), nil)
+}
+
+// updateCommand exports the command with the given name from its provider:
+// the application of the alias with the same name, the current provider if
+// still installed, or the most recently installed application exporting a
+// binary with that name, preferring the given origin. The command is
+// removed if there is no provider left.
+func (c *Cpak) updateCommand(store *Store, name string, preferredOrigin string) error {
+	alias, err := store.GetAlias(name)
+	if err != nil {
+		return err
+	}
+	if alias.Name != "" {
+		app, err := store.GetApplicationByOrigin(alias.Origin, "", alias.Branch, alias.Commit, alias.Release)
+		binary := alias.Binary
+		if err == nil && binary == "" {
+			binary = getProvidedBinary(app, name)
+		}
+		if err == nil && binary != "" {
+			return c.writeCommand(store, name, app, binary, getSelectorFlags(alias.Branch, alias.Commit, alias.Release), alias.Args)
+		}
+		logger.Printf("Warning: the application of alias %s (%s) is not installed, falling back to the exported binaries", name, alias.Origin)
+	}
+
+	owner, err := c.getCommandOwner(store, name)
+	if err != nil {
+		return err
+	}
+	provider := owner
+	if getProvidedBinary(owner, name) == "" {
+		apps, err := store.GetApplications()
+		if err != nil {
+			return err
+		}
+		provider = types.Application{}
+		for _, app := range apps {
+			if app.DerivedFrom != "" || getProvidedBinary(app, name) == "" {
+				continue
+			}
+			if provider.CpakId == "" || (app.Origin == preferredOrigin && provider.Origin != preferredOrigin) {
+				provider = app
+			}
+		}
+	}
+
+	if provider.CpakId == "" {
+		record, err := store.GetExportedFile(filepath.Join(c.GetExportsBinDir(), name))
+		if err != nil || record.Path == "" {
+			return err
+		}
+		return c.removeCommand(store, record)
+	}
+	if provider.CpakId != owner.CpakId {
+		logger.Printf("Command %s is now provided by %s", name, getAppRemote(provider))
+	}
+	return c.writeCommand(store, name, provider, getProvidedBinary(provider, name), getSelectorFlags(provider.Branch, provider.Commit, provider.Release), nil)
+}
+
+// writeCommand writes the command with the given name, running the binary
+// of the application with the given cpak run flags and default arguments,
+// and replacing the command of another provider. Completions and man pages
+// are only exported for commands named after their binary, the others are
+// not known to them.
+func (c *Cpak) writeCommand(store *Store, name string, app types.Application, binary string, runFlags []string, args []string) error {
+	commandPath := filepath.Join(c.GetExportsBinDir(), name)
+
+	record, err := store.GetExportedFile(commandPath)
+	if err != nil {
+		return err
+	}
+	if record.Path != "" && (record.AppCpakId != app.CpakId || record.Source != binary) {
+		err = c.removeCommand(store, record)
+		if err != nil {
+			return err
+		}
+	}
+
+	runArgs := append([]string{"cpak", "run"}, runFlags...)
+	runArgs = append(runArgs, "--", app.Origin, "@"+binary)
+	runArgs = append(runArgs, args...)
+	for i, arg := range runArgs {
+		runArgs[i] = shellQuote(arg)
+	}
+	scriptContent := fmt.Sprintf("#!/bin/sh\nexec %s \"$@\"\n", strings.Join(runArgs, " "))
+	err = writeExport(store, app, types.ExportCommand, binary, commandPath, []byte(scriptContent), 0755)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(binary) != name {
+		return nil
+	}
+
+	shareDir := c.GetExportsShareDir()
+	files := map[string]string{}
+	for _, completion := range completionPaths {
+		dest := filepath.Join(shareDir, fmt.Sprintf(completion.dest, name))
+		if _, ok := files[dest]; ok {
+			continue
+		}
+		if path := c.findInLayers(app, "/"+fmt.Sprintf(completion.source, name), 0); path != "" {
+			files[dest] = path
+		}
+	}
+	for _, page := range c.findManPages(app, name) {
+		if path := c.findInLayers(app, "/"+page, 0); path != "" {
+			files[filepath.Join(shareDir, strings.TrimPrefix(page, "usr/share/"))] = path
+		}
+	}
+
+	for dest, src := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		kind := types.ExportCompletion
+		if strings.HasPrefix(dest, filepath.Join(shareDir, "man")) {
+			kind = types.ExportManPage
+		}
+		err = writeExport(store, app, kind, binary, dest, data, 0644)
+		if errors.Is(err, ErrExportConflict) {
+			logger.Printf("Warning: %s not exported: %v", kind, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeCommand removes the given exported command, along with the
+// completions and man pages exported with it.
+func (c *Cpak) removeCommand(store *Store, command types.ExportedFile) error {
+	err := removeExport(store, command)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(command.Source) != filepath.Base(command.Path) {
+		return nil
+	}
+
+	files, err := store.GetExportedFiles(command.AppCpakId)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Source != command.Source || (file.Kind != types.ExportCompletion && file.Kind != types.ExportManPage) {
+			continue
+		}
+		err = removeExport(store, file)
+		if err != nil {
+			return err
+		}
+		c.removeEmptyExportDirs(filepath.Dir(file.Path))
+	}
+	return nil
+}
+
+// getCommandOwner returns the installed application providing the command
+// with the given name, a zero Application is returned if there is none.
+func (c *Cpak) getCommandOwner(store *Store, name string) (types.Application, error) {
+	record, err := store.GetExportedFile(filepath.Join(c.GetExportsBinDir(), name))
+	if err != nil || record.AppCpakId == "" {
+		return types.Application{}, err
+	}
+	owner, err := store.GetApplicationByCpakId(record.AppCpakId)
+	if err != nil {
+		// the application was removed
+		return types.Application{}, nil
+	}
+	return owner, nil
+}
+
+// getProvidedBinary returns the binary of the application with the given
+// name, empty if it has none.
+func getProvidedBinary(app types.Application, name string) string {
+	for _, binary := range app.ParsedBinaries {
+		if filepath.Base(binary) == name {
+			return binary
+		}
+	}
+	return ""
+}
+
+// getSelectorFlags returns the cpak run flags selecting the given version
+// of an application.
+func getSelectorFlags(branch string, commit string, release string) (flags []string) {
+	if branch != "" {
+		flags = append(flags, "--branch", branch)
+	}
+	if commit != "" {
+		flags = append(flags, "--commit", commit)
+	}
+	if release != "" {
+		flags = append(flags, "--release", release)
+	}
+	return
+}
+
+// getAppRemote returns the remote of the given application, as accepted by
+// cpak alias set: its origin, followed by the branch, release or commit it
+// was installed from.
+func getAppRemote(app types.Application) string {
+	for _, selector := range []string{app.Branch, app.Release, app.Commit} {
+		if selector != "" {
+			return app.Origin + "@" + selector
+		}
+	}
+	return app.Origin
+}
+
+// shellQuote quotes the given argument for sh, if needed.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// findManPages returns the man pages of the given command found in the
+// application layers, relative to the root: the ones named after it and
+// the ones of its subcommands, e.g. git-commit. Translated pages are not
+// exported.
+func (c *Cpak) findManPages(app types.Application, name string) (pages []string) {
+	for _, layer := range app.ParsedLayers {
+		root := c.GetInStoreDir("layers", layer)
+		matches, _ := filepath.Glob(filepath.Join(root, manPagesDir, "man*", name+".*"))
+		subMatches, _ := filepath.Glob(filepath.Join(root, manPagesDir, "man*", name+"-*.*"))
+		for _, match := range append(matches, subMatches...) {
+			rel, err := filepath.Rel(root, match)
+			if err == nil && !slices.Contains(pages, rel) {
+				pages = append(pages, rel)
+			}
+		}
+	}
+	sort.Strings(pages)
+	return
+}
+
+// reassignCommands exports the commands the given application released
+// from the other applications providing them, if any, preferring the other
+// versions of the same application.
+func (c *Cpak) reassignCommands(store *Store, app types.Application, names []string) {
+	for _, name := range names {
+		err := c.updateCommand(store, name, app.Origin)
+		if err != nil {
+			logger.Printf("Warning: failed to export command %s: %v", name, err)
+		}
+	}
+}
+
+// GetShellEnv returns the commands setting up the given shell to find the
+// exported commands, their completions and man pages. The output is meant
+// to be evaluated in the shell startup file, e.g. with
+// eval "$(cpak env bash)".
+func (c *Cpak) GetShellEnv(shell string) (string, error) {
+	binDir := c.GetExportsBinDir()
+	shareDir := c.GetExportsShareDir()
+	manDir := filepath.Join(shareDir, "man")
+
+	var b strings.Builder
+	switch shell {
+	case ShellBash, ShellZsh:
+		fmt.Fprintf(&b, "case \":$PATH:\" in\n  *\":%s:\"*) ;;\n  *) export PATH=\"%s:$PATH\" ;;\nesac\n", binDir, binDir)
+		fmt.Fprintf(&b, "export XDG_DATA_DIRS=\"%s:${XDG_DATA_DIRS:-/usr/local/share:/usr/share}\"\n", shareDir)
+		// an empty item makes man look up the default paths too
+		fmt.Fprintf(&b, "export MANPATH=\"%s:$MANPATH\"\n", manDir)
+		if shell == ShellZsh {
+			// the completions are loaded by compinit, which must be run
+			// after this
+			fmt.Fprintf(&b, "fpath=(%q $fpath)\n", filepath.Join(shareDir, "zsh", "site-functions"))
+		}
+	case ShellFish:
+		fmt.Fprintf(&b, "contains -- %q $PATH; or set -gx PATH %q $PATH\n", binDir, binDir)
+		fmt.Fprintf(&b, "set -q XDG_DATA_DIRS; or set -gx XDG_DATA_DIRS /usr/local/share:/usr/share\n")
+		fmt.Fprintf(&b, "set -gx XDG_DATA_DIRS %q:$XDG_DATA_DIRS\n", shareDir)
+		fmt.Fprintf(&b, "set -q MANPATH; or set -gx MANPATH \"\"\n")
+		fmt.Fprintf(&b, "set -gx MANPATH %q $MANPATH\n", manDir)
+		// fish reads the vendor completions directories at startup only
+		fmt.Fprintf(&b, "set -p fish_complete_path %q\n", filepath.Join(shareDir, "fish", "vendor_completions.d"))
+	default:
+		return "", fmt.Errorf("unsupported shell %s, supported shells are: %s", shell, strings.Join(Shells, ", "))
+	}
+	return b.String(), nil
+}
@@ -9,7 +9,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
@@ -40,7 +42,7 @@ func NewStore(dbPath string) (s *Store, err error) {
 }
 
 func (s *Store) migrate() error {
-	err := s.DB.AutoMigrate(&types.Application{}, &types.Container{})
+	err := s.DB.AutoMigrate(&types.Application{}, &types.Container{}, &types.GpuDriver{}, &types.ExportedFile{}, &types.Alias{}, &types.CatalogSource{})
 	if err != nil {
 		return fmt.Errorf("gorm automigrate: %w", err)
 	}
@@ -67,6 +69,22 @@ func (s *Store) serializeApplicationFields(app *types.Application) {
 	} else {
 		app.OverrideRaw = ""
 	}
+
+	app.ServicesRaw = ""
+	if len(app.ParsedServices) > 0 {
+		servicesBytes, _ := json.Marshal(app.ParsedServices)
+		app.ServicesRaw = string(servicesBytes)
+	}
+	app.DBusServicesRaw = ""
+	if len(app.ParsedDBusServices) > 0 {
+		dbusServicesBytes, _ := json.Marshal(app.ParsedDBusServices)
+		app.DBusServicesRaw = string(dbusServicesBytes)
+	}
+	app.InfoRaw = ""
+	if !reflect.DeepEqual(app.ParsedInfo, types.AppInfo{}) {
+		infoBytes, _ := json.Marshal(app.ParsedInfo)
+		app.InfoRaw = string(infoBytes)
+	}
 }
 
 func (s *Store) parseApplicationFields(app *types.Application) {
@@ -104,6 +122,19 @@ func (s *Store) parseApplicationFields(app *types.Application) {
 	} else {
 		app.ParsedOverride = types.NewOverride()
 	}
+
+	app.ParsedServices = []types.Service{}
+	if app.ServicesRaw != "" {
+		json.Unmarshal([]byte(app.ServicesRaw), &app.ParsedServices)
+	}
+	app.ParsedDBusServices = []types.DBusService{}
+	if app.DBusServicesRaw != "" {
+		json.Unmarshal([]byte(app.DBusServicesRaw), &app.ParsedDBusServices)
+	}
+	app.ParsedInfo = types.AppInfo{}
+	if app.InfoRaw != "" {
+		json.Unmarshal([]byte(app.InfoRaw), &app.ParsedInfo)
+	}
 }
 
 func (s *Store) NewApplication(app types.Application) (err error) {
@@ -197,9 +228,37 @@ func (s *Store) GetApplicationContainers(application types.Application) (contain
 	if result.Error != nil {
 		return nil, fmt.Errorf("failed to query containers for app %s: %w", application.CpakId, result.Error)
 	}
+	for i := range containers {
+		s.resolveContainerPid(&containers[i])
+	}
 	return containers, nil
 }
 
+func (s *Store) GetContainerByCpakId(cpakId string) (container types.Container, err error) {
+	result := s.DB.Where("cpak_id = ?", cpakId).First(&container)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return container, fmt.Errorf("container with cpak_id %s not found", cpakId)
+		}
+		return container, fmt.Errorf("GetContainerByCpakId %w", result.Error)
+	}
+	s.resolveContainerPid(&container)
+	return container, nil
+}
+
+// resolveContainerPid sets the container's Pid from its pid file, which is
+// the source of truth for the container process. The Pid is reset to 0 if
+// the pid file is missing or does not match a running process anymore, e.g.
+// because the PID has been reused.
+func (s *Store) resolveContainerPid(container *types.Container) {
+	pidFile, err := readContainerPidFile(*container)
+	if err != nil {
+		container.Pid = 0
+		return
+	}
+	container.Pid = pidFile.Pid
+}
+
 func (s *Store) RemoveApplicationByCpakId(cpakId string) (err error) {
 	result := s.DB.Unscoped().Where("cpak_id = ?", cpakId).Delete(&types.Application{})
 	if result.Error != nil {
@@ -293,19 +352,38 @@ func (s *Store) GetApplicationByDesktopEntry(desktopEntry string) (app types.App
 	return app, gorm.ErrRecordNotFound
 }
 
+// GetApplicationByBinary returns the application providing the given
+// binary as a command of the managed bin directory, as chosen by the user
+// with an alias or on install. Otherwise the first installed application
+// exporting it is returned.
 func (s *Store) GetApplicationByBinary(binary string) (app types.Application, err error) {
+	var commands []types.ExportedFile
+	result := s.DB.Where("kind = ? AND source = ?", types.ExportCommand, binary).Find(&commands)
+	if result.Error != nil {
+		return app, fmt.Errorf("GetApplicationByBinary (loading commands): %w", result.Error)
+	}
+	for _, command := range commands {
+		// aliases with other names may run the same binary
+		if filepath.Base(command.Path) != filepath.Base(binary) {
+			continue
+		}
+		app, err = s.GetApplicationByCpakId(command.AppCpakId)
+		if err == nil {
+			return app, nil
+		}
+	}
+
 	apps, err := s.GetApplications()
 	if err != nil {
 		return app, fmt.Errorf("GetApplicationByBinary (loading apps): %w", err)
 	}
-	for _, currentApp := range apps {
-		for _, bin := range currentApp.ParsedBinaries {
-			if bin == binary {
-				return currentApp, nil
-			}
+	// applications are listed from the most recently installed one
+	for i := len(apps) - 1; i >= 0; i-- {
+		if slices.Contains(apps[i].ParsedBinaries, binary) {
+			return apps[i], nil
 		}
 	}
-	return app, gorm.ErrRecordNotFound
+	return types.Application{}, gorm.ErrRecordNotFound
 }
 
 func (s *Store) ParseDependenciesString(dependencyCpakIdsString string) (deps []types.Dependency, err error) {
@@ -331,6 +409,169 @@ func (s *Store) ParseDependenciesString(dependencyCpakIdsString string) (deps []
 	return deps, nil
 }
 
+// GetGpuDriver returns the cached discovery of the GPU driver of the given
+// vendor, a zero GpuDriver is returned if there is none.
+func (s *Store) GetGpuDriver(vendor string) (driver types.GpuDriver, err error) {
+	result := s.DB.Where("vendor = ?", vendor).Limit(1).Find(&driver)
+	if result.Error != nil {
+		return driver, fmt.Errorf("GetGpuDriver %w", result.Error)
+	}
+	if driver.FilesRaw != "" {
+		err = json.Unmarshal([]byte(driver.FilesRaw), &driver.ParsedFiles)
+		if err != nil {
+			return types.GpuDriver{}, fmt.Errorf("invalid cached GPU files for %s: %w", vendor, err)
+		}
+	}
+	return driver, nil
+}
+
+// SaveGpuDriver replaces the cached discovery of the GPU driver of the same
+// vendor with the given one.
+func (s *Store) SaveGpuDriver(driver types.GpuDriver) (err error) {
+	filesBytes, err := json.Marshal(driver.ParsedFiles)
+	if err != nil {
+		return
+	}
+	driver.FilesRaw = string(filesBytes)
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("vendor = ?", driver.Vendor).Delete(&types.GpuDriver{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove cached GPU driver %s: %w", driver.Vendor, result.Error)
+		}
+		driver.ID = 0
+		result = tx.Create(&driver)
+		if result.Error != nil {
+			return fmt.Errorf("failed to cache GPU driver %s: %w", driver.Vendor, result.Error)
+		}
+		return nil
+	})
+}
+
+// GetExportedFile returns the record of the exported file at the given
+// path, a zero ExportedFile is returned if there is none.
+func (s *Store) GetExportedFile(path string) (file types.ExportedFile, err error) {
+	result := s.DB.Where("path = ?", path).Limit(1).Find(&file)
+	if result.Error != nil {
+		return file, fmt.Errorf("GetExportedFile %w", result.Error)
+	}
+	return file, nil
+}
+
+// GetExportedFiles returns the files exported for the application with the
+// given CpakId, or for all the applications if cpakId is empty.
+func (s *Store) GetExportedFiles(cpakId string) (files []types.ExportedFile, err error) {
+	query := s.DB.Order("path")
+	if cpakId != "" {
+		query = query.Where("app_cpak_id = ?", cpakId)
+	}
+	result := query.Find(&files)
+	if result.Error != nil {
+		return nil, fmt.Errorf("GetExportedFiles %w", result.Error)
+	}
+	return files, nil
+}
+
+// SaveExportedFile records the given exported file, replacing the record
+// of the same path, which may belong to another application.
+func (s *Store) SaveExportedFile(file types.ExportedFile) (err error) {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("path = ?", file.Path).Delete(&types.ExportedFile{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove exported file %s: %w", file.Path, result.Error)
+		}
+		file.ID = 0
+		result = tx.Create(&file)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record exported file %s: %w", file.Path, result.Error)
+		}
+		return nil
+	})
+}
+
+// RemoveExportedFile removes the record of the exported file at the given
+// path.
+func (s *Store) RemoveExportedFile(path string) (err error) {
+	result := s.DB.Unscoped().Where("path = ?", path).Delete(&types.ExportedFile{})
+	if result.Error != nil {
+		return fmt.Errorf("RemoveExportedFile %w", result.Error)
+	}
+	return nil
+}
+
+// GetAlias returns the alias with the given name, a zero Alias is returned
+// if there is none.
+func (s *Store) GetAlias(name string) (alias types.Alias, err error) {
+	result := s.DB.Where("name = ?", name).Limit(1).Find(&alias)
+	if result.Error != nil {
+		return alias, fmt.Errorf("GetAlias %w", result.Error)
+	}
+	return alias, nil
+}
+
+// GetAliases returns all the aliases, ordered by name.
+func (s *Store) GetAliases() (aliases []types.Alias, err error) {
+	result := s.DB.Order("name").Find(&aliases)
+	if result.Error != nil {
+		return nil, fmt.Errorf("GetAliases %w", result.Error)
+	}
+	return aliases, nil
+}
+
+// SaveAlias records the given alias, replacing the one with the same name.
+func (s *Store) SaveAlias(alias types.Alias) (err error) {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("name = ?", alias.Name).Delete(&types.Alias{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove alias %s: %w", alias.Name, result.Error)
+		}
+		alias.ID = 0
+		result = tx.Create(&alias)
+		if result.Error != nil {
+			return fmt.Errorf("failed to save alias %s: %w", alias.Name, result.Error)
+		}
+		return nil
+	})
+}
+
+// RemoveAlias removes the alias with the given name.
+func (s *Store) RemoveAlias(name string) (err error) {
+	result := s.DB.Unscoped().Where("name = ?", name).Delete(&types.Alias{})
+	if result.Error != nil {
+		return fmt.Errorf("RemoveAlias %w", result.Error)
+	}
+	return nil
+}
+
+// GetCatalogSources returns the catalogs added by the user, ordered by
+// name.
+func (s *Store) GetCatalogSources() (sources []types.CatalogSource, err error) {
+	result := s.DB.Order("name").Find(&sources)
+	if result.Error != nil {
+		return nil, fmt.Errorf("GetCatalogSources %w", result.Error)
+	}
+	return sources, nil
+}
+
+// SaveCatalogSource records the given catalog, replacing the one with the
+// same name.
+func (s *Store) SaveCatalogSource(source types.CatalogSource) (err error) {
+	result := s.DB.Save(&source)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save catalog %s: %w", source.Name, result.Error)
+	}
+	return nil
+}
+
+// RemoveCatalogSource removes the catalog with the given name.
+func (s *Store) RemoveCatalogSource(name string) (err error) {
+	result := s.DB.Where("name = ?", name).Delete(&types.CatalogSource{})
+	if result.Error != nil {
+		return fmt.Errorf("RemoveCatalogSource %w", result.Error)
+	}
+	return nil
+}
+
 func (s *Store) Close() error {
 	if s.DB != nil {
 		sqlDB, err := s.DB.DB()
@@ -14,7 +14,41 @@ import (
 // ValidateManifest validates a CpakManifest against its JSON schema.
 func ValidateManifest(m *types.CpakManifest) error {
 	reflector := &jsonschema.Reflector{ExpandedStruct: true}
-	schema := reflector.Reflect(&types.CpakManifest{})
+	return validateAgainstSchema(reflector, &types.CpakManifest{}, m, "manifest")
+}
+
+// ValidateOverrideLayer validates a partial override against the override
+// JSON schema, every key being optional.
+func ValidateOverrideLayer(layer OverrideLayer) error {
+	reflector := &jsonschema.Reflector{
+		ExpandedStruct:             true,
+		RequiredFromJSONSchemaTags: true,
+	}
+	err := validateAgainstSchema(reflector, &types.Override{}, layer, "override")
+	if err != nil {
+		return err
+	}
+
+	// the schema does not check the list items, device rules are parsed
+	// here so that they are not only rejected when the application runs
+	if raw, ok := layer["deviceRules"]; ok {
+		rules := []string{}
+		if json.Unmarshal(raw, &rules) == nil {
+			for _, rule := range rules {
+				if _, err := types.ParseDeviceRule(rule); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateAgainstSchema validates the given document against the JSON
+// schema of v, reflected with the given reflector. The document is named
+// after what in the errors.
+func validateAgainstSchema(reflector *jsonschema.Reflector, v any, doc any, what string) error {
+	schema := reflector.Reflect(v)
 
 	schemaBytes, err := json.Marshal(schema)
 	if err != nil {
@@ -22,11 +56,11 @@ func ValidateManifest(m *types.CpakManifest) error {
 	}
 	schemaLoader := gojsonschema.NewBytesLoader(schemaBytes)
 
-	manifestBytes, err := json.Marshal(m)
+	docBytes, err := json.Marshal(doc)
 	if err != nil {
-		return fmt.Errorf("failed to marshal manifest: %w", err)
+		return fmt.Errorf("failed to marshal %s: %w", what, err)
 	}
-	documentLoader := gojsonschema.NewBytesLoader(manifestBytes)
+	documentLoader := gojsonschema.NewBytesLoader(docBytes)
 
 	result, err := gojsonschema.Validate(schemaLoader, documentLoader)
 	if err != nil {
@@ -35,7 +69,7 @@ func ValidateManifest(m *types.CpakManifest) error {
 
 	if !result.Valid() {
 		var sb strings.Builder
-		sb.WriteString("manifest validation failed:\n")
+		sb.WriteString(what + " validation failed:\n")
 		for _, desc := range result.Errors() {
 			sb.WriteString("  • ")
 			sb.WriteString(desc.String())
@@ -0,0 +1,181 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package nested
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// ErrNoExitStatus is returned when the connection is closed before the
+// service sends the exit status of the command.
+var ErrNoExitStatus = errors.New("nested run terminated without an exit status")
+
+// Client is a connection to the cpak service, ready to send a request.
+type Client struct {
+	conn *Conn
+}
+
+// Stdio holds the streams attached to a nested command. Stdin can be nil,
+// in which case the command standard input is closed right away.
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Session is a command running through the cpak service.
+type Session struct {
+	conn     *Conn
+	done     chan struct{}
+	mu       sync.Mutex
+	exitCode int
+	err      error
+}
+
+// Dial connects to the cpak service listening on the given socket and
+// performs the protocol handshake.
+func Dial(socketPath string) (*Client, error) {
+	netConn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := NewConn(netConn)
+	err = conn.WriteJSON(FrameHello, Hello{Version: ProtocolVersion})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	payload, err := conn.readExpected(FrameHello)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+
+	var hello Hello
+	err = json.Unmarshal(payload, &hello)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("invalid hello: %w", err)
+	}
+	if hello.Version != ProtocolVersion {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %d", ErrProtocolVersion, hello.Version)
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection to the service, terminating any running
+// session.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Run sends the given request and, once the service accepts it, starts
+// forwarding the given streams. A client runs a single request.
+func (c *Client) Run(req Request, stdio Stdio) (*Session, error) {
+	err := c.conn.WriteJSON(FrameRequest, req)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.conn.readExpected(FrameAccept)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		conn: c.conn,
+		done: make(chan struct{}),
+	}
+	go s.receive(stdio.Stdout, stdio.Stderr)
+	go s.send(stdio.Stdin)
+	return s, nil
+}
+
+// receive dispatches the frames sent by the service until the exit status
+// is received or the connection is closed.
+func (s *Session) receive(stdout io.Writer, stderr io.Writer) {
+	defer close(s.done)
+	for {
+		t, payload, err := s.conn.ReadFrame()
+		if err != nil {
+			s.finish(0, ErrNoExitStatus)
+			return
+		}
+
+		switch t {
+		case FrameStdout:
+			if stdout != nil {
+				stdout.Write(payload)
+			}
+		case FrameStderr:
+			if stderr != nil {
+				stderr.Write(payload)
+			}
+		case FrameError:
+			s.finish(0, errors.New(string(payload)))
+			return
+		case FrameExit:
+			if len(payload) != 4 {
+				s.finish(0, fmt.Errorf("invalid exit status frame"))
+				return
+			}
+			s.finish(int(int32(binary.BigEndian.Uint32(payload))), nil)
+			return
+		}
+	}
+}
+
+// send forwards the given reader as the command standard input.
+func (s *Session) send(stdin io.Reader) {
+	if stdin != nil {
+		_, err := io.Copy(s.conn.Writer(FrameStdin), stdin)
+		if err != nil {
+			return
+		}
+	}
+	s.conn.WriteFrame(FrameStdinClose, nil)
+}
+
+func (s *Session) finish(exitCode int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exitCode = exitCode
+	s.err = err
+}
+
+// Resize notifies the service that the client terminal has been resized.
+func (s *Session) Resize(size WindowSize) error {
+	return s.conn.WriteJSON(FrameResize, size)
+}
+
+// Signal delivers the given signal to the command process group.
+func (s *Session) Signal(sig syscall.Signal) error {
+	return s.conn.WriteUint32(FrameSignal, uint32(sig))
+}
+
+// Done returns a channel closed once the session is over.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Wait waits for the command to exit and returns its exit status.
+func (s *Session) Wait() (exitCode int, err error) {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exitCode, s.err
+}
@@ -0,0 +1,276 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+
+// Package nested implements the protocol spoken on the cpak service socket,
+// used by containers to run other cpak applications on the host.
+//
+// Every message is a frame made of a 1 byte type, a 4 bytes big endian
+// payload length and the payload itself. A session starts with both sides
+// exchanging a Hello frame carrying the protocol version, then the client
+// sends a Request frame which the service answers with Accept or Error.
+// Once accepted, the standard streams, terminal resizes and signals flow as
+// separate frames until the service sends the Exit frame.
+package nested
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// ProtocolVersion is the version of the protocol implemented by this
+// package, both sides must speak the same version.
+const ProtocolVersion = 1
+
+// MaxFrameSize is the maximum payload size of a single frame, larger
+// stream writes are split across multiple frames.
+const MaxFrameSize = 1 << 20
+
+// HandshakeTimeout is the time a client has to complete the handshake and
+// send its request, so that idle connections do not hold the service.
+var HandshakeTimeout = 5 * time.Second
+
+// FrameType identifies the content of a frame.
+type FrameType byte
+
+const (
+	// FrameHello carries a JSON encoded Hello, sent by both sides.
+	FrameHello FrameType = 0x01
+	// FrameRequest carries a JSON encoded Request, sent by the client.
+	FrameRequest FrameType = 0x02
+	// FrameAccept has no payload, sent by the service once the request
+	// has been accepted and the command started.
+	FrameAccept FrameType = 0x03
+	// FrameError carries an error message, sent by the service when the
+	// handshake or the request fail.
+	FrameError FrameType = 0x04
+
+	// FrameStdin carries data for the command standard input.
+	FrameStdin FrameType = 0x10
+	// FrameStdinClose has no payload, it closes the command standard input.
+	FrameStdinClose FrameType = 0x11
+	// FrameStdout carries data from the command standard output.
+	FrameStdout FrameType = 0x12
+	// FrameStderr carries data from the command standard error.
+	FrameStderr FrameType = 0x13
+
+	// FrameResize carries a JSON encoded WindowSize.
+	FrameResize FrameType = 0x20
+	// FrameSignal carries a 4 bytes big endian signal number, delivered to
+	// the command process group.
+	FrameSignal FrameType = 0x21
+
+	// FrameExit carries the 4 bytes big endian exit status of the command,
+	// it is the last frame of a session.
+	FrameExit FrameType = 0x30
+)
+
+// Hello is exchanged by both sides when the connection is established.
+type Hello struct {
+	Version int `json:"version"`
+}
+
+// WindowSize is the size of the client terminal.
+type WindowSize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// Request describes the command the client wants to run.
+type Request struct {
+	Params types.RequestParams `json:"params"`
+
+	// Tty requests the command to run in a pseudo terminal, in which case
+	// its output is only sent as FrameStdout.
+	Tty bool `json:"tty"`
+
+	// Size is the initial size of the pseudo terminal.
+	Size *WindowSize `json:"size,omitempty"`
+}
+
+// ErrProtocolVersion is returned when the two sides speak different
+// versions of the protocol.
+var ErrProtocolVersion = errors.New("unsupported nested protocol version")
+
+// Conn wraps a service socket connection, reading and writing frames. Any
+// number of goroutines can write frames concurrently.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// NewConn returns a Conn for the given connection.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// Close closes the underlying connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// WriteFrame writes a single frame with the given type and payload.
+func (c *Conn) WriteFrame(t FrameType, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame too large: %d bytes", len(payload))
+	}
+
+	header := make([]byte, 5)
+	header[0] = byte(t)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+// WriteJSON writes a frame with the given type and the JSON encoding of v
+// as payload.
+func (c *Conn) WriteJSON(t FrameType, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteFrame(t, payload)
+}
+
+// WriteUint32 writes a frame with the given type and a 4 bytes big endian
+// value as payload.
+func (c *Conn) WriteUint32(t FrameType, v uint32) error {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, v)
+	return c.WriteFrame(t, payload)
+}
+
+// ReadFrame reads the next frame. It must not be called concurrently.
+func (c *Conn) ReadFrame() (t FrameType, payload []byte, err error) {
+	header := make([]byte, 5)
+	_, err = io.ReadFull(c.r, header)
+	if err != nil {
+		return
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxFrameSize {
+		return 0, nil, fmt.Errorf("frame too large: %d bytes", size)
+	}
+
+	payload = make([]byte, size)
+	_, err = io.ReadFull(c.r, payload)
+	if err != nil {
+		return
+	}
+	return FrameType(header[0]), payload, nil
+}
+
+// readExpected reads the next frame, failing if it is not of the given
+// type. Error frames are returned as errors.
+func (c *Conn) readExpected(expected FrameType) ([]byte, error) {
+	t, payload, err := c.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	if t == FrameError {
+		return nil, errors.New(string(payload))
+	}
+	if t != expected {
+		return nil, fmt.Errorf("unexpected frame 0x%02x, expected 0x%02x", byte(t), byte(expected))
+	}
+	return payload, nil
+}
+
+// Writer returns a writer sending everything written to it as frames of
+// the given type.
+func (c *Conn) Writer(t FrameType) io.Writer {
+	return &frameWriter{conn: c, t: t}
+}
+
+type frameWriter struct {
+	conn *Conn
+	t    FrameType
+}
+
+func (w *frameWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxFrameSize {
+			chunk = chunk[:MaxFrameSize]
+		}
+		err = w.conn.WriteFrame(w.t, chunk)
+		if err != nil {
+			return
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+// ServerHandshake performs the service side of the handshake and reads the
+// client request, within HandshakeTimeout.
+func (c *Conn) ServerHandshake() (req Request, err error) {
+	err = c.conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	if err != nil {
+		return
+	}
+	defer c.conn.SetDeadline(time.Time{})
+
+	payload, err := c.readExpected(FrameHello)
+	if err != nil {
+		return
+	}
+
+	var hello Hello
+	err = json.Unmarshal(payload, &hello)
+	if err != nil {
+		return req, fmt.Errorf("invalid hello: %w", err)
+	}
+	if hello.Version != ProtocolVersion {
+		err = fmt.Errorf("%w: %d", ErrProtocolVersion, hello.Version)
+		c.Reject(err)
+		return
+	}
+
+	err = c.WriteJSON(FrameHello, Hello{Version: ProtocolVersion})
+	if err != nil {
+		return
+	}
+
+	payload, err = c.readExpected(FrameRequest)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(payload, &req)
+	if err != nil {
+		err = fmt.Errorf("invalid request: %w", err)
+		c.Reject(err)
+	}
+	return
+}
+
+// Accept tells the client that its request has been accepted.
+func (c *Conn) Accept() error {
+	return c.WriteFrame(FrameAccept, nil)
+}
+
+// Reject tells the client that its request has been rejected with the
+// given error.
+func (c *Conn) Reject(reason error) error {
+	return c.WriteFrame(FrameError, []byte(reason.Error()))
+}
@@ -0,0 +1,385 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package nested
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/cpak/pkg/types"
+)
+
+// pipe returns the two ends of an in-memory connection.
+func pipe(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return NewConn(a), NewConn(b)
+}
+
+// rawConn returns a Conn reading the given bytes, followed by EOF.
+func rawConn(t *testing.T, data []byte) *Conn {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() { b.Close() })
+	go func() {
+		a.Write(data)
+		a.Close()
+	}()
+	return NewConn(b)
+}
+
+func header(t FrameType, size uint32) []byte {
+	h := make([]byte, 5)
+	h[0] = byte(t)
+	binary.BigEndian.PutUint32(h[1:], size)
+	return h
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []struct {
+		t       FrameType
+		payload []byte
+	}{
+		{FrameAccept, nil},
+		{FrameStdout, []byte("hello")},
+		{FrameStderr, []byte{0x00, 0xff, 0x10}},
+		{FrameStdin, bytes.Repeat([]byte{'x'}, MaxFrameSize)},
+		{FrameStdinClose, nil},
+	}
+
+	client, server := pipe(t)
+	go func() {
+		for _, f := range frames {
+			if err := client.WriteFrame(f.t, f.payload); err != nil {
+				t.Errorf("WriteFrame(0x%02x): %v", byte(f.t), err)
+				return
+			}
+		}
+	}()
+
+	for _, want := range frames {
+		got, payload, err := server.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		if got != want.t {
+			t.Errorf("frame type = 0x%02x, want 0x%02x", byte(got), byte(want.t))
+		}
+		if !bytes.Equal(payload, want.payload) {
+			t.Errorf("frame 0x%02x payload has %d bytes, want %d", byte(want.t), len(payload), len(want.payload))
+		}
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	tests := map[string][]byte{
+		"header":  {byte(FrameStdout), 0x00, 0x00},
+		"payload": append(header(FrameStdout, 10), []byte("short")...),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := rawConn(t, data).ReadFrame()
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("ReadFrame error = %v, want %v", err, io.ErrUnexpectedEOF)
+			}
+		})
+	}
+
+	_, _, err := rawConn(t, nil).ReadFrame()
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("ReadFrame on a closed connection = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReadFrameOversized(t *testing.T) {
+	_, _, err := rawConn(t, header(FrameStdout, MaxFrameSize+1)).ReadFrame()
+	if err == nil || !strings.Contains(err.Error(), "frame too large") {
+		t.Errorf("ReadFrame error = %v, want a frame too large error", err)
+	}
+}
+
+func TestWriteFrameOversized(t *testing.T) {
+	client, _ := pipe(t)
+	err := client.WriteFrame(FrameStdout, make([]byte, MaxFrameSize+1))
+	if err == nil || !strings.Contains(err.Error(), "frame too large") {
+		t.Errorf("WriteFrame error = %v, want a frame too large error", err)
+	}
+}
+
+func TestWriterSplitsFrames(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 2*MaxFrameSize+10)
+	client, server := pipe(t)
+	go func() {
+		n, err := client.Writer(FrameStdout).Write(data)
+		if err != nil || n != len(data) {
+			t.Errorf("Write = %d, %v, want %d, nil", n, err, len(data))
+		}
+		client.WriteFrame(FrameStdinClose, nil)
+	}()
+
+	var got []byte
+	var sizes []int
+	for {
+		ft, payload, err := server.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame: %v", err)
+		}
+		if ft == FrameStdinClose {
+			break
+		}
+		got = append(got, payload...)
+		sizes = append(sizes, len(payload))
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("reassembled %d bytes, want %d", len(got), len(data))
+	}
+	if len(sizes) != 3 || sizes[2] != 10 {
+		t.Errorf("frame sizes = %v, want [%d %d 10]", sizes, MaxFrameSize, MaxFrameSize)
+	}
+}
+
+func TestServerHandshakeVersion(t *testing.T) {
+	client, server := pipe(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.ServerHandshake()
+		done <- err
+	}()
+
+	err := client.WriteJSON(FrameHello, Hello{Version: ProtocolVersion + 1})
+	if err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	_, err = client.readExpected(FrameHello)
+	if err == nil || !strings.Contains(err.Error(), ErrProtocolVersion.Error()) {
+		t.Errorf("client error = %v, want the version to be rejected", err)
+	}
+	if err := <-done; !errors.Is(err, ErrProtocolVersion) {
+		t.Errorf("ServerHandshake error = %v, want %v", err, ErrProtocolVersion)
+	}
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	timeout := HandshakeTimeout
+	HandshakeTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { HandshakeTimeout = timeout })
+
+	_, server := pipe(t)
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.ServerHandshake()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("ServerHandshake error = %v, want %v", err, os.ErrDeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServerHandshake did not time out")
+	}
+}
+
+// serve runs a service accepting a single session on a unix socket, the
+// given handler runs once the request has been accepted.
+func serve(t *testing.T, handler func(*Conn, Request)) string {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "cpak.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn := NewConn(netConn)
+		defer conn.Close()
+
+		req, err := conn.ServerHandshake()
+		if err != nil {
+			t.Errorf("ServerHandshake: %v", err)
+			return
+		}
+		if err := conn.Accept(); err != nil {
+			t.Errorf("Accept: %v", err)
+			return
+		}
+		handler(conn, req)
+	}()
+	return socketPath
+}
+
+func TestSession(t *testing.T) {
+	want := Request{
+		Params: types.RequestParams{
+			Action:    "run",
+			Origin:    "example.com/app",
+			ExtraArgs: []string{"--flag"},
+		},
+		Tty:  true,
+		Size: &WindowSize{Rows: 24, Cols: 80},
+	}
+
+	received := make(chan string, 8)
+	socketPath := serve(t, func(conn *Conn, req Request) {
+		got, _ := json.Marshal(req)
+		expected, _ := json.Marshal(want)
+		if !bytes.Equal(got, expected) {
+			t.Errorf("request = %s, want %s", got, expected)
+		}
+
+		conn.WriteFrame(FrameStdout, []byte("out"))
+		conn.WriteFrame(FrameStderr, []byte("err"))
+
+		var stdin []byte
+		for events := 0; events < 3; {
+			ft, payload, err := conn.ReadFrame()
+			if err != nil {
+				t.Errorf("ReadFrame: %v", err)
+				return
+			}
+			switch ft {
+			case FrameStdin:
+				stdin = append(stdin, payload...)
+			case FrameResize:
+				var size WindowSize
+				if err := json.Unmarshal(payload, &size); err != nil {
+					t.Errorf("invalid resize: %v", err)
+				}
+				received <- "resize"
+				events++
+				if size != (WindowSize{Rows: 50, Cols: 132}) {
+					t.Errorf("resize = %+v", size)
+				}
+			case FrameSignal:
+				received <- "signal"
+				events++
+				if len(payload) != 4 || syscall.Signal(binary.BigEndian.Uint32(payload)) != syscall.SIGINT {
+					t.Errorf("signal payload = %v, want SIGINT", payload)
+				}
+			case FrameStdinClose:
+				received <- "stdin:" + string(stdin)
+				events++
+			}
+		}
+		exitCode := -1
+		conn.WriteUint32(FrameExit, uint32(int32(exitCode)))
+	})
+
+	client, err := Dial(socketPath)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	var stdout, stderr bytes.Buffer
+	stdinReader, stdinWriter := io.Pipe()
+	session, err := client.Run(want, Stdio{Stdin: stdinReader, Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if err := session.Resize(WindowSize{Rows: 50, Cols: 132}); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if <-received != "resize" {
+		t.Fatal("resize not received first")
+	}
+	if err := session.Signal(syscall.SIGINT); err != nil {
+		t.Fatalf("Signal: %v", err)
+	}
+	if <-received != "signal" {
+		t.Fatal("signal not received")
+	}
+	stdinWriter.Write([]byte("input"))
+	stdinWriter.Close()
+	if got := <-received; got != "stdin:input" {
+		t.Errorf("stdin = %q, want %q", got, "stdin:input")
+	}
+
+	exitCode, err := session.Wait()
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if exitCode != -1 {
+		t.Errorf("exit code = %d, want -1", exitCode)
+	}
+	if stdout.String() != "out" || stderr.String() != "err" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestSessionWithoutExit(t *testing.T) {
+	socketPath := serve(t, func(conn *Conn, req Request) {
+		conn.WriteFrame(FrameExit, []byte{0x01})
+	})
+
+	client, err := Dial(socketPath)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.Run(Request{}, Stdio{})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	_, err = session.Wait()
+	if err == nil || !strings.Contains(err.Error(), "invalid exit status") {
+		t.Errorf("Wait error = %v, want an invalid exit status error", err)
+	}
+}
+
+func TestRunRejected(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "cpak.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		netConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn := NewConn(netConn)
+		defer conn.Close()
+		if _, err := conn.ServerHandshake(); err == nil {
+			conn.Reject(errors.New("application not found"))
+		}
+	}()
+
+	client, err := Dial(socketPath)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.Run(Request{}, Stdio{})
+	if err == nil || err.Error() != "application not found" {
+		t.Errorf("Run error = %v, want the rejection reason", err)
+	}
+}
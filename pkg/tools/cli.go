@@ -8,6 +8,7 @@ package tools
 import (
 	"bufio"
 	"os"
+	"os/exec"
 	"strings"
 
 	"github.com/mirkobrombin/cpak/pkg/logger"
@@ -20,3 +21,21 @@ func ConfirmOperation(s string) bool {
 	text = strings.Replace(text, "\n", "", -1)
 	return strings.ToLower(text) == "y"
 }
+
+// ConfirmDesktop asks the desktop user to confirm an operation through a
+// graphical dialog, using zenity or kdialog. It returns false when neither
+// is available, so that operations are denied without a desktop session.
+func ConfirmDesktop(title string, text string) bool {
+	var cmd *exec.Cmd
+	if path, err := exec.LookPath("zenity"); err == nil {
+		cmd = exec.Command(path, "--question", "--title", title, "--text", text)
+	} else if path, err := exec.LookPath("kdialog"); err == nil {
+		cmd = exec.Command(path, "--title", title, "--yesno", text)
+	} else {
+		logger.Println("No dialog tool found, unable to ask for confirmation")
+		return false
+	}
+
+	// both tools exit with 0 when the user confirms
+	return cmd.Run() == nil
+}
@@ -18,9 +18,6 @@ import (
 	"github.com/mirkobrombin/cpak/pkg/logger"
 )
 
-//go:embed rootlesskit.tar.gz
-var rootlesskit []byte
-
 //go:embed nsenter
 var nsenter []byte
 
@@ -31,7 +28,8 @@ var nsenter []byte
 // and extracted to the specified binPath if it is not already present. If
 // rootlesskit is already present in the system, it is not used, cpak will
 // always use the embedded one, this is to ensure that the rootlesskit version
-// used by cpak is always the expected one.
+// used by cpak is always the expected one. Other runtimes only need nsenter,
+// which is always embedded.
 func EnsureUnixDeps(binPath string, rootlessImplementation string) error {
 	err := os.MkdirAll(binPath, 0755)
 	if err != nil {
@@ -54,6 +52,10 @@ func EnsureUnixDeps(binPath string, rootlessImplementation string) error {
 			return nil
 		}
 
+		if !EmbeddedRootlesskit {
+			return fmt.Errorf("rootlesskit not found in %s and cpak was built without the embedded one, install it or use another runtime", binPath)
+		}
+
 		logger.Println("rootlesskit not found, installing it from embedded binary")
 
 		gzipReader, err := gzip.NewReader(bytes.NewReader(rootlesskit))
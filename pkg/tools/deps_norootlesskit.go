@@ -0,0 +1,17 @@
+//go:build norootlesskit
+
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+
+package tools
+
+// rootlesskit is not embedded when building with the norootlesskit tag,
+// it must be provided by the host if the rootlesskit runtime is used.
+var rootlesskit []byte
+
+// EmbeddedRootlesskit reports whether the rootlesskit tarball is embedded
+// in the cpak binary.
+const EmbeddedRootlesskit = false
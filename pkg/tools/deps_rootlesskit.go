@@ -0,0 +1,20 @@
+//go:build !norootlesskit
+
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+
+package tools
+
+import (
+	_ "embed"
+)
+
+//go:embed rootlesskit.tar.gz
+var rootlesskit []byte
+
+// EmbeddedRootlesskit reports whether the rootlesskit tarball is embedded
+// in the cpak binary.
+const EmbeddedRootlesskit = true
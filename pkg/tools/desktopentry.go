@@ -0,0 +1,285 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DesktopEntryMainGroup is the group holding the main keys of a desktop
+// entry, the actions are in "Desktop Action <name>" groups.
+const DesktopEntryMainGroup = "Desktop Entry"
+
+// DesktopEntry is a parsed desktop entry file, see the Desktop Entry
+// Specification. Comments, blank lines and the order of the keys are kept,
+// so that writing it back only changes the keys which were set.
+type DesktopEntry struct {
+	// Header holds the comments and blank lines before the first group.
+	Header []string
+	Groups []*DesktopEntryGroup
+}
+
+// DesktopEntryGroup is a [group] of a desktop entry.
+type DesktopEntryGroup struct {
+	Name  string
+	Lines []DesktopEntryLine
+}
+
+// DesktopEntryLine is a line of a group, either a key, with an optional
+// locale, e.g. Name[it], or a comment or blank line kept in Raw.
+type DesktopEntryLine struct {
+	Key   string
+	Value string
+	Raw   string
+}
+
+// ParseDesktopEntry parses the given desktop entry file content.
+func ParseDesktopEntry(data []byte) (*DesktopEntry, error) {
+	entry := &DesktopEntry{}
+	var group *DesktopEntryGroup
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			group = &DesktopEntryGroup{Name: trimmed[1 : len(trimmed)-1]}
+			entry.Groups = append(entry.Groups, group)
+			continue
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			if group == nil {
+				entry.Header = append(entry.Header, line)
+			} else {
+				group.Lines = append(group.Lines, DesktopEntryLine{Raw: line})
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || group == nil {
+			return nil, fmt.Errorf("invalid desktop entry line %d: %s", i+1, line)
+		}
+		group.Lines = append(group.Lines, DesktopEntryLine{
+			Key:   strings.TrimSpace(key),
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	if entry.Group(DesktopEntryMainGroup) == nil {
+		return nil, fmt.Errorf("missing [%s] group", DesktopEntryMainGroup)
+	}
+	return entry, nil
+}
+
+// Group returns the group with the given name, nil if there is none.
+func (e *DesktopEntry) Group(name string) *DesktopEntryGroup {
+	for _, group := range e.Groups {
+		if group.Name == name {
+			return group
+		}
+	}
+	return nil
+}
+
+// Main returns the [Desktop Entry] group.
+func (e *DesktopEntry) Main() *DesktopEntryGroup {
+	return e.Group(DesktopEntryMainGroup)
+}
+
+// Actions returns the groups of the actions listed in the Actions key,
+// the groups of unlisted actions are ignored as per specification.
+func (e *DesktopEntry) Actions() (groups []*DesktopEntryGroup) {
+	value, _ := e.Main().Get("Actions")
+	for _, action := range SplitDesktopEntryList(value) {
+		if group := e.Group("Desktop Action " + action); group != nil {
+			groups = append(groups, group)
+		}
+	}
+	return
+}
+
+// Bytes returns the content of the desktop entry file.
+func (e *DesktopEntry) Bytes() []byte {
+	var b strings.Builder
+	for _, line := range e.Header {
+		b.WriteString(line + "\n")
+	}
+	for i, group := range e.Groups {
+		if i > 0 && !strings.HasSuffix(b.String(), "\n\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString("[" + group.Name + "]\n")
+		for _, line := range group.Lines {
+			if line.Key == "" {
+				b.WriteString(line.Raw + "\n")
+			} else {
+				b.WriteString(line.Key + "=" + line.Value + "\n")
+			}
+		}
+	}
+	return []byte(b.String())
+}
+
+// Get returns the value of the given key, without locale.
+func (g *DesktopEntryGroup) Get(key string) (string, bool) {
+	for _, line := range g.Lines {
+		if line.Key == key {
+			return line.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of the given key, appending it if missing.
+func (g *DesktopEntryGroup) Set(key string, value string) {
+	for i, line := range g.Lines {
+		if line.Key == key {
+			g.Lines[i].Value = value
+			return
+		}
+	}
+
+	// keys go before the trailing blank lines separating the next group
+	pos := len(g.Lines)
+	for pos > 0 && g.Lines[pos-1].Key == "" && strings.TrimSpace(g.Lines[pos-1].Raw) == "" {
+		pos--
+	}
+	line := DesktopEntryLine{Key: key, Value: value}
+	g.Lines = append(g.Lines[:pos], append([]DesktopEntryLine{line}, g.Lines[pos:]...)...)
+}
+
+// Delete removes the given key, along with its localized variants.
+func (g *DesktopEntryGroup) Delete(key string) {
+	lines := g.Lines[:0]
+	for _, line := range g.Lines {
+		if line.Key == key || strings.HasPrefix(line.Key, key+"[") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	g.Lines = lines
+}
+
+// SplitDesktopEntryList splits a list value, e.g. MimeType, whose items are
+// separated by semicolons, which can be escaped as \;.
+func SplitDesktopEntryList(value string) (items []string) {
+	var item strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == ';':
+			item.WriteByte(';')
+			i++
+		case value[i] == ';':
+			if item.Len() > 0 {
+				items = append(items, item.String())
+			}
+			item.Reset()
+		default:
+			item.WriteByte(value[i])
+		}
+	}
+	if item.Len() > 0 {
+		items = append(items, item.String())
+	}
+	return
+}
+
+// desktopExecReserved are the characters which need the argument to be
+// quoted in an Exec value.
+const desktopExecReserved = " \t\n\"'\\><~|&;$*?#()`"
+
+// ParseDesktopExec splits the given Exec value into its arguments, undoing
+// the string escapes first and then the quoting. Field codes are kept as
+// they are.
+func ParseDesktopExec(value string) (args []string, err error) {
+	value = unescapeDesktopString(value)
+
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case quoted && ch == '\\':
+			if i+1 >= len(value) || !strings.ContainsRune("\"`$\\", rune(value[i+1])) {
+				return nil, fmt.Errorf("invalid escape in quoted argument: %s", value)
+			}
+			arg.WriteByte(value[i+1])
+			i++
+		case ch == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (ch == ' ' || ch == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(ch)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote: %s", value)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// FormatDesktopExec returns the Exec value running the given arguments,
+// quoting the ones holding reserved characters. Field codes, e.g. %U, are
+// not quoted so that they are still expanded.
+func FormatDesktopExec(args []string) string {
+	quotedArgs := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, desktopExecReserved) {
+			quotedArgs[i] = arg
+			continue
+		}
+		var b strings.Builder
+		b.WriteByte('"')
+		for _, ch := range arg {
+			if strings.ContainsRune("\"`$\\", ch) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(ch)
+		}
+		b.WriteByte('"')
+		quotedArgs[i] = b.String()
+	}
+
+	// the string escapes are applied on top of the quoting
+	return strings.ReplaceAll(strings.Join(quotedArgs, " "), "\\", "\\\\")
+}
+
+func unescapeDesktopString(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 >= len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 's':
+			b.WriteByte(' ')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\':
+			b.WriteByte('\\')
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
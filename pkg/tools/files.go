@@ -6,10 +6,18 @@
 package tools
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // ResolvePath resolves the given path, following symlinks.
@@ -111,3 +119,211 @@ func CopyDirContent(src, dest string) error {
 
 	return nil
 }
+
+// whiteoutPrefix is the prefix of the files marking a deleted path in an
+// overlay layer, when character device whiteouts cannot be created.
+const whiteoutPrefix = ".wh."
+
+// opaqueWhiteout is the file marking a directory whose content replaces
+// the one of the lower layers.
+const opaqueWhiteout = ".wh..wh..opq"
+
+// opaqueXattrs are the extended attributes the overlay implementations
+// mark opaque directories with.
+var opaqueXattrs = []string{"user.overlay.opaque", "trusted.overlay.opaque", "user.fuseoverlayfs.opaque"}
+
+// IsWhiteout checks if the given entry is an overlay whiteout, which is a
+// character device with 0/0 device number.
+func IsWhiteout(d fs.DirEntry) bool {
+	if d.Type()&os.ModeCharDevice == 0 {
+		return false
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// WhiteoutTarget returns the name of the path deleted by the given .wh.
+// whiteout file name, empty if it is not one.
+func WhiteoutTarget(name string) string {
+	if name == opaqueWhiteout || !strings.HasPrefix(name, whiteoutPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(name, whiteoutPrefix)
+}
+
+// IsOpaqueDir checks if the given directory of an overlay layer hides the
+// content of the lower layers, either through an extended attribute or an
+// opaque whiteout file.
+func IsOpaqueDir(path string) bool {
+	if _, err := os.Lstat(filepath.Join(path, opaqueWhiteout)); err == nil {
+		return true
+	}
+	value := make([]byte, 1)
+	for _, attr := range opaqueXattrs {
+		n, err := unix.Lgetxattr(path, attr, value)
+		if err == nil && n == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// CloneTree copies the content of the src directory into the dest one,
+// preserving symlinks and permissions. Regular files are cloned with a
+// reflink when the filesystem supports it, otherwise their data is copied.
+// Existing files in dest are replaced, so multiple trees can be stacked:
+// the overlay whiteouts and opaque directories of src delete the paths they
+// hide from dest, as the overlay filesystem does for the lower layers.
+//
+// Note: hardlinks are never used, since writes to the destination tree
+// would otherwise reach the source one.
+func CloneTree(src, dest string) (reflinked bool, err error) {
+	reflinked = true
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if os.IsPermission(walkErr) {
+				return nil
+			}
+			return walkErr
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.Name() == opaqueWhiteout:
+			return nil
+		case WhiteoutTarget(d.Name()) != "":
+			return os.RemoveAll(filepath.Join(filepath.Dir(target), WhiteoutTarget(d.Name())))
+		case IsWhiteout(d):
+			return os.RemoveAll(target)
+		case d.IsDir():
+			if rel != "." && IsOpaqueDir(path) {
+				err = os.RemoveAll(target)
+				if err != nil {
+					return err
+				}
+			}
+			err = os.MkdirAll(target, 0755)
+			if err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode()&cloneModeMask)
+		case d.Type()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_ = os.RemoveAll(target)
+			return os.Symlink(linkTarget, target)
+		case d.Type().IsRegular():
+			_ = os.RemoveAll(target)
+			cloned, err := cloneFile(path, target, info.Mode()&cloneModeMask)
+			if err != nil {
+				return fmt.Errorf("clone %s: %w", path, err)
+			}
+			if !cloned {
+				reflinked = false
+			}
+			return nil
+		}
+
+		// device nodes, sockets and pipes are not part of the layers
+		return nil
+	})
+	return
+}
+
+// cloneModeMask are the mode bits kept by CloneTree. os.Chmod turns the
+// setuid, setgid and sticky bits into the unix ones, which Perm drops.
+const cloneModeMask = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// cloneFile clones the src file to dest using a reflink, falling back to a
+// plain copy when reflinks are not supported.
+func cloneFile(src, dest string, perm os.FileMode) (cloned bool, err error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return
+	}
+	defer destFile.Close()
+
+	err = unix.IoctlFileClone(int(destFile.Fd()), int(srcFile.Fd()))
+	if err == nil {
+		return true, destFile.Chmod(perm)
+	}
+
+	_, err = io.Copy(destFile, srcFile)
+	if err != nil {
+		return
+	}
+	return false, destFile.Chmod(perm)
+}
+
+// HashTree returns the sha256 digest of the given directory tree, covering
+// paths, file types, permissions, symlink targets, device numbers and file
+// contents. The walk is sorted, so the same tree always has the same digest.
+func HashTree(root string) (digest string, err error) {
+	hasher := sha256.New()
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hasher, "%s\x00%o\x00", rel, info.Mode())
+		switch {
+		case d.IsDir() && IsOpaqueDir(path):
+			fmt.Fprint(hasher, "opaque\x00")
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "%s\x00", target)
+		case info.Mode()&os.ModeDevice != 0:
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				fmt.Fprintf(hasher, "%d\x00", stat.Rdev)
+			}
+		case info.Mode().IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(hasher, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
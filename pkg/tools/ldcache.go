@@ -0,0 +1,155 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package tools
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// LdCachePath is the path of the dynamic linker cache, built by ldconfig.
+const LdCachePath = "/etc/ld.so.cache"
+
+const (
+	ldCacheOldMagic = "ld.so-1.7.0"
+	ldCacheNewMagic = "glibc-ld.so.cache1.1"
+
+	// the sizes of the cache headers and entries, see sysdeps/generic/
+	// dl-cache.h in glibc
+	ldCacheOldHeaderSize = 16
+	ldCacheOldEntrySize  = 12
+	ldCacheNewHeaderSize = 48
+	ldCacheNewEntrySize  = 24
+
+	// ldCacheFlagElfLibc6 marks the glibc libraries, the arch flags are in
+	// the upper byte
+	ldCacheFlagTypeMask = 0x00ff
+	ldCacheFlagElfLibc6 = 0x0003
+)
+
+// LdCacheEntry is a library listed in the dynamic linker cache.
+type LdCacheEntry struct {
+	// Name is the SONAME of the library.
+	Name string
+
+	// Path is the path the dynamic linker loads the library from.
+	Path string
+
+	// Flags holds the library type and architecture, as set by ldconfig.
+	Flags int32
+}
+
+// ReadLdCache returns the glibc libraries listed in the given dynamic linker
+// cache. Both the new format and the old one followed by the new one are
+// supported.
+func ReadLdCache(path string) (entries []LdCacheEntry, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	offset := 0
+	if bytes.HasPrefix(data, []byte(ldCacheOldMagic)) {
+		if len(data) < ldCacheOldHeaderSize {
+			return nil, fmt.Errorf("%s is truncated", path)
+		}
+		nlibs := binary.NativeEndian.Uint32(data[12:16])
+		offset = ldCacheOldHeaderSize + int(nlibs)*ldCacheOldEntrySize
+		// the new format is aligned to its 64 bit fields
+		offset = (offset + 7) &^ 7
+	}
+	if offset > len(data) || !bytes.HasPrefix(data[offset:], []byte(ldCacheNewMagic)) {
+		return nil, fmt.Errorf("%s has an unsupported format", path)
+	}
+
+	// the string offsets are relative to the new format header
+	cache := data[offset:]
+	if len(cache) < ldCacheNewHeaderSize {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	nlibs := int(binary.NativeEndian.Uint32(cache[20:24]))
+	if len(cache) < ldCacheNewHeaderSize+nlibs*ldCacheNewEntrySize {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+
+	for i := 0; i < nlibs; i++ {
+		entry := cache[ldCacheNewHeaderSize+i*ldCacheNewEntrySize:]
+		flags := int32(binary.NativeEndian.Uint32(entry[0:4]))
+		if flags&ldCacheFlagTypeMask != ldCacheFlagElfLibc6 {
+			continue
+		}
+
+		name, nameOk := ldCacheString(cache, binary.NativeEndian.Uint32(entry[4:8]))
+		libPath, pathOk := ldCacheString(cache, binary.NativeEndian.Uint32(entry[8:12]))
+		if !nameOk || !pathOk {
+			return nil, fmt.Errorf("%s has an invalid entry", path)
+		}
+		entries = append(entries, LdCacheEntry{Name: name, Path: libPath, Flags: flags})
+	}
+	return entries, nil
+}
+
+func ldCacheString(cache []byte, offset uint32) (string, bool) {
+	if int(offset) >= len(cache) {
+		return "", false
+	}
+	end := bytes.IndexByte(cache[offset:], 0)
+	if end < 0 {
+		return "", false
+	}
+	return string(cache[offset : int(offset)+end]), true
+}
+
+// ElfDynamic holds the dynamic linking details of an ELF shared library.
+type ElfDynamic struct {
+	// Soname is the SONAME of the library, empty if it has none.
+	Soname string
+
+	// Needed are the SONAMEs of the libraries it depends on.
+	Needed []string
+
+	// Class and Machine tell the architecture the library is built for.
+	Class   elf.Class
+	Machine elf.Machine
+}
+
+// ReadElfDynamic returns the dynamic linking details of the given ELF
+// shared library.
+func ReadElfDynamic(path string) (dyn ElfDynamic, err error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	dyn.Class = file.Class
+	dyn.Machine = file.Machine
+	sonames, err := file.DynString(elf.DT_SONAME)
+	if err != nil {
+		return
+	}
+	if len(sonames) > 0 {
+		dyn.Soname = sonames[0]
+	}
+	dyn.Needed, err = file.DynString(elf.DT_NEEDED)
+	return
+}
+
+// ReadElfSoname returns the SONAME recorded in the dynamic section of the
+// given ELF shared library.
+func ReadElfSoname(path string) (string, error) {
+	dyn, err := ReadElfDynamic(path)
+	if err != nil {
+		return "", err
+	}
+	if dyn.Soname == "" {
+		return "", fmt.Errorf("%s has no SONAME", path)
+	}
+	return dyn.Soname, nil
+}
@@ -12,6 +12,7 @@ import (
 	"os/exec"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // IsMounted checks if the given source path is mounted in the given
@@ -69,13 +70,59 @@ func MountOverlay(targetDir, lowerDir, upperDir, workDir string) error {
 	)
 }
 
-func MountFuseOverlayfs(targetDir, lowerDir, upperDir, workDir string) (err error) {
-	c := exec.Command("fuse-overlayfs", targetDir, "-o", fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workDir))
+// MountFuseOverlayfs mounts the given lower, upper and work directories in
+// the given destination path using fuse-overlayfs, for hosts where kernel
+// overlay mounts are not allowed for unprivileged users. The fuse-overlayfs
+// process is kept in foreground and returned once the mount is ready, so
+// that the caller can track and stop it. The process is waited for here,
+// its exit status is sent on the returned channel once it exits, and it
+// must not be waited for or released by the caller.
+func MountFuseOverlayfs(targetDir, lowerDir, upperDir, workDir string) (process *os.Process, exited <-chan error, err error) {
+	if _, err = exec.LookPath("fuse-overlayfs"); err != nil {
+		return nil, nil, err
+	}
+	if _, err = os.Stat("/dev/fuse"); err != nil {
+		return nil, nil, err
+	}
+
+	c := exec.Command("fuse-overlayfs", "-f", "-o", fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workDir), targetDir)
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
-	return c.Run()
+	err = c.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- c.Wait()
+	}()
+
+	deadline := time.After(fuseOverlayfsTimeout)
+	for {
+		mounted, _ := IsMounted("fuse-overlayfs", targetDir)
+		if mounted {
+			return c.Process, waited, nil
+		}
+
+		select {
+		case err = <-waited:
+			if err == nil {
+				err = fmt.Errorf("fuse-overlayfs exited before mounting %s", targetDir)
+			}
+			return nil, nil, err
+		case <-deadline:
+			_ = c.Process.Kill()
+			return nil, nil, fmt.Errorf("timeout waiting for fuse-overlayfs to mount %s", targetDir)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
 }
 
+// fuseOverlayfsTimeout is the maximum time to wait for fuse-overlayfs to
+// complete the mount.
+const fuseOverlayfsTimeout = 5 * time.Second
+
 func MountTmpfs(targetDir string) (err error) {
 	c := exec.Command("mount", "-t", "tmpfs", "tmpfs", targetDir)
 	c.Stdout = os.Stdout
@@ -0,0 +1,113 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package tools
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MountInfo is an entry of a process mount table.
+type MountInfo struct {
+	// Device is the major:minor number of the mounted filesystem.
+	Device string
+
+	// Root is the path, inside the filesystem, which is mounted.
+	Root string
+
+	// MountPoint is the path where the filesystem is mounted, as seen by
+	// the process.
+	MountPoint string
+}
+
+// ReadMountInfo reads the mount table of the process with the given pid
+// from /proc/<pid>/mountinfo.
+func ReadMountInfo(pid int) (mounts []MountInfo, err error) {
+	file, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "mountinfo"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid mountinfo line: %s", scanner.Text())
+		}
+		mounts = append(mounts, MountInfo{
+			Device:     fields[2],
+			Root:       unescapeMountPath(fields[3]),
+			MountPoint: unescapeMountPath(fields[4]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// TranslatePath translates the given absolute path, as seen by a process
+// with the from mount table, to the same file as seen by a process with the
+// to mount table. The second return value is false if the file is not
+// reachable with the to mount table.
+func TranslatePath(path string, from []MountInfo, to []MountInfo) (string, bool) {
+	path = filepath.Clean(path)
+
+	// the last matching mount is the one on top, since mount tables are
+	// listed in mount order
+	var source *MountInfo
+	for i := range from {
+		if isPathUnder(path, from[i].MountPoint) && (source == nil || len(from[i].MountPoint) >= len(source.MountPoint)) {
+			source = &from[i]
+		}
+	}
+	if source == nil {
+		return "", false
+	}
+	inFs := filepath.Join(source.Root, strings.TrimPrefix(path, source.MountPoint))
+
+	var target *MountInfo
+	for i := range to {
+		if to[i].Device != source.Device || !isPathUnder(inFs, to[i].Root) {
+			continue
+		}
+		if target == nil || len(to[i].Root) > len(target.Root) {
+			target = &to[i]
+		}
+	}
+	if target == nil {
+		return "", false
+	}
+	return filepath.Join(target.MountPoint, strings.TrimPrefix(inFs, target.Root)), true
+}
+
+// isPathUnder checks if path is dir or one of its descendants.
+func isPathUnder(path string, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// unescapeMountPath decodes the octal escapes used by the kernel for
+// spaces, tabs, newlines and backslashes in mount table paths.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if v, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
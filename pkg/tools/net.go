@@ -0,0 +1,36 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package tools
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// SetLoopbackUp brings up the loopback interface of the current network
+// namespace, which is down in a newly created one. Nothing is done if it
+// is already up.
+func SetLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq)
+	if err != nil {
+		return err
+	}
+	flags := ifreq.Uint16()
+	if flags&unix.IFF_UP != 0 {
+		return nil
+	}
+	ifreq.SetUint16(flags | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
@@ -0,0 +1,237 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// pidFileNamespaces is the list of namespaces recorded in a pid file, those
+// that are missing on the host are simply skipped.
+var pidFileNamespaces = []string{"mnt", "user", "uts", "ipc", "cgroup", "net", "pid"}
+
+// ErrProcessGone is returned when the process described by a pid file is
+// not running anymore or its PID has been reused by another process.
+var ErrProcessGone = errors.New("process is not running")
+
+// PidFile describes a process in a way that allows detecting PID reuse: the
+// PID alone is not enough since the kernel can assign it to a new process
+// once the original one exits.
+type PidFile struct {
+	// Pid is the process identifier, in the host PID namespace.
+	Pid int `json:"pid"`
+
+	// StartTime is the process start time, in clock ticks since boot, as
+	// reported by /proc/<pid>/stat.
+	StartTime uint64 `json:"start_time"`
+
+	// Namespaces maps the namespace names to their inode numbers, as
+	// reported by /proc/<pid>/ns/<name>.
+	Namespaces map[string]uint64 `json:"namespaces"`
+}
+
+// NewPidFile collects the identifiers of the process with the given pid.
+func NewPidFile(pid int) (pidFile PidFile, err error) {
+	pidFile.Pid = pid
+	pidFile.StartTime, err = getProcStartTime(pid)
+	if err != nil {
+		return
+	}
+
+	pidFile.Namespaces, err = getProcNamespaces(pid)
+	return
+}
+
+// ReadPidFile reads the pid file at the given path.
+func ReadPidFile(path string) (pidFile PidFile, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(data, &pidFile)
+	if err != nil {
+		return pidFile, fmt.Errorf("invalid pid file %s: %w", path, err)
+	}
+	if pidFile.Pid <= 0 {
+		return pidFile, fmt.Errorf("invalid pid file %s: missing pid", path)
+	}
+	return
+}
+
+// Write writes the pid file to the given file, which is expected to be
+// already open, so that it can be written after a pivot_root.
+func (p PidFile) Write(file *os.File) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Verify checks that the process described by the pid file is still the
+// one running with that PID. The process is pinned with a pidfd while its
+// identifiers are compared, so a PID reused in the meantime is detected.
+func (p PidFile) Verify() error {
+	pidfd, err := unix.PidfdOpen(p.Pid, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			// kernels older than 5.3 lack pidfd support, the start time
+			// comparison is the best we can do there
+			return p.verifyIdentifiers()
+		}
+		if errors.Is(err, unix.ESRCH) {
+			return ErrProcessGone
+		}
+		return fmt.Errorf("pidfd_open %d: %w", p.Pid, err)
+	}
+	defer unix.Close(pidfd)
+
+	err = p.verifyIdentifiers()
+	if err != nil {
+		return err
+	}
+
+	// the identifiers were read from /proc after pinning the process, if it
+	// is still alive now they surely belonged to it
+	err = unix.PidfdSendSignal(pidfd, 0, nil, 0)
+	if err != nil {
+		return ErrProcessGone
+	}
+	return nil
+}
+
+// Signal verifies the process described by the pid file and sends it the
+// given signal through a pidfd, so that it is never delivered to another
+// process which reused the PID.
+func (p PidFile) Signal(sig syscall.Signal) error {
+	pidfd, err := unix.PidfdOpen(p.Pid, 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOSYS) {
+			if err := p.verifyIdentifiers(); err != nil {
+				return err
+			}
+			return syscall.Kill(p.Pid, sig)
+		}
+		if errors.Is(err, unix.ESRCH) {
+			return ErrProcessGone
+		}
+		return fmt.Errorf("pidfd_open %d: %w", p.Pid, err)
+	}
+	defer unix.Close(pidfd)
+
+	err = p.verifyIdentifiers()
+	if err != nil {
+		return err
+	}
+
+	err = unix.PidfdSendSignal(pidfd, sig, nil, 0)
+	if errors.Is(err, unix.ESRCH) {
+		return ErrProcessGone
+	}
+	return err
+}
+
+// verifyIdentifiers compares the start time and namespaces of the running
+// process with the ones recorded in the pid file.
+func (p PidFile) verifyIdentifiers() error {
+	startTime, err := getProcStartTime(p.Pid)
+	if err != nil {
+		return ErrProcessGone
+	}
+	if startTime != p.StartTime {
+		return ErrProcessGone
+	}
+
+	namespaces, err := getProcNamespaces(p.Pid)
+	if err != nil {
+		return ErrProcessGone
+	}
+	for name, inode := range p.Namespaces {
+		if namespaces[name] != inode {
+			return fmt.Errorf("%w: %s namespace of %d changed", ErrProcessGone, name, p.Pid)
+		}
+	}
+	return nil
+}
+
+// getProcStartTime returns the start time of the process with the given
+// pid, reading the 22nd field of /proc/<pid>/stat.
+func getProcStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+
+	// the command name (2nd field) can contain spaces, so fields are
+	// counted after its closing parenthesis
+	stat := string(data)
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat for %d", pid)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("malformed stat for %d", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// getProcNamespaces returns the namespace inodes of the process with the
+// given pid.
+func getProcNamespaces(pid int) (map[string]uint64, error) {
+	namespaces := map[string]uint64{}
+	for _, name := range pidFileNamespaces {
+		info, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid), "ns", name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				if _, statErr := os.Stat(filepath.Join("/proc", strconv.Itoa(pid))); statErr != nil {
+					return nil, statErr
+				}
+				continue
+			}
+			return nil, err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		namespaces[name] = stat.Ino
+	}
+	return namespaces, nil
+}
+
+// GetProcNamespace returns the inode number of the given namespace of the
+// process with the given pid, as reported by /proc/<pid>/ns/<name>.
+func GetProcNamespace(pid int, name string) (uint64, error) {
+	info, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid), "ns", name))
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("unable to read %s namespace of process %d", name, pid)
+	}
+	return stat.Ino, nil
+}
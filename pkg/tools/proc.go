@@ -7,13 +7,14 @@ package tools
 
 import (
 	"bufio"
-	"fmt"
+	"errors"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 // GetSubIDRanges returns the subuid and subgid ranges for the current user.
@@ -104,35 +105,81 @@ func readSubIDFile(filename, username string) (subIDSlice []string, err error) {
 	return
 }
 
-func GetPidFromEnv(envVar string) (int, error) {
-	// Scan /proc for numeric directories
-	dirs, err := os.ReadDir("/proc")
+// ForwardSignals relays the given signals received by the current process
+// to the given process, until the returned stop function is called. The
+// current process is not terminated by the relayed signals.
+func ForwardSignals(process *os.Process, sigs ...os.Signal) (stop func()) {
+	sigCh := make(chan os.Signal, 8)
+	doneCh := make(chan struct{})
+	signal.Notify(sigCh, sigs...)
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				_ = process.Signal(sig)
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(doneCh)
+	}
+}
+
+// ExitCode returns the exit code of a terminated process, following the
+// shell convention of reporting 128+N for a process killed by signal N.
+// The second return value is false if err does not carry an exit status.
+func ExitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		return 128 + int(status.Signal()), true
+	}
+	return exitErr.ExitCode(), true
+}
+
+// IsUserOwnedID checks if the given host uid belongs to the current user,
+// either being the user uid or falling in one of the user subordinate uid
+// ranges listed in /etc/subuid. Processes running as a non-root user in a
+// rootless container are seen by the host with a subordinate uid.
+func IsUserOwnedID(uid uint32) bool {
+	if int(uid) == os.Getuid() {
+		return true
+	}
+
+	curUser, err := user.Current()
+	if err != nil {
+		return false
+	}
+
+	file, err := os.Open("/etc/subuid")
 	if err != nil {
-		return 0, fmt.Errorf("failed to read /proc: %w", err)
+		return false
 	}
+	defer file.Close()
 
-	for _, d := range dirs {
-		if !d.IsDir() {
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(parts) != 3 || (parts[0] != curUser.Username && parts[0] != curUser.Uid) {
 			continue
 		}
-		pid, err := strconv.Atoi(d.Name())
-		if err != nil {
-			continue // not a PID dir
-		}
-
-		envPath := filepath.Join("/proc", d.Name(), "environ")
-		data, err := os.ReadFile(envPath)
-		if err != nil {
+		start, errStart := strconv.ParseUint(parts[1], 10, 32)
+		count, errCount := strconv.ParseUint(parts[2], 10, 32)
+		if errStart != nil || errCount != nil {
 			continue
 		}
-
-		// environ entries are '\x00'-separated
-		envEntries := strings.Split(string(data), "\x00")
-		for _, e := range envEntries {
-			if e == envVar {
-				return pid, nil
-			}
+		if uint64(uid) >= start && uint64(uid) < start+count {
+			return true
 		}
 	}
-	return 0, fmt.Errorf("no process with env var %s found", envVar)
+	return false
 }
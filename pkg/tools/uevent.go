@@ -0,0 +1,102 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ueventKernelGroup is the netlink multicast group of the uevents sent by
+// the kernel, which unprivileged processes are allowed to join.
+const ueventKernelGroup = 1
+
+// Uevent is a device event sent by the kernel.
+type Uevent struct {
+	// Action is the kind of event, e.g. add, remove or change.
+	Action string
+
+	// DevPath is the path of the device in /sys.
+	DevPath string
+
+	// Subsystem is the kernel subsystem of the device.
+	Subsystem string
+
+	// DevName is the path of the device node relative to /dev, empty for
+	// devices without a node.
+	DevName string
+}
+
+// WatchUevents subscribes to the kernel device events and sends them to the
+// returned channel. An error is returned if the netlink socket cannot be
+// opened, e.g. in a network namespace without access to the uevents.
+func WatchUevents() (<-chan Uevent, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uevent socket: %w", err)
+	}
+	err = unix.Bind(fd, &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: ueventKernelGroup,
+	})
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to bind uevent socket: %w", err)
+	}
+
+	events := make(chan Uevent)
+	go func() {
+		defer unix.Close(fd)
+		defer close(events)
+
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err == unix.EINTR || err == unix.ENOBUFS {
+				// ENOBUFS means events were dropped, the following ones
+				// are still delivered
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if event, ok := parseUevent(buf[:n]); ok {
+				events <- event
+			}
+		}
+	}()
+	return events, nil
+}
+
+// parseUevent parses a kernel uevent message, made of an ACTION@DEVPATH
+// header followed by KEY=VALUE properties, all NUL terminated.
+func parseUevent(msg []byte) (event Uevent, ok bool) {
+	fields := bytes.Split(msg, []byte{0})
+	if len(fields) == 0 || !bytes.Contains(fields[0], []byte("@")) {
+		return event, false
+	}
+
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(string(field), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "ACTION":
+			event.Action = value
+		case "DEVPATH":
+			event.DevPath = value
+		case "SUBSYSTEM":
+			event.Subsystem = value
+		case "DEVNAME":
+			event.DevName = value
+		}
+	}
+	return event, event.Action != ""
+}
@@ -0,0 +1,45 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+import "gorm.io/gorm"
+
+// Alias is a command of the managed bin directory set by the user, it runs
+// a binary of the given application with default arguments, taking
+// priority over the binaries exported by the installed applications.
+type Alias struct {
+	gorm.Model
+	Name   string `gorm:"uniqueIndex;not null"`
+	Origin string `gorm:"not null"`
+
+	// Branch, Commit and Release select the installed version of the
+	// application, the most recently installed one is used if all empty.
+	Branch  string
+	Commit  string
+	Release string
+
+	// Binary is the binary run by the alias, the one of the application
+	// named after the alias if empty.
+	Binary string
+	Args   []string `gorm:"serializer:json"`
+}
+
+// Command is a command of the managed bin directory, along with the
+// applications providing a binary with the same name.
+type Command struct {
+	Name    string   `json:"name"`
+	Origin  string   `json:"origin"`
+	Version string   `json:"version"`
+	Binary  string   `json:"binary"`
+	Args    []string `json:"args"`
+
+	// Alias tells whether the command was set by the user.
+	Alias bool `json:"alias"`
+
+	// Alternatives are the origins of the other installed applications
+	// exporting a binary with the same name.
+	Alternatives []string `json:"alternatives"`
+}
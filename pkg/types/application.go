@@ -55,6 +55,10 @@ type Application struct {
 	// Config is the configuration of the application.
 	Config string
 
+	// DerivedFrom is the CpakId of the application this one was committed
+	// from, it is empty for applications installed from a remote.
+	DerivedFrom string
+
 	// Containers is the list of containers created for the application.
 	Containers []Container `gorm:"foreignKey:ApplicationCpakId;references:CpakId"`
 
@@ -77,13 +81,28 @@ type Application struct {
 	// ParsedOverride is a set of permissions
 	ParsedOverride Override `gorm:"-"`
 
+	// ParsedInfo is the metadata of the application shown by software
+	// centers.
+	ParsedInfo AppInfo `gorm:"-"`
+
+	// ParsedServices is the list of services of the application.
+	ParsedServices []Service `gorm:"-"`
+
+	// ParsedDBusServices is the list of D-Bus services of the application.
+	ParsedDBusServices []DBusService `gorm:"-"`
+
 	// Raw fields
 	DependenciesRaw string
 	OverrideRaw     string
+	ServicesRaw     string
+	DBusServicesRaw string
+	InfoRaw         string
 }
 
 func (a Application) SourceType() string {
 	switch {
+	case a.DerivedFrom != "":
+		return "derived"
 	case a.Branch != "":
 		return "branch"
 	case a.Release != "":
@@ -0,0 +1,52 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+import "time"
+
+const (
+	// AuditEventHostExec is recorded for every command requested to the
+	// hostexec server of a container.
+	AuditEventHostExec = "hostexec"
+
+	// AuditEventNestedRun is recorded for every nested run request.
+	AuditEventNestedRun = "nested-run"
+
+	// AuditEventOverride is recorded when the user changes an override.
+	AuditEventOverride = "override"
+
+	// AuditEventMount is recorded for every host path mounted in a
+	// container because of its override.
+	AuditEventMount = "mount"
+)
+
+const (
+	AuditDecisionAllowed = "allowed"
+	AuditDecisionDenied  = "denied"
+)
+
+// AuditEvent is an entry of the audit log, recording an event crossing the
+// container sandbox.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+
+	// AppId is the CpakId of the application the event belongs to, it is
+	// empty for events originated on the host.
+	AppId string `json:"app_id"`
+
+	// ContainerId is the CpakId of the container the event belongs to,
+	// if any.
+	ContainerId string `json:"container_id,omitempty"`
+
+	// Type is one of the AuditEvent* constants.
+	Type string `json:"type"`
+
+	// Decision is one of the AuditDecision* constants.
+	Decision string `json:"decision"`
+
+	// Detail describes the event, e.g. the command or the mounted path.
+	Detail string `json:"detail,omitempty"`
+}
@@ -0,0 +1,82 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+// CatalogSource is a catalog of applications, a JSON index listing their
+// origins, metadata and available versions.
+type CatalogSource struct {
+	// Name is the name of the catalog, e.g. corp.
+	Name string `json:"name" gorm:"primaryKey"`
+
+	// Url is the HTTP(S) URL or the local path of the index, its detached
+	// signature is read from the same location with the .sig suffix.
+	Url string `json:"url" gorm:"not null"`
+
+	// PublicKey is the ed25519 public key the index is signed with, encoded
+	// in base64, either raw or in the PKIX DER form. It is only optional
+	// for the local indexes added as Insecure.
+	PublicKey string `json:"public_key"`
+
+	// Insecure allows a local index without PublicKey, which is then not
+	// verified. Remote indexes must always be signed.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Builtin is set for the catalogs of the configuration file, which
+	// cannot be removed with cpak remote.
+	Builtin bool `json:"builtin,omitempty" gorm:"-"`
+}
+
+// CatalogIndex is the index of a catalog.
+type CatalogIndex struct {
+	Apps []CatalogApp `json:"apps"`
+}
+
+// CatalogApp is an application listed in a catalog.
+type CatalogApp struct {
+	// Name is the short name the application is installed by, e.g.
+	// myeditor.
+	Name string `json:"name"`
+
+	// Origin is the repository of the application, e.g.
+	// github.com/example/myeditor.
+	Origin string `json:"origin"`
+
+	// Description is the short description of the application.
+	Description string `json:"description"`
+
+	AppMetadata
+
+	// Versions are the available versions of the application, the first
+	// one is installed when none is given.
+	Versions []CatalogVersion `json:"versions"`
+
+	// Catalog is the name of the catalog listing the application, it is
+	// set by cpak when reading the index.
+	Catalog string `json:"catalog,omitempty"`
+}
+
+// CatalogVersion is an available version of an application, only one of
+// Branch, Release and Commit is set.
+type CatalogVersion struct {
+	Version string `json:"version,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Release string `json:"release,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+// String returns the version, or its branch, release or commit if it has
+// no version.
+func (v CatalogVersion) String() string {
+	switch {
+	case v.Version != "":
+		return v.Version
+	case v.Branch != "":
+		return v.Branch
+	case v.Release != "":
+		return v.Release
+	}
+	return v.Commit
+}
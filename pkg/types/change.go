@@ -0,0 +1,27 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+const (
+	// FileAdded marks a file which does not exist in the application layers.
+	FileAdded = "A"
+
+	// FileChanged marks a file which replaces one in the application layers.
+	FileChanged = "C"
+
+	// FileDeleted marks a file of the application layers which was removed.
+	FileDeleted = "D"
+)
+
+// FileChange is a change made to the filesystem of a persistent container,
+// compared to the layers of its application.
+type FileChange struct {
+	// Kind is one of FileAdded, FileChanged or FileDeleted.
+	Kind string `json:"kind"`
+
+	// Path is the absolute path of the file inside the container.
+	Path string `json:"path"`
+}
@@ -32,6 +32,11 @@ type Container struct {
 	// actual workdir for the layer mounts.
 	StatePath string
 
+	// Persistent containers keep their upper layer, and their record, when
+	// stopped, they are started again with their changes until these are
+	// committed or the application is removed.
+	Persistent bool
+
 	// HostExecPid is the PID of the 'cpak hostexec-server' process running on the host for this container.
 	HostExecPid int
 
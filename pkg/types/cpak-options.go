@@ -36,6 +36,26 @@ type CpakOptions struct {
 	// the downloaded images and unpacked layers.
 	CachePath string `json:"cache_path"`
 
+	// Runtime is the backend used to create the containers namespaces and
+	// to run processes inside them, one of the Runtime* constants. It
+	// defaults to rootlesskit when embedded in the cpak binary, otherwise
+	// to unshare.
+	Runtime string `json:"runtime"`
+
+	// MountMethod is the mechanism used to assemble the containers root
+	// filesystem from the image layers, one of the MountMethod* constants.
+	// When set to "auto" (the default), the methods are tried in order:
+	// kernel overlay, fuse-overlayfs and finally a copy of the layers.
+	MountMethod string `json:"mount_method"`
+
+	// NestedRunPrompt asks the desktop user to confirm nested runs which
+	// are not allowed by the parent application, instead of denying them.
+	NestedRunPrompt bool `json:"nested_run_prompt"`
+
+	// Sources are the catalogs the applications are searched in and
+	// installed by name from, along with the ones added with cpak remote.
+	Sources []CatalogSource `json:"sources"`
+
 	// DaBaDeeStoreopts is the configuration for the DaBaDee store.
 	DaBaDeeStoreOptions storage.StorageOptions `json:"dabadee_store"`
 
@@ -47,3 +67,44 @@ type CpakOptions struct {
 	RotlesskitBinPath   string `json:"rootlesskit_bin_path"`
 	NsenterBinPath      string `json:"nsenter_bin_path"`
 }
+
+const (
+	// RuntimeRootlesskit creates the namespaces using rootlesskit, which is
+	// embedded in the cpak binary unless built with the norootlesskit tag.
+	RuntimeRootlesskit = "rootlesskit"
+
+	// RuntimeUnshare creates the namespaces directly from cpak, it requires
+	// no external binaries but the kernel must allow unprivileged user
+	// namespaces.
+	RuntimeUnshare = "unshare"
+
+	// RuntimeBwrap creates the namespaces using bubblewrap, which must be
+	// installed on the host.
+	RuntimeBwrap = "bwrap"
+)
+
+const (
+	// MountMethodAuto picks the first mount method supported by the host.
+	MountMethodAuto = "auto"
+
+	// MountMethodOverlay uses the kernel overlay filesystem, it requires
+	// unprivileged overlay mounts support (kernel 5.11 or newer).
+	MountMethodOverlay = "overlay"
+
+	// MountMethodFuseOverlayfs uses the fuse-overlayfs binary, it requires
+	// fuse-overlayfs to be installed on the host and /dev/fuse access.
+	MountMethodFuseOverlayfs = "fuse-overlayfs"
+
+	// MountMethodCopy snapshots the layers into the container root, using
+	// reflinks when the filesystem supports them. It always works but it is
+	// the slowest method when reflinks are not available.
+	MountMethodCopy = "copy"
+)
+
+// MountMethods is the list of the mount methods tried, in order, when the
+// MountMethodAuto method is selected.
+var MountMethods = []string{
+	MountMethodOverlay,
+	MountMethodFuseOverlayfs,
+	MountMethodCopy,
+}
@@ -0,0 +1,88 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// deviceSubsystemRegexp and deviceIdsRegexp match the two parts of the
+// device rules, PRODUCT can be * to match any product of the vendor.
+var (
+	deviceSubsystemRegexp = regexp.MustCompile(`^[a-z0-9_\-]+$`)
+	deviceIdsRegexp       = regexp.MustCompile(`^([0-9a-f]{4}):([0-9a-f]{4}|\*)$`)
+)
+
+// Device is a device node of the host, with the properties device rules
+// are matched against.
+type Device struct {
+	// Node is the path of the device node, e.g. /dev/ttyACM0.
+	Node string
+
+	// Subsystem is the kernel subsystem of the device, e.g. tty or
+	// video4linux.
+	Subsystem string
+
+	// Vendor and Product are the USB identifiers of the device, if it is
+	// connected through USB, as 4 lowercase hex digits.
+	Vendor  string
+	Product string
+}
+
+// DeviceRule selects the host devices exposed to a container, all the set
+// fields must match.
+type DeviceRule struct {
+	Subsystem string `json:"subsystem,omitempty"`
+	Vendor    string `json:"vendor,omitempty"`
+	Product   string `json:"product,omitempty"`
+}
+
+// ParseDeviceRule parses a device rule in the SUBSYSTEM, VENDOR:PRODUCT or
+// SUBSYSTEM/VENDOR:PRODUCT form, e.g. video4linux, 0483:374b or tty/0483:*.
+func ParseDeviceRule(rule string) (r DeviceRule, err error) {
+	invalid := fmt.Errorf("invalid device rule %q, expected SUBSYSTEM, VENDOR:PRODUCT or SUBSYSTEM/VENDOR:PRODUCT", rule)
+
+	subsystem, ids, found := strings.Cut(strings.ToLower(rule), "/")
+	if !found && strings.Contains(subsystem, ":") {
+		subsystem, ids = "", subsystem
+	}
+	if subsystem != "" && !deviceSubsystemRegexp.MatchString(subsystem) {
+		return r, invalid
+	}
+	r.Subsystem = subsystem
+
+	if found || ids != "" {
+		m := deviceIdsRegexp.FindStringSubmatch(ids)
+		if m == nil {
+			return r, invalid
+		}
+		r.Vendor = m[1]
+		if m[2] != "*" {
+			r.Product = m[2]
+		}
+	}
+
+	if r == (DeviceRule{}) {
+		return r, invalid
+	}
+	return r, nil
+}
+
+// Matches reports whether the given device is selected by the rule.
+func (r DeviceRule) Matches(d Device) bool {
+	if r.Subsystem != "" && r.Subsystem != d.Subsystem {
+		return false
+	}
+	if r.Vendor != "" && r.Vendor != d.Vendor {
+		return false
+	}
+	if r.Product != "" && r.Product != d.Product {
+		return false
+	}
+	return true
+}
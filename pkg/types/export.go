@@ -0,0 +1,47 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+import "gorm.io/gorm"
+
+// The kinds of the files exported by cpak outside the store.
+const (
+	ExportBinary       = "binary"
+	ExportDesktopEntry = "desktop-entry"
+	ExportIcon         = "icon"
+	ExportMimePackage  = "mime-package"
+	ExportCommand      = "command"
+	ExportCompletion   = "completion"
+	ExportManPage      = "man-page"
+	ExportSystemdUnit  = "systemd-unit"
+	ExportDBusService  = "dbus-service"
+)
+
+// ExportedFile is a file written by cpak outside the store for an
+// application, e.g. a desktop entry, so that it can be removed, verified
+// and regenerated exactly.
+type ExportedFile struct {
+	gorm.Model
+	AppCpakId string `gorm:"index;not null"`
+	Path      string `gorm:"uniqueIndex;not null"`
+	Kind      string
+
+	// Source is the binary or desktop entry of the manifest the file was
+	// exported for, icons and MIME packages refer to their desktop entry,
+	// commands, completions and man pages to their binary, systemd units
+	// and D-Bus services to their name in the manifest.
+	Source string
+
+	// Hash is the sha256 digest of the content written by cpak, a file
+	// with a different content was modified by the user.
+	Hash string
+
+	// SharedWith is the comma separated list of the CpakIds of the other
+	// installed versions of the same application which export the file with
+	// the same content, one of them takes it over when it is removed for
+	// AppCpakId.
+	SharedWith string
+}
@@ -0,0 +1,71 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+import "gorm.io/gorm"
+
+// The kinds of the GPU driver files injected in the containers.
+const (
+	// GpuFileLibrary is a shared library, listed in the ld.so cache.
+	GpuFileLibrary = "library"
+	// GpuFileModule is a driver module loaded by path, e.g. a DRI driver.
+	GpuFileModule = "module"
+	GpuFileConfig = "config"
+	GpuFileBinary = "binary"
+	GpuFileDevice = "device"
+	// GpuFileMount is any other path bound by a CDI spec.
+	GpuFileMount = "mount"
+)
+
+// The values of the gpuDrivers override key.
+const (
+	// GpuDriversAuto injects the host drivers which are known to work
+	// with any image, i.e. the NVIDIA ones, since images cannot ship them.
+	GpuDriversAuto = "auto"
+	// GpuDriversHost injects all the host drivers, replacing the image
+	// ones, e.g. when the image Mesa is too old for the host GPU.
+	GpuDriversHost = "host"
+	// GpuDriversImage only uses the drivers shipped with the image.
+	GpuDriversImage = "image"
+)
+
+// GpuFile is a file of the host GPU driver, bound in the containers.
+type GpuFile struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+
+	// Target is the path of the file in the containers, if it differs from
+	// Path.
+	Target string `json:"target,omitempty"`
+
+	// Soname is the SONAME of the library, for library files.
+	Soname string `json:"soname,omitempty"`
+
+	// Dependency marks the libraries which are not part of the driver but
+	// which it depends on, e.g. libstdc++, only bound if the image lacks
+	// them.
+	Dependency bool `json:"dependency,omitempty"`
+}
+
+// GpuDriver is the result of the discovery of a host GPU driver, cached in
+// the store until the driver or the scanned paths change.
+type GpuDriver struct {
+	gorm.Model
+	// Vendor is the name of the driver, e.g. nvidia.
+	Vendor string `gorm:"uniqueIndex;not null"`
+
+	// Version is the version of the loaded kernel driver.
+	Version string
+
+	// Key identifies the state of the host the discovery is based on, it
+	// is made of the driver version and the mtimes of the scanned paths.
+	Key string
+
+	// Files are the driver files to inject in the containers, stored as
+	// JSON in FilesRaw.
+	FilesRaw    string
+	ParsedFiles []GpuFile `gorm:"-"`
+}
@@ -13,4 +13,88 @@ type HostExecRequest struct {
 	Width uint16 `json:"width"`
 	// Height is the initial terminal height for PTY setup.
 	Height uint16 `json:"height"`
+	// Env is the client environment, only the variables listed in the
+	// command policy are passed to the host command.
+	Env []string `json:"env,omitempty"`
+	// Cwd is the client working directory, the host command runs in the
+	// matching host directory when it is shared with the host.
+	Cwd string `json:"cwd,omitempty"`
+}
+
+// HostCommandPolicy restricts how an allowed host command can be invoked
+// from the container. A request is denied as soon as one of its arguments
+// breaks one of the rules.
+type HostCommandPolicy struct {
+	// Subcommands is the list of subcommands which can be run, when not
+	// empty. The subcommand is the first argument not starting with "-",
+	// so the flags before it cannot take a separate value.
+	Subcommands []string `json:"subcommands,omitempty" jsonschema:"description=Subcommands which can be run"`
+
+	// AllowedArgs is the list of patterns every argument must match, when
+	// not empty. Patterns prefixed with "re:" are regular expressions which
+	// must match the whole argument, the others are shell globs.
+	AllowedArgs []string `json:"allowedArgs,omitempty" jsonschema:"description=Patterns every argument must match (globs or re: prefixed regular expressions)"`
+
+	// ForbiddenFlags is the list of flags which cannot be passed, in any of
+	// their forms: "-c value", "-cvalue" and "--flag=value". Abbreviated long
+	// flags are only matched when listed too, e.g. "--upload-pa".
+	ForbiddenFlags []string `json:"forbiddenFlags,omitempty" jsonschema:"description=Flags which cannot be passed to the command"`
+
+	// ValueFlags is the list of short flags taking a value, which can be
+	// attached as in "-C/dir" and is then checked and translated as the
+	// other arguments. Other short flags with a path attached are refused.
+	ValueFlags []string `json:"valueFlags,omitempty" jsonschema:"description=Short flags taking a value which can be attached to them"`
+
+	// AllowedSchemes is the list of URL schemes accepted for the arguments
+	// which are URLs, when not empty.
+	AllowedSchemes []string `json:"allowedSchemes,omitempty" jsonschema:"description=URL schemes accepted in the arguments"`
+
+	// EnvPassthrough is the list of container environment variables passed
+	// to the host command.
+	EnvPassthrough []string `json:"envPassthrough,omitempty" jsonschema:"description=Container environment variables passed to the host command"`
+
+	// Args is the list of arguments always passed to the host command,
+	// before the requested ones.
+	Args []string `json:"args,omitempty" jsonschema:"description=Arguments passed to the host command before the requested ones"`
+
+	// Env is the list of environment variables always set for the host
+	// command, taking precedence over the passed through ones.
+	Env []string `json:"env,omitempty" jsonschema:"description=Environment variables set for the host command,items.pattern=^[A-Za-z_][A-Za-z0-9_]*=.*$"`
+}
+
+// DefaultHostCommandPolicies are the policies applied to the host commands
+// the override does not define a policy for.
+var DefaultHostCommandPolicies = map[string]HostCommandPolicy{
+	"xdg-open": {
+		AllowedSchemes: []string{"http", "https", "mailto", "file"},
+	},
+	// git runs in the host copy of a repository the container can write
+	// to, so only the common subcommands are allowed and the settings of
+	// the repository which run commands are replaced. Aliases are not
+	// subcommands, so they cannot run either.
+	"git": {
+		Subcommands: []string{
+			"add", "blame", "branch", "checkout", "clone", "commit", "diff",
+			"fetch", "init", "log", "ls-files", "mv", "pull", "push", "remote",
+			"restore", "rev-parse", "rm", "show", "stash", "status", "switch",
+			"tag",
+		},
+		ForbiddenFlags: []string{
+			"-c", "--config", "--config-env", "--exec-path", "--upload-pack", "--receive-pack", "-u",
+			"--template", "--separate-git-dir", "--ext-diff", "--textconv",
+		},
+		Args: []string{
+			"-c", "core.hooksPath=/dev/null",
+			"-c", "core.fsmonitor=false",
+			"-c", "core.sshCommand=ssh",
+			"-c", "core.pager=cat",
+			"-c", "core.editor=false",
+			"-c", "sequence.editor=false",
+			"-c", "gpg.program=gpg",
+			"-c", "credential.helper=",
+			"-c", "protocol.ext.allow=never",
+		},
+		ValueFlags: []string{"-m", "-F"},
+		Env:        []string{"GIT_CONFIG_NOSYSTEM=1"},
+	},
 }
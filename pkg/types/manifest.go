@@ -5,6 +5,8 @@
  */
 package types
 
+import "encoding/json"
+
 // CpakManifest is the struct that represents the manifest of an application.
 type CpakManifest struct {
 	// ManifestVersion is the version of the manifest schema (e.g. "1.0").
@@ -17,6 +19,10 @@ type CpakManifest struct {
 	// as concise as possible.
 	Description string `json:"description" jsonschema:"minLength=1,description=Short application description"`
 
+	// AppMetadata is the optional information shown by software centers,
+	// the ones missing are read from the AppStream metainfo of the image.
+	AppMetadata
+
 	// Version is the version of the application.
 	Version string `json:"version" jsonschema:"pattern=^v?[0-9]+(\\.[0-9]+)*(?:[-+][0-9A-Za-z.-]+)?$,description=Semver-like version"`
 
@@ -25,11 +31,19 @@ type CpakManifest struct {
 	Image string `json:"image" jsonschema:"pattern=^[a-z0-9]+(?:[._-][a-z0-9]+)*/[A-Za-z0-9._-]+(?::[A-Za-z0-9._-]+)?$,description=OCI image reference"`
 
 	// Binaries is the list of exported binaries of the application.
-	Binaries []string `json:"binaries" jsonschema:"minItems=1,items.pattern=^/,description=Absolute paths to binaries"`
+	Binaries []string `json:"binaries" jsonschema:"minItems=1,pattern=^/[^\\x00-\\x1f\\x7f]*$,description=Absolute paths to binaries"`
 
 	// DesktopEntries is the list of exported desktop entries of the application.
 	DesktopEntries []string `json:"desktop_entries" jsonschema:"items.pattern=.+\\.desktop$,description=.desktop entry files"`
 
+	// Services is the list of long-running binaries of the application,
+	// exported as systemd user units.
+	Services []Service `json:"services,omitempty" jsonschema:"description=Long-running services"`
+
+	// DBusServices is the list of session D-Bus names the application can
+	// be activated by.
+	DBusServices []DBusService `json:"dbus_services,omitempty" jsonschema:"description=D-Bus activatable services"`
+
 	// Dependencies is the list of dependencies of the application, it is
 	// expected to be a list of origin repositories.
 	//
@@ -49,4 +63,33 @@ type CpakManifest struct {
 	// application, even if this is called "override", it is also used to
 	// set the default permissions.
 	Override Override `json:"override" jsonschema:"description=Permissions override settings"`
+
+	// RawOverride holds the keys set by the override of a decoded manifest,
+	// so that the keys left out are told apart from the ones set to their
+	// zero value. It is nil for the manifests built in code, whose Override
+	// is used as a whole.
+	RawOverride map[string]json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes the manifest, keeping the keys set by its override
+// in RawOverride.
+func (m *CpakManifest) UnmarshalJSON(data []byte) error {
+	type plainManifest CpakManifest
+	var raw struct {
+		Override map[string]json.RawMessage `json:"override"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, (*plainManifest)(m))
+	if err != nil {
+		return err
+	}
+
+	m.RawOverride = raw.Override
+	if m.RawOverride == nil {
+		m.RawOverride = map[string]json.RawMessage{}
+	}
+	return nil
 }
@@ -0,0 +1,53 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+// AppMetadata is the optional information about an application shown by
+// software centers, it follows the AppStream specification.
+type AppMetadata struct {
+	// Summary is a one-line description of the application.
+	Summary string `json:"summary,omitempty" jsonschema:"description=One-line summary"`
+
+	// License is the SPDX license expression of the application.
+	License string `json:"license,omitempty" jsonschema:"description=SPDX license expression"`
+
+	// Homepage is the URL of the application website.
+	Homepage string `json:"homepage,omitempty" jsonschema:"format=uri,description=Homepage URL"`
+
+	// Categories are the freedesktop.org main and additional categories of
+	// the application, e.g. Network.
+	Categories []string `json:"categories,omitempty" jsonschema:"description=freedesktop.org categories"`
+
+	// Keywords are additional search terms for the application.
+	Keywords []string `json:"keywords,omitempty" jsonschema:"description=Search keywords"`
+
+	// Screenshots are shown by software centers, the first one is the
+	// default.
+	Screenshots []Screenshot `json:"screenshots,omitempty" jsonschema:"description=Screenshots"`
+
+	// ContentRating maps the OARS 1.1 attributes, e.g. social-chat, to
+	// their intensity: none, mild, moderate or intense.
+	ContentRating map[string]string `json:"content_rating,omitempty" jsonschema:"description=OARS 1.1 content rating"`
+}
+
+// Screenshot is a screenshot of an application.
+type Screenshot struct {
+	Url     string `json:"url" jsonschema:"format=uri,description=Image URL"`
+	Caption string `json:"caption,omitempty" jsonschema:"description=Short caption"`
+}
+
+// AppInfo is the metadata of an installed application, merged from its
+// manifest and from the AppStream metainfo file shipped in its image.
+type AppInfo struct {
+	AppMetadata
+
+	// Id is the AppStream component id, e.g. org.example.App.
+	Id string `json:"id,omitempty"`
+
+	// Description is the long description of the application, as plain
+	// text with paragraphs separated by blank lines.
+	Description string `json:"description,omitempty"`
+}
@@ -6,6 +6,8 @@
 package types
 
 type Override struct {
+	Extends string `json:"extends,omitempty" jsonschema:"description=Permission profile the override is based on,pattern=^[a-z0-9][a-z0-9_\\-]*$"`
+
 	SocketX11        bool `json:"socketX11" jsonschema:"description=Mount /tmp/.X11-unix/,default=true" flag:"socketX11,bool"`
 	SocketWayland    bool `json:"socketWayland" jsonschema:"description=Mount Wayland socket,default=true" flag:"socketWayland,bool"`
 	SocketPulseAudio bool `json:"socketPulseAudio" jsonschema:"description=Mount PulseAudio socket,default=true" flag:"socketPulseAudio,bool"`
@@ -27,6 +29,11 @@ type Override struct {
 	DeviceUsb   bool `json:"deviceUsb" jsonschema:"description=Expose USB devices,default=false" flag:"deviceUsb,bool"`
 	DeviceAll   bool `json:"deviceAll" jsonschema:"description=Expose all /dev,default=false" flag:"deviceAll,bool"`
 
+	GpuDrivers string   `json:"gpuDrivers" jsonschema:"description=GPU drivers used: auto (host NVIDIA drivers) or host or image,enum=auto,enum=host,enum=image,default=auto" flag:"gpuDrivers,enum,auto|host|image"`
+	CdiDevices []string `json:"cdiDevices" jsonschema:"description=CDI devices exposed e.g. nvidia.com/gpu=all,items.pattern=^[a-z0-9.\\-]+/[a-z0-9.\\-_]+=[A-Za-z0-9._\\-:]+$,minItems=0" flag:"cdiDevices,strings"`
+
+	DeviceRules []string `json:"deviceRules" jsonschema:"description=Devices exposed also when plugged in while running: SUBSYSTEM or VENDOR:PRODUCT or SUBSYSTEM/VENDOR:PRODUCT,items.pattern=^(?:[a-z0-9_\\-]+|(?:[a-z0-9_\\-]+/)?[0-9a-fA-F]{4}:(?:[0-9a-fA-F]{4}|\\*))$,minItems=0" flag:"deviceRules,strings"`
+
 	Notification bool `json:"notification" jsonschema:"description=Enable desktop notifications,default=false" flag:"notification,bool"`
 
 	FsHost     bool     `json:"fsHost" jsonschema:"description=Mount host root read-only,default=false" flag:"fsHost,bool"`
@@ -35,12 +42,18 @@ type Override struct {
 	FsExtra    []string `json:"fsExtra" jsonschema:"description=Additional paths to mount,items.pattern=^(?:\\./|\\../|/)?(?:[A-Za-z0-9_\\-\\.]+/)*[A-Za-z0-9_\\-\\.]+$,minItems=0" flag:"fsExtra,strings"`
 
 	Env     []string `json:"env" jsonschema:"description=Additional environment variables,items.pattern=^[A-Za-z_][A-Za-z0-9_]*=.+$,minItems=0" flag:"env,strings"`
-	Network bool     `json:"network" jsonschema:"description=Enable network namespace,default=true" flag:"network,bool"`
+	Network bool     `json:"network" jsonschema:"description=Allow network access,default=true" flag:"network,bool"`
 	Process bool     `json:"process" jsonschema:"description=Share host process namespace,default=false" flag:"process,bool"`
 
 	AsRoot bool `json:"asRoot" jsonschema:"description=Run as root inside container,default=false" flag:"asRoot,bool"`
 
+	Persistent bool `json:"persistent" jsonschema:"description=Keep the container changes across restarts,default=false" flag:"persistent,bool"`
+
 	AllowedHostCommands []string `json:"allowedHostCommands" jsonschema:"description=Host commands allowed via shim,items.pattern=^[A-Za-z0-9_\\-]+$,minItems=0" flag:"allowedHostCommands,strings"`
+
+	HostCommandPolicies map[string]HostCommandPolicy `json:"hostCommandPolicies,omitempty" jsonschema:"description=Argument and environment policies for the allowed host commands"`
+
+	NestedRun []string `json:"nestedRun" jsonschema:"description=Origins of the applications allowed to be run from the container,minItems=0" flag:"nestedRun,strings"`
 }
 
 func NewOverride() Override {
@@ -58,6 +71,9 @@ func NewOverride() Override {
 		DeviceKvm:           true,
 		DeviceShm:           true,
 		DeviceAll:           false,
+		GpuDrivers:          GpuDriversAuto,
+		CdiDevices:          []string{},
+		DeviceRules:         []string{},
 		FsHost:              false,
 		FsHostEtc:           false,
 		FsHostHome:          true,
@@ -66,6 +82,8 @@ func NewOverride() Override {
 		Network:             true,
 		Process:             false,
 		AsRoot:              false,
+		Persistent:          false,
 		AllowedHostCommands: []string{},
+		NestedRun:           []string{},
 	}
 }
@@ -0,0 +1,47 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+// PermissionRisk rates how much a permission weakens the sandbox, higher
+// values are riskier.
+type PermissionRisk int
+
+const (
+	// PermissionRiskLow marks permissions common to desktop applications.
+	PermissionRiskLow PermissionRisk = iota
+
+	// PermissionRiskMedium marks permissions exposing user data or
+	// hardware.
+	PermissionRiskMedium
+
+	// PermissionRiskHigh marks permissions which let the application
+	// escape the sandbox or act on behalf of the user.
+	PermissionRiskHigh
+)
+
+func (r PermissionRisk) String() string {
+	switch r {
+	case PermissionRiskHigh:
+		return "dangerous"
+	case PermissionRiskMedium:
+		return "sensitive"
+	default:
+		return "common"
+	}
+}
+
+// PermissionChange is a permission requested by an application on top of
+// the ones it would get anyway.
+type PermissionChange struct {
+	// Key is the override key, as used in the manifest.
+	Key string `json:"key"`
+
+	// Value is the requested list or map entry, empty for booleans.
+	Value string `json:"value,omitempty"`
+
+	Description string         `json:"description"`
+	Risk        PermissionRisk `json:"risk"`
+}
@@ -0,0 +1,19 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+// OverridePolicy is the system policy set by the administrator, it is
+// applied on top of every other override layer and can only take
+// permissions away.
+type OverridePolicy struct {
+	// Deny lists the override keys which are always disabled: booleans
+	// are set to false, lists and maps are emptied.
+	Deny []string `json:"deny" jsonschema:"description=Override keys always disabled"`
+
+	// DenyValues lists, for list and map override keys, the items which
+	// are always removed. Items can be glob patterns.
+	DenyValues map[string][]string `json:"denyValues" jsonschema:"description=Items always removed from list and map override keys"`
+}
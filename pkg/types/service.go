@@ -0,0 +1,69 @@
+/*
+* Copyright (c) 2025 FABRICATORS S.R.L.
+* Licensed under the Fabricators Public Access License (FPAL) v1.0
+* See https://github.com/fabricatorsltd/FPAL for details.
+ */
+package types
+
+// The targets a service can be started with.
+const (
+	// ServiceTargetDefault starts the service with the user session.
+	ServiceTargetDefault = "default"
+
+	// ServiceTargetGraphical starts the service with the graphical session
+	// and stops it along with it, e.g. for tray applets.
+	ServiceTargetGraphical = "graphical"
+)
+
+// Service is a long-running binary of an application, exported as a
+// systemd user unit.
+type Service struct {
+	// Name identifies the service within the application.
+	Name string `json:"name" jsonschema:"pattern=^[A-Za-z0-9_.-]+$,description=Service name"`
+
+	// Description is shown by systemd along with the service status.
+	Description string `json:"description,omitempty" jsonschema:"pattern=^[^\\x00-\\x1f\\x7f]*$,description=Service description"`
+
+	// Exec is the binary to run, followed by its arguments.
+	Exec []string `json:"exec" jsonschema:"minItems=1,pattern=^[^\\x00-\\x1f\\x7f]*$,description=Absolute path to the binary followed by its arguments"`
+
+	// Restart is the systemd restart policy, on-failure if empty.
+	Restart string `json:"restart,omitempty" jsonschema:"enum=no,enum=on-failure,enum=always,description=Restart policy"`
+
+	// Target is the session the service is started with, one of the
+	// ServiceTarget* constants, ServiceTargetDefault if empty.
+	Target string `json:"target,omitempty" jsonschema:"enum=default,enum=graphical,description=Session the service is started with"`
+
+	// Autostart enables the service when the application is installed.
+	Autostart bool `json:"autostart,omitempty" jsonschema:"description=Enable the service on install"`
+}
+
+// DBusService is a session D-Bus name owned by an application, which is
+// started on demand when a client calls it.
+type DBusService struct {
+	// Name is the well-known bus name, e.g. org.example.App.
+	Name string `json:"name" jsonschema:"pattern=^[A-Za-z_][A-Za-z0-9_-]*(\\.[A-Za-z_][A-Za-z0-9_-]*)+$,description=Well-known bus name"`
+
+	// Exec is the binary to run, followed by its arguments.
+	Exec []string `json:"exec" jsonschema:"minItems=1,pattern=^[^\\x00-\\x1f\\x7f]*$,description=Absolute path to the binary followed by its arguments"`
+
+	// Service is the name of the service of the application which owns the
+	// bus name, if any, so that it is activated through systemd.
+	Service string `json:"service,omitempty" jsonschema:"pattern=^[A-Za-z0-9_.-]+$,description=Service owning the bus name"`
+}
+
+// ServiceStatus is the state of a service of an application, as reported
+// by systemd.
+type ServiceStatus struct {
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+
+	// Enabled is the unit file state, e.g. enabled or disabled.
+	Enabled string `json:"enabled"`
+
+	// Active is the unit state, e.g. active, inactive or failed.
+	Active string `json:"active"`
+
+	// BusNames are the D-Bus names activating the service.
+	BusNames []string `json:"busNames"`
+}